@@ -9,4 +9,5 @@ require (
 	github.com/stretchr/testify v1.5.1
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/oauth2 v0.0.0-20201208152858-08078c50e5b5
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/szabolcsgelencser/bitrise-step-argocd-template/pkg/gitops"
 )
@@ -16,13 +18,101 @@ func main() {
 	}
 }
 
-func run() error {
+func run() (err error) {
 	ctx := context.Background()
 
 	// Read gitops related config from environment.
 	cfg, err := gitops.NewConfig()
 	if err != nil {
-		return fmt.Errorf("new gitops config: %w", err)
+		// The config is invalid, so the report (without inputs) is
+		// written to the report path input as is.
+		report := gitops.NewReport(os.Getenv("report_path"), nil, gitops.EnvmanExport)
+		return report.Finish(fmt.Errorf("new gitops config: %w", err))
+	}
+
+	// The run is reported from here on, so failures of the setup are
+	// reported as well (modes which don't change the deploy repository
+	// aren't reported).
+	report := gitops.NewReport(cfg.ReportPath, cfg.ReportInputs(), gitops.EnvmanExport)
+	if cfg.Mode != gitops.ModeReplay && cfg.Mode != gitops.ModeRender && cfg.Mode != gitops.ModeVerify {
+		defer func() {
+			err = report.Finish(err)
+		}()
+	}
+
+	// Templates are re-rendered exactly as recorded by a lock file
+	// to a local folder only in replay mode.
+	if cfg.Mode == gitops.ModeReplay {
+		if err := gitops.Replay(gitops.ReplayParams{
+			LockPath:        cfg.ReplayLockPath,
+			TemplatesFolder: cfg.TemplatesFolder,
+			PartialsFolder:  cfg.PartialsFolder,
+			Vars:            cfg.Vars,
+			OutputFolder:    cfg.RenderOutputFolder,
+			ExportEnv:       gitops.EnvmanExport,
+		}); err != nil {
+			return fmt.Errorf("replay lock file: %w", err)
+		}
+		return nil
+	}
+
+	// Create templates renderer.
+	renderer := gitops.TemplatesRenderer{
+		SourceFolder:          cfg.TemplatesFolder,
+		PartialsFolder:        cfg.PartialsFolder,
+		SuffixedTemplatesOnly: cfg.SuffixedTemplatesOnly,
+		VerbatimPatterns:      cfg.VerbatimPatterns,
+		Delimiters:            cfg.Delimiters,
+		HTMLEscape:            cfg.HTMLEscape,
+		ValuesFiles:           cfg.ValuesFiles,
+		VarsFile:              cfg.VarsFile,
+		Vars:                  cfg.Vars,
+		Debug:                 cfg.Verbose,
+		DestinationFolder:     cfg.DeployFolder,
+		LockFile:              cfg.LockFile,
+		SourceCommit:          cfg.SourceCommit,
+		KustomizeResources:    cfg.KustomizeResources,
+	}
+
+	// Check variables of all templates before touching the deploy repository
+	// (templates aren't rendered in rollback, promote, update, flux and
+	// teardown modes, verify mode renders them with the locked variables
+	// instead of the inputs).
+	if cfg.Mode != gitops.ModeRollback && cfg.Mode != gitops.ModePromote &&
+		cfg.Mode != gitops.ModeUpdate && cfg.Mode != gitops.ModeFlux &&
+		cfg.Mode != gitops.ModeTeardown && cfg.Mode != gitops.ModeVerify {
+		if err := gitops.CheckVars(gitops.CheckVarsParams{
+			Templates:    renderer,
+			Environments: cfg.Environments,
+			FailOnUnused: cfg.StrictVars,
+		}); err != nil {
+			return fmt.Errorf("check template variables: %w", err)
+		}
+	}
+
+	// Rendered manifests are validated offline before they are pushed.
+	var validator *gitops.ManifestValidator
+	if cfg.ValidateManifests {
+		if validator, err = gitops.NewManifestValidator(cfg.KubernetesSchemasFolder); err != nil {
+			return fmt.Errorf("new manifest validator: %w", err)
+		}
+	}
+
+	// Templates are rendered to a local folder only in render mode.
+	if cfg.Mode == gitops.ModeRender {
+		renderer.DestinationRoot = cfg.RenderOutputFolder
+		params := gitops.RenderParams{
+			Renderer:     gitops.NewRenderer(renderer, cfg.Environments),
+			OutputFolder: cfg.RenderOutputFolder,
+			ExportEnv:    gitops.EnvmanExport,
+		}
+		if validator != nil {
+			params.Validator = validator
+		}
+		if err := gitops.Render(params); err != nil {
+			return fmt.Errorf("render templates: %w", err)
+		}
+		return nil
 	}
 
 	// Create Github client.
@@ -38,7 +128,7 @@ func run() error {
 	}
 
 	// Create local clone of the remote repository.
-	repo, err := gitops.NewRepository(ctx, gitops.NewRepositoryParams{
+	clone, err := gitops.NewRepository(ctx, gitops.NewRepositoryParams{
 		Github: gh,
 		SSHKey: sshKey,
 		Remote: gitops.RemoteConfig{
@@ -46,35 +136,161 @@ func run() error {
 			Branch: cfg.DeployBranch,
 		},
 	})
+	if err != nil {
+		return fmt.Errorf("new repository: %w", err)
+	}
 	defer func() {
-		if errs := repo.Close(ctx); errs != nil {
+		if errs := clone.Close(ctx); errs != nil {
 			for _, err := range errs {
 				log.Printf("warning: close repo resource: %s\n", err)
 			}
 		}
 	}()
-	if err != nil {
-		return fmt.Errorf("new repository: %w", err)
-	}
+	// All operations of the repository are recorded to the report.
+	repo := report.Repository(clone)
 
-	// Create templates renderer.
-	renderer := gitops.TemplatesRenderer{
-		SourceFolder:      cfg.TemplatesFolder,
-		Vars:              cfg.Vars,
-		DestinationRepo:   repo,
-		DestinationFolder: cfg.DeployFolder,
+	// Templates are rendered to a temporary folder in verify mode
+	// and compared with the deploy folders of the local clone.
+	if cfg.Mode == gitops.ModeVerify {
+		renderedRoot, err := ioutil.TempDir("", "")
+		if err != nil {
+			return fmt.Errorf("create temp dir for rendered templates: %w", err)
+		}
+		defer os.RemoveAll(renderedRoot)
+		renderer.DestinationRoot = renderedRoot
+		// Templates are rendered with the variables recorded by the lock
+		// files, then files are updated and ArgoCD applications are
+		// generated like in gitops mode.
+		verified := gitops.Renderers{gitops.RecordedRenderer{
+			Templates:    renderer,
+			Environments: cfg.Environments,
+			RepoRoot:     repo.LocalPath(),
+		}}
+		verified = append(verified, cfg.Updaters(renderedRoot)...)
+		if cfg.GeneratesArgoApplications() {
+			verified = append(verified, cfg.ArgoApplications(renderedRoot))
+		}
+		if err := gitops.Verify(gitops.VerifyParams{
+			Renderer:           verified,
+			RenderedRoot:       renderedRoot,
+			RepoRoot:           repo.LocalPath(),
+			Folders:            cfg.DeployFolders(),
+			FailOnDrift:        cfg.VerifyFailOnDrift,
+			ExportEnv:          gitops.EnvmanExport,
+			IgnoreChartVersion: cfg.BumpsChartVersion(),
+		}); err != nil {
+			return fmt.Errorf("verify deploy repository: %w", err)
+		}
+		return nil
 	}
 
+	// Templates are rendered to the local clone.
+	renderer.DestinationRoot = repo.LocalPath()
+
 	// Update files of gitops repository.
-	if err := gitops.UpdateFiles(ctx, gitops.UpdateFilesParams{
+	params := gitops.UpdateFilesParams{
 		Repo:             repo,
 		ExportEnv:        gitops.EnvmanExport,
-		Renderer:         renderer,
+		Renderer:         gitops.NewRenderer(renderer, cfg.Environments),
 		PullRequest:      cfg.PullRequest,
 		PullRequestTitle: cfg.PullRequestTitle,
 		PullRequestBody:  cfg.PullRequestBody,
 		CommitMessage:    cfg.CommitMessage,
-	}); err != nil {
+		Environments:     cfg.Environments,
+		DryRun:           cfg.DryRun,
+		DiffPath:         cfg.DiffPath,
+		SummaryPath:      cfg.SummaryPath,
+		Report:           report,
+	}
+	build := cfg.Build()
+	history := gitops.HistoryLedger{
+		Templates:    renderer,
+		Environments: cfg.Environments,
+		MaxEntries:   cfg.HistoryMaxEntries,
+	}
+	switch cfg.Mode {
+	case gitops.ModeRollback:
+		// Deploy folders are restored to an earlier deployment. The current
+		// source commit isn't deployed, so it isn't recorded.
+		build.SourceCommit = ""
+		trailer := gitops.RollbackTrailer(cfg.RollbackToSourceCommit, cfg.RollbackToBuildNumber)
+		params.Trailers = append(params.Trailers, trailer)
+		params.Renderer = gitops.RollbackRenderer{
+			Repo:           repo,
+			Folders:        cfg.DeployFolders(),
+			ToSourceCommit: cfg.RollbackToSourceCommit,
+			ToBuildNumber:  cfg.RollbackToBuildNumber,
+		}
+		history.Rollback = trailer.Value
+	case gitops.ModePromote:
+		// A deploy folder is promoted to another one. The promoted source
+		// commit is recorded instead of the current one.
+		build.SourceCommit = ""
+		params.Environments = nil
+		params.Renderer = gitops.PromoteRenderer{
+			Repo: repo,
+			From: cfg.PromoteFrom,
+			To:   cfg.PromoteTo,
+			Keys: cfg.PromoteKeys,
+		}
+		history.Templates.DestinationFolder = cfg.PromoteTo
+		history.Environments = nil
+		history.PromotedFrom = cfg.PromoteFrom
+	case gitops.ModeUpdate, gitops.ModeFlux:
+		// Existing files (or Flux resources) are updated without templates.
+		params.Renderer = cfg.Updaters(repo.LocalPath())
+	case gitops.ModeTeardown:
+		// The preview (and expired ones) are removed, so is their history.
+		params.Renderer = cfg.PreviewRemover(repo)
+		cfg.HistoryLedger = false
+	default:
+		// Existing files are updated after rendering templates.
+		if updates := cfg.Updaters(repo.LocalPath()); len(updates) > 0 {
+			params.Renderer = append(gitops.Renderers{params.Renderer}, updates...)
+		}
+	}
+	// ArgoCD applications of deploy folders are committed with them.
+	if cfg.GeneratesArgoApplications() {
+		params.Renderer = gitops.Renderers{params.Renderer, cfg.ArgoApplications(repo.LocalPath())}
+	}
+	// Expired previews are removed in the same commit.
+	if cfg.Mode == gitops.ModePreview && cfg.SweepsPreviews() {
+		params.Renderer = gitops.Renderers{params.Renderer, cfg.PreviewRemover(repo)}
+	}
+	params.Trailers = append(gitops.CommitTrailers(cfg.DeployFolders(), build), params.Trailers...)
+	// Bump the version of charts whenever their deploy folder changes.
+	if cfg.BumpsChartVersion() {
+		params.ChartVersion = gitops.ChartVersionBumper{
+			Folders:     cfg.DeployFolders(),
+			Bump:        cfg.ChartVersionBump,
+			BuildNumber: cfg.BuildNumber,
+		}
+	}
+	// Wait for ArgoCD applications to be synced to the pushed commit.
+	if cfg.SyncsArgoApplications() {
+		argo, err := gitops.NewArgoCD(cfg.ArgoServerURL, cfg.ArgoToken, gitops.ArgoCDTLS{
+			Insecure:   cfg.ArgoInsecure,
+			CACertFile: cfg.ArgoCACertFile,
+		})
+		if err != nil {
+			return fmt.Errorf("new argocd client: %w", err)
+		}
+		params.ArgoSync = gitops.ArgoCDSyncer{
+			ArgoCD:       argo,
+			Applications: cfg.ArgoSyncApplications(),
+			Sync:         cfg.ArgoSync,
+			Timeout:      time.Duration(cfg.ArgoSyncTimeout) * time.Second,
+		}
+	}
+	if validator != nil {
+		params.Validator = validator
+	}
+	// Record changes in the history ledger of each deploy folder.
+	if cfg.HistoryLedger {
+		history.Build = build
+		params.History = history
+	}
+	if err := gitops.UpdateFiles(ctx, params); err != nil {
 		return fmt.Errorf("update files in gitops repo: %w", err)
 	}
 	return nil
@@ -0,0 +1,337 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+)
+
+// Syncs of ArgoCD applications after pushing.
+const (
+	// ArgoSyncNone doesn't sync applications (ArgoCD polls the repository).
+	ArgoSyncNone = "none"
+	// ArgoSyncRefresh refreshes applications, so ArgoCD notices the pushed
+	// revision immediately (automated sync policies sync them).
+	ArgoSyncRefresh = "refresh"
+	// ArgoSyncSync syncs applications to the pushed revision.
+	ArgoSyncSync = "sync"
+)
+
+// Statuses of ArgoCD applications.
+const (
+	argoSynced          = "Synced"
+	argoHealthy         = "Healthy"
+	argoOperationFailed = "Failed"
+	argoOperationError  = "Error"
+)
+
+// defaultArgoPollInterval is the interval of polling application statuses.
+const defaultArgoPollInterval = 5 * time.Second
+
+//go:generate moq -out argocd_moq_test.go . argoSyncer
+type argoSyncer interface {
+	// syncApplications syncs (or refreshes) applications and waits until
+	// they are synced to a revision and healthy.
+	syncApplications(ctx context.Context, revision string) error
+}
+
+// ArgoCDTLS are the TLS options of the ArgoCD API client.
+type ArgoCDTLS struct {
+	// Insecure skips the verification of the server certificate.
+	Insecure bool
+	// CACertFile is a PEM file of the CA certificates of the server
+	// (the system ones are used without it).
+	CACertFile string
+}
+
+// argoCD is a client of the ArgoCD API.
+type argoCD struct {
+	client *http.Client
+	server *url.URL
+	token  string
+}
+
+// NewArgoCD returns a new client of the ArgoCD API of a server
+// authenticated by a token (of an ArgoCD account).
+func NewArgoCD(serverURL string, token stepconf.Secret, opts ArgoCDTLS) (*argoCD, error) {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "https://" + serverURL
+	}
+	server, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, classify(errorClassArgoCD, fmt.Errorf("parse server url: %w", err))
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.CACertFile != "" {
+		pem, err := ioutil.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, classify(errorClassArgoCD, fmt.Errorf("read ca cert file: %w", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, classify(errorClassArgoCD, fmt.Errorf("no certificates in ca cert file %s", opts.CACertFile))
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &argoCD{
+		client: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		server: server,
+		token:  string(token),
+	}, nil
+}
+
+// argoAppStatus is the status of an ArgoCD application.
+type argoAppStatus struct {
+	Sync struct {
+		Status   string `json:"status"`
+		Revision string `json:"revision"`
+	} `json:"sync"`
+	Health         argoHealth          `json:"health"`
+	OperationState *argoOperationState `json:"operationState"`
+	Resources      []argoResource      `json:"resources"`
+}
+
+// argoHealth is the health of an application or a resource.
+type argoHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// argoOperationState is the state of the last sync of an application.
+type argoOperationState struct {
+	Phase      string `json:"phase"`
+	Message    string `json:"message"`
+	SyncResult *struct {
+		Revision string `json:"revision"`
+	} `json:"syncResult"`
+}
+
+// argoResource is a resource of an application.
+type argoResource struct {
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Status    string      `json:"status"`
+	Health    *argoHealth `json:"health"`
+}
+
+// application returns the status of an application. A refresh makes ArgoCD
+// compare the application with the latest revision of its repository.
+func (ac argoCD) application(ctx context.Context, name string, refresh bool) (argoAppStatus, error) {
+	var query url.Values
+	if refresh {
+		query = url.Values{"refresh": {"normal"}}
+	}
+	var app struct {
+		Status argoAppStatus `json:"status"`
+	}
+	if err := ac.do(ctx, http.MethodGet, "/api/v1/applications/"+url.PathEscape(name), query, nil, &app); err != nil {
+		return argoAppStatus{}, err
+	}
+	return app.Status, nil
+}
+
+// sync syncs an application to a revision.
+func (ac argoCD) sync(ctx context.Context, name, revision string) error {
+	body := map[string]interface{}{"revision": revision}
+	return ac.do(ctx, http.MethodPost, "/api/v1/applications/"+url.PathEscape(name)+"/sync", nil, body, nil)
+}
+
+// do sends a request to the API and decodes the JSON response (if out is
+// given). Unsuccessful responses are errors with the message of ArgoCD.
+func (ac argoCD) do(ctx context.Context, method, apiPath string, query url.Values, in, out interface{}) error {
+	u := *ac.server
+	u.Path = strings.TrimSuffix(u.Path, "/") + apiPath
+	u.RawQuery = query.Encode()
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+ac.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ac.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, apiPath, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s %s: read response: %w", method, apiPath, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(b))
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, apiPath, resp.Status, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("%s %s: decode response: %w", method, apiPath, err)
+	}
+	return nil
+}
+
+// ArgoCDSyncer syncs (or refreshes) ArgoCD applications after pushing and
+// waits until they are synced to the pushed revision and healthy.
+type ArgoCDSyncer struct {
+	// ArgoCD is the client of the ArgoCD API.
+	ArgoCD *argoCD
+	// Applications are the names of the applications.
+	Applications []string
+	// Sync is how the applications are synced (see ArgoSync* constants).
+	Sync string
+	// Timeout of waiting for the applications.
+	Timeout time.Duration
+	// PollInterval is the interval of polling application statuses
+	// (5 seconds by default).
+	PollInterval time.Duration
+}
+
+// ArgoCDSyncer implements the argoSyncer interface.
+var _ argoSyncer = (*ArgoCDSyncer)(nil)
+
+func (as ArgoCDSyncer) syncApplications(ctx context.Context, revision string) error {
+	if as.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, as.Timeout)
+		defer cancel()
+	}
+	for _, name := range as.Applications {
+		if as.Sync == ArgoSyncSync {
+			if err := as.ArgoCD.sync(ctx, name, revision); err != nil {
+				return fmt.Errorf("sync application %q: %w", name, err)
+			}
+			continue
+		}
+		if _, err := as.ArgoCD.application(ctx, name, true); err != nil {
+			return fmt.Errorf("refresh application %q: %w", name, err)
+		}
+	}
+
+	interval := as.PollInterval
+	if interval <= 0 {
+		interval = defaultArgoPollInterval
+	}
+	pending := append([]string{}, as.Applications...)
+	statuses := map[string]argoAppStatus{}
+	for {
+		var waiting []string
+		for i, name := range pending {
+			status, err := as.ArgoCD.application(ctx, name, false)
+			if err != nil {
+				if ctx.Err() != nil {
+					waiting = append(waiting, pending[i:]...)
+					break
+				}
+				return fmt.Errorf("status of application %q: %w", name, err)
+			}
+			statuses[name] = status
+			if err := syncFailure(status, revision); err != nil {
+				return fmt.Errorf("application %q: %w", name, err)
+			}
+			if !status.ready(revision) {
+				waiting = append(waiting, name)
+			}
+		}
+		if pending = waiting; len(pending) == 0 {
+			return nil
+		}
+		log.Printf("Waiting for ArgoCD applications: %s\n", strings.Join(pending, ", "))
+
+		select {
+		case <-ctx.Done():
+			var reasons []string
+			for _, name := range pending {
+				reasons = append(reasons, fmt.Sprintf("%s: %s", name, statuses[name].describe(revision)))
+			}
+			return fmt.Errorf("applications aren't synced to %s and healthy: %w\n%s",
+				revision, ctx.Err(), strings.Join(reasons, "\n"))
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ready tells whether the application is synced to a revision and healthy.
+func (s argoAppStatus) ready(revision string) bool {
+	return s.Sync.Status == argoSynced && s.Sync.Revision == revision && s.Health.Status == argoHealthy
+}
+
+// syncFailure returns the error of a failed sync to a revision (if any).
+func syncFailure(s argoAppStatus, revision string) error {
+	op := s.OperationState
+	if op == nil || op.SyncResult == nil || op.SyncResult.Revision != revision {
+		return nil
+	}
+	if op.Phase != argoOperationFailed && op.Phase != argoOperationError {
+		return nil
+	}
+	return fmt.Errorf("sync %s: %s\n%s", strings.ToLower(op.Phase), op.Message, strings.Join(s.unhealthyResources(), "\n"))
+}
+
+// describe describes why the application isn't ready.
+func (s argoAppStatus) describe(revision string) string {
+	if s.Sync.Status == "" && s.Health.Status == "" {
+		return "status unknown"
+	}
+	description := fmt.Sprintf("sync status %s", s.Sync.Status)
+	if s.Sync.Revision != revision {
+		description += fmt.Sprintf(" at revision %q", s.Sync.Revision)
+	}
+	description += fmt.Sprintf(", health status %s", s.Health.Status)
+	if s.Health.Message != "" {
+		description += fmt.Sprintf(" (%s)", s.Health.Message)
+	}
+	for _, r := range s.unhealthyResources() {
+		description += "\n  " + r
+	}
+	return description
+}
+
+// unhealthyResources returns the health of resources which aren't healthy
+// (sorted by kind, namespace and name).
+func (s argoAppStatus) unhealthyResources() []string {
+	var resources []string
+	for _, r := range s.Resources {
+		if r.Health == nil || r.Health.Status == argoHealthy {
+			continue
+		}
+		resource := fmt.Sprintf("%s %s/%s is %s", r.Kind, r.Namespace, r.Name, r.Health.Status)
+		if r.Namespace == "" {
+			resource = fmt.Sprintf("%s %s is %s", r.Kind, r.Name, r.Health.Status)
+		}
+		if r.Health.Message != "" {
+			resource += ": " + r.Health.Message
+		}
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	return resources
+}
@@ -0,0 +1,446 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sync policies of generated ArgoCD applications.
+const (
+	// SyncManual applications are synced by hand.
+	SyncManual = "manual"
+	// SyncAutomated applications are synced automatically.
+	SyncAutomated = "automated"
+	// SyncAutomatedPrune applications are synced automatically and
+	// resources which aren't deployed anymore are deleted.
+	SyncAutomatedPrune = "automated-prune"
+	// SyncAutomatedPruneSelfHeal applications are synced automatically,
+	// deleted resources are pruned and changes made in the cluster are
+	// reverted.
+	SyncAutomatedPruneSelfHeal = "automated-prune-self-heal"
+)
+
+// argoAPIVersion is the API version of ArgoCD resources.
+const argoAPIVersion = "argoproj.io/v1alpha1"
+
+// Sync option and finalizer of ArgoCD applications.
+const (
+	argoCreateNamespace    = "CreateNamespace=true"
+	argoResourcesFinalizer = "resources-finalizer.argocd.argoproj.io"
+)
+
+// ArgoApplication is the ArgoCD application of a deploy folder.
+type ArgoApplication struct {
+	// Name of the application.
+	Name string
+	// Project of the application.
+	Project string
+	// RepoURL is the URL of the deploy repository.
+	RepoURL string
+	// TargetRevision is the deployed branch of the deploy repository.
+	TargetRevision string
+	// Path is the deploy folder.
+	Path string
+	// Namespace is the destination namespace (optional).
+	Namespace string
+	// Cluster is the URL or the name of the destination cluster.
+	Cluster string
+	// SyncPolicy is the sync policy (see Sync* constants).
+	SyncPolicy string
+	// CreateNamespace creates the destination namespace when syncing.
+	CreateNamespace bool
+	// DeleteResources deletes the resources of the application
+	// when it's deleted (by the resources finalizer).
+	DeleteResources bool
+}
+
+// argoField is a scalar field of an ArgoCD manifest.
+type argoField struct {
+	keyPath []string
+	value   string
+	tag     string
+}
+
+// ArgoApplications generates (or updates) the ArgoCD Application manifests
+// of deploy folders in the apps folder of the deploy repository. They are
+// elements of the list generator of an ApplicationSet instead, if its name
+// is given. Existing manifests are edited in place (with a minimal diff).
+type ArgoApplications struct {
+	// Root is the root folder of the deploy repository (the local clone).
+	Root string
+	// Folder is the apps folder (relative to the root).
+	Folder string
+	// Namespace is the namespace of ArgoCD (where applications are created).
+	Namespace string
+	// ApplicationSet is the name of the ApplicationSet
+	// (Application manifests are generated without it).
+	ApplicationSet string
+	// Applications of the deploy folders.
+	Applications []ArgoApplication
+}
+
+// ArgoApplications implements the renderAllFileser interface.
+var _ renderAllFileser = (*ArgoApplications)(nil)
+
+// renderAllFiles writes the manifests and returns their slash separated paths.
+func (aa ArgoApplications) renderAllFiles() ([]string, error) {
+	if aa.ApplicationSet != "" {
+		file, err := aa.writeManifest(aa.ApplicationSet, aa.applicationSet)
+		if err != nil {
+			return nil, fmt.Errorf("application set %q: %w", aa.ApplicationSet, err)
+		}
+		return []string{file}, nil
+	}
+	var files []string
+	for _, app := range aa.Applications {
+		app := app
+		file, err := aa.writeManifest(app.Name, func(content []byte) ([]byte, error) {
+			return aa.application(content, app)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("application %q: %w", app.Name, err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// remove removes the manifests of applications (or their elements of the
+// ApplicationSet) and returns the slash separated paths of edited files.
+func (aa ArgoApplications) remove(names []string) ([]string, error) {
+	folder := filepath.ToSlash(filepath.Clean(aa.Folder))
+	if aa.ApplicationSet == "" {
+		for _, name := range names {
+			file := path.Join(folder, name+".yaml")
+			err := os.Remove(filepath.Join(aa.Root, filepath.FromSlash(file)))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("remove %s: %w", file, err)
+			}
+		}
+		return nil, nil
+	}
+
+	file := path.Join(folder, aa.ApplicationSet+".yaml")
+	filePath := filepath.Join(aa.Root, filepath.FromSlash(file))
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", file, err)
+	}
+	elementsPath := []string{"spec", "generators", "0", "list", "elements"}
+	edited := false
+	for _, name := range names {
+		index, err := sequenceIndex(content, elementsPath, []string{"name"}, name)
+		if err != nil {
+			return nil, fmt.Errorf("application set %q: %w", aa.ApplicationSet, err)
+		}
+		if index < 0 {
+			continue
+		}
+		if content, err = removeElement(content, elementsPath, index); err != nil {
+			return nil, fmt.Errorf("application set %q: %w", aa.ApplicationSet, err)
+		}
+		edited = true
+	}
+	if !edited {
+		return nil, nil
+	}
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		return nil, fmt.Errorf("write %s: %w", file, err)
+	}
+	return []string{file}, nil
+}
+
+// removeElement removes an element of a list generator. The elements are
+// kept even without any, so the generator stays valid.
+func removeElement(content []byte, elementsPath []string, index int) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	elements := findYAMLNode(root, elementsPath)
+	if elements == nil || elements.Kind != yaml.SequenceNode || len(elements.Content) != 1 {
+		return removeYAMLItem(content, elementsPath, index)
+	}
+	if elements.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("%s: flow style sequences aren't supported", strings.Join(elementsPath, "."))
+	}
+	item := elements.Content[0]
+	return removeLines(content, item.Line, lastLine(item)), nil
+}
+
+// writeManifest edits the manifest of a given name (a new one is created
+// from a skeleton) and returns its slash separated path.
+func (aa ArgoApplications) writeManifest(name string, edit func([]byte) ([]byte, error)) (string, error) {
+	file := path.Join(filepath.ToSlash(filepath.Clean(aa.Folder)), name+".yaml")
+	filePath := filepath.Join(aa.Root, filepath.FromSlash(file))
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		kind := "Application"
+		if aa.ApplicationSet != "" {
+			kind = "ApplicationSet"
+		}
+		content = []byte(fmt.Sprintf("apiVersion: %s\nkind: %s\nmetadata:\n  name: %s\n", argoAPIVersion, kind, name))
+	} else if err != nil {
+		return "", fmt.Errorf("read %s: %w", file, err)
+	}
+	if aa.Namespace != "" {
+		if content, err = setYAMLPath(content, []string{"metadata", "namespace"}, aa.Namespace, "!!str"); err != nil {
+			return "", fmt.Errorf("set namespace: %w", err)
+		}
+	}
+	if content, err = edit(content); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", fmt.Errorf("create apps folder: %w", err)
+	}
+	if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+		return "", fmt.Errorf("write %s: %w", file, err)
+	}
+	return file, nil
+}
+
+// application sets the fields of an Application manifest.
+func (aa ArgoApplications) application(content []byte, app ArgoApplication) ([]byte, error) {
+	spec := func(keyPath ...string) []string {
+		return append([]string{"spec"}, keyPath...)
+	}
+	fields := []argoField{
+		{keyPath: spec("project"), value: app.Project},
+		{keyPath: spec("source", "repoURL"), value: app.RepoURL},
+		{keyPath: spec("source", "targetRevision"), value: app.TargetRevision},
+		{keyPath: spec("source", "path"), value: app.Path},
+	}
+	fields = append(fields, destinationFields(spec("destination"), app.Cluster, app.Namespace)...)
+	content, err := setArgoFields(content, fields)
+	if err != nil {
+		return nil, err
+	}
+	content, err = removeArgoFields(content, spec("destination"), app.Cluster, app.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = setSyncPolicy(content, spec("syncPolicy"), app.SyncPolicy); err != nil {
+		return nil, err
+	}
+	return addArgoOptions(content, nil, app)
+}
+
+// addArgoOptions adds the sync option and the finalizer of an application
+// to the manifest (or the template) at a key path (if they're missing).
+// They aren't removed from existing manifests.
+func addArgoOptions(content []byte, keyPath []string, app ArgoApplication) ([]byte, error) {
+	at := func(keys ...string) []string {
+		return append(append([]string{}, keyPath...), keys...)
+	}
+	var err error
+	if app.CreateNamespace {
+		if content, err = addArgoItem(content, at("spec", "syncPolicy", "syncOptions"), argoCreateNamespace); err != nil {
+			return nil, fmt.Errorf("add sync option: %w", err)
+		}
+	}
+	if app.DeleteResources {
+		if content, err = addArgoItem(content, at("metadata", "finalizers"), argoResourcesFinalizer); err != nil {
+			return nil, fmt.Errorf("add finalizer: %w", err)
+		}
+	}
+	return content, nil
+}
+
+// addArgoItem adds a string item to the sequence at a key path
+// (if it's missing).
+func addArgoItem(content []byte, keyPath []string, value string) ([]byte, error) {
+	index, err := sequenceIndex(content, keyPath, nil, value)
+	if err != nil || index >= 0 {
+		return content, err
+	}
+	text, err := plainText(value)
+	if err != nil {
+		return nil, err
+	}
+	return appendYAMLItem(content, keyPath, []string{text})
+}
+
+// applicationSet sets the elements of the list generator of an
+// ApplicationSet manifest. The template of a new ApplicationSet uses the
+// fields of the elements.
+func (aa ArgoApplications) applicationSet(content []byte) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	elementsPath := []string{"spec", "generators", "0", "list", "elements"}
+	if findYAMLNode(root, []string{"spec"}) == nil && len(aa.Applications) > 0 {
+		if content, err = aa.applicationSetSkeleton(content, aa.Applications[0]); err != nil {
+			return nil, err
+		}
+	} else if findYAMLNode(root, elementsPath[:4]) == nil {
+		return nil, fmt.Errorf("first generator isn't a list generator")
+	}
+
+	for _, app := range aa.Applications {
+		element := []argoField{
+			{keyPath: []string{"name"}, value: app.Name},
+			{keyPath: []string{"project"}, value: app.Project},
+			{keyPath: []string{"repoURL"}, value: app.RepoURL},
+			{keyPath: []string{"targetRevision"}, value: app.TargetRevision},
+			{keyPath: []string{"path"}, value: app.Path},
+		}
+		element = append(element, destinationFields(nil, app.Cluster, app.Namespace)...)
+		index, err := sequenceIndex(content, elementsPath, []string{"name"}, app.Name)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 {
+			var item []string
+			for _, f := range element {
+				text, err := plainText(f.value)
+				if err != nil {
+					return nil, err
+				}
+				item = append(item, f.keyPath[0]+": "+text)
+			}
+			if content, err = appendYAMLItem(content, elementsPath, item); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		elementPath := append(append([]string{}, elementsPath...), fmt.Sprint(index))
+		for i := range element {
+			element[i].keyPath = append(append([]string{}, elementPath...), element[i].keyPath...)
+		}
+		if content, err = setArgoFields(content, element); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// applicationSetSkeleton adds the list generator and the template of a new
+// ApplicationSet (the destination and the sync policy of the template are
+// the ones of an application).
+func (aa ArgoApplications) applicationSetSkeleton(content []byte, app ArgoApplication) ([]byte, error) {
+	destination := "    server: '{{server}}'"
+	if !isClusterURL(app.Cluster) {
+		destination = "    name: '{{cluster}}'"
+	}
+	lines := []string{
+		"  generators:",
+		"  - list:",
+		"      elements:",
+		"  template:",
+		"    metadata:",
+		"      name: '{{name}}'",
+		"    spec:",
+		"      project: '{{project}}'",
+		"      source:",
+		"        repoURL: '{{repoURL}}'",
+		"        targetRevision: '{{targetRevision}}'",
+		"        path: '{{path}}'",
+		"      destination:",
+		"    " + destination,
+	}
+	if app.Namespace != "" {
+		lines = append(lines, "        namespace: '{{namespace}}'")
+	}
+	content, err := addYAMLEntry(content, nil, "spec", lines, true)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = setSyncPolicy(content, []string{"spec", "template", "spec", "syncPolicy"}, app.SyncPolicy); err != nil {
+		return nil, err
+	}
+	return addArgoOptions(content, []string{"spec", "template"}, app)
+}
+
+// destinationFields returns the destination fields of an application.
+func destinationFields(keyPath []string, cluster, namespace string) []argoField {
+	field := func(key, value string) argoField {
+		return argoField{keyPath: append(append([]string{}, keyPath...), key), value: value}
+	}
+	var fields []argoField
+	if isClusterURL(cluster) {
+		fields = append(fields, field("server", cluster))
+	} else {
+		fields = append(fields, field("name", cluster))
+	}
+	if namespace != "" {
+		fields = append(fields, field("namespace", namespace))
+	}
+	return fields
+}
+
+// removeArgoFields removes the destination fields which aren't used anymore
+// (e.g. the server if the cluster is given by its name).
+func removeArgoFields(content []byte, keyPath []string, cluster, namespace string) ([]byte, error) {
+	unused := []string{"name"}
+	if !isClusterURL(cluster) {
+		unused = []string{"server"}
+	}
+	if namespace == "" {
+		unused = append(unused, "namespace")
+	}
+	var err error
+	for _, key := range unused {
+		if content, err = removeYAMLEntry(content, append(append([]string{}, keyPath...), key)); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// isClusterURL tells whether a cluster is given by its URL (or its name).
+func isClusterURL(cluster string) bool {
+	return strings.HasPrefix(cluster, "https://") || strings.HasPrefix(cluster, "http://")
+}
+
+// setArgoFields sets fields of a manifest.
+func setArgoFields(content []byte, fields []argoField) ([]byte, error) {
+	var err error
+	for _, f := range fields {
+		tag := f.tag
+		if tag == "" {
+			tag = "!!str"
+		}
+		if content, err = setYAMLPath(content, f.keyPath, f.value, tag); err != nil {
+			return nil, fmt.Errorf("set %s: %w", strings.Join(f.keyPath, "."), err)
+		}
+	}
+	return content, nil
+}
+
+// setSyncPolicy sets the automated sync policy at a key path
+// (it's removed for manual syncs).
+func setSyncPolicy(content []byte, keyPath []string, policy string) ([]byte, error) {
+	automated := append(append([]string{}, keyPath...), "automated")
+	if policy == "" || policy == SyncManual {
+		return removeYAMLEntry(content, automated)
+	}
+	prune := policy == SyncAutomatedPrune || policy == SyncAutomatedPruneSelfHeal
+	selfHeal := policy == SyncAutomatedPruneSelfHeal
+	return setArgoFields(content, []argoField{
+		{keyPath: append(append([]string{}, automated...), "prune"), value: fmt.Sprint(prune), tag: "!!bool"},
+		{keyPath: append(append([]string{}, automated...), "selfHeal"), value: fmt.Sprint(selfHeal), tag: "!!bool"},
+	})
+}
+
+// repoURLWithoutCredentials returns a repository URL without credentials
+// (they aren't committed to the manifests).
+func repoURLWithoutCredentials(repoURL string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.User == nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return repoURL
+	}
+	u.User = nil
+	return u.String()
+}
@@ -0,0 +1,80 @@
+// Code generated by moq; DO NOT EDIT.
+// github.com/matryer/moq
+
+package gitops
+
+import (
+	"context"
+	"sync"
+)
+
+// Ensure, that argoSyncerMock does implement argoSyncer.
+// If this is not the case, regenerate this file with moq.
+var _ argoSyncer = &argoSyncerMock{}
+
+// argoSyncerMock is a mock implementation of argoSyncer.
+//
+//     func TestSomethingThatUsesargoSyncer(t *testing.T) {
+//
+//         // make and configure a mocked argoSyncer
+//         mockedargoSyncer := &argoSyncerMock{
+//             syncApplicationsFunc: func(ctx context.Context, revision string) error {
+// 	               panic("mock out the syncApplications method")
+//             },
+//         }
+//
+//         // use mockedargoSyncer in code that requires argoSyncer
+//         // and then make assertions.
+//
+//     }
+type argoSyncerMock struct {
+	// syncApplicationsFunc mocks the syncApplications method.
+	syncApplicationsFunc func(ctx context.Context, revision string) error
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// syncApplications holds details about calls to the syncApplications method.
+		syncApplications []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Revision is the revision argument value.
+			Revision string
+		}
+	}
+	locksyncApplications sync.RWMutex
+}
+
+// syncApplications calls syncApplicationsFunc.
+func (mock *argoSyncerMock) syncApplications(ctx context.Context, revision string) error {
+	if mock.syncApplicationsFunc == nil {
+		panic("argoSyncerMock.syncApplicationsFunc: method is nil but argoSyncer.syncApplications was just called")
+	}
+	callInfo := struct {
+		Ctx      context.Context
+		Revision string
+	}{
+		Ctx:      ctx,
+		Revision: revision,
+	}
+	mock.locksyncApplications.Lock()
+	mock.calls.syncApplications = append(mock.calls.syncApplications, callInfo)
+	mock.locksyncApplications.Unlock()
+	return mock.syncApplicationsFunc(ctx, revision)
+}
+
+// syncApplicationsCalls gets all the calls that were made to syncApplications.
+// Check the length with:
+//     len(mockedargoSyncer.syncApplicationsCalls())
+func (mock *argoSyncerMock) syncApplicationsCalls() []struct {
+	Ctx      context.Context
+	Revision string
+} {
+	var calls []struct {
+		Ctx      context.Context
+		Revision string
+	}
+	mock.locksyncApplications.RLock()
+	calls = mock.calls.syncApplications
+	mock.locksyncApplications.RUnlock()
+	return calls
+}
@@ -0,0 +1,234 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/stepconf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pushedRevision = "0123456789abcdef0123456789abcdef01234567"
+
+// fakeArgoCD is a fake ArgoCD API server. Each status request of an
+// application returns its next status (the last one is repeated).
+type fakeArgoCD struct {
+	mu       sync.Mutex
+	statuses map[string][]argoAppStatus
+	refresh  []string
+	synced   map[string]string
+}
+
+func (f *fakeArgoCD) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer argo-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid session","code":16,"message":"invalid session: token is invalid"}`))
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/api/v1/applications/")
+	name := strings.TrimSuffix(p, "/sync")
+	statuses, ok := f.statuses[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"not found","code":5,"message":"applications.argoproj.io \"` + name + `\" not found"}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(p, "/sync"):
+		var body struct {
+			Revision string `json:"revision"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.synced[name] = body.Revision
+		_, _ = w.Write([]byte(`{}`))
+	case r.Method == http.MethodGet:
+		if r.URL.Query().Get("refresh") != "" {
+			f.refresh = append(f.refresh, name)
+		}
+		status := statuses[0]
+		if len(statuses) > 1 {
+			f.statuses[name] = statuses[1:]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": status})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// appStatus returns an application status.
+func appStatus(sync, revision, health string, resources ...argoResource) argoAppStatus {
+	var s argoAppStatus
+	s.Sync.Status = sync
+	s.Sync.Revision = revision
+	s.Health.Status = health
+	s.Resources = resources
+	return s
+}
+
+var degradedDeployment = argoResource{
+	Kind:      "Deployment",
+	Namespace: "shop",
+	Name:      "api",
+	Status:    argoSynced,
+	Health:    &argoHealth{Status: "Degraded", Message: `Deployment "api" exceeded its progress deadline`},
+}
+
+var argoCDSyncerCases = map[string]struct {
+	statuses    map[string][]argoAppStatus
+	token       string
+	sync        string
+	wantRefresh []string
+	wantSynced  map[string]string
+	wantErr     []string
+}{
+	"refresh until synced and healthy": {
+		statuses: map[string][]argoAppStatus{
+			"shop-prod": {
+				appStatus("OutOfSync", "fedcba", argoHealthy),
+				appStatus(argoSynced, pushedRevision, "Progressing"),
+				appStatus(argoSynced, pushedRevision, argoHealthy),
+			},
+			"shop-staging": {appStatus(argoSynced, pushedRevision, argoHealthy)},
+		},
+		sync:        ArgoSyncRefresh,
+		wantRefresh: []string{"shop-prod", "shop-staging"},
+		wantSynced:  map[string]string{},
+	},
+	"sync to pushed revision": {
+		statuses: map[string][]argoAppStatus{
+			"shop-prod":    {appStatus(argoSynced, pushedRevision, argoHealthy)},
+			"shop-staging": {appStatus(argoSynced, pushedRevision, argoHealthy)},
+		},
+		sync:       ArgoSyncSync,
+		wantSynced: map[string]string{"shop-prod": pushedRevision, "shop-staging": pushedRevision},
+	},
+	"failed sync (error)": {
+		statuses: map[string][]argoAppStatus{
+			"shop-prod": {func() argoAppStatus {
+				s := appStatus("OutOfSync", pushedRevision, "Missing", degradedDeployment)
+				s.OperationState = &argoOperationState{Phase: argoOperationFailed, Message: "one or more objects failed to apply"}
+				s.OperationState.SyncResult = &struct {
+					Revision string `json:"revision"`
+				}{Revision: pushedRevision}
+				return s
+			}()},
+			"shop-staging": {appStatus(argoSynced, pushedRevision, argoHealthy)},
+		},
+		sync:    ArgoSyncSync,
+		wantErr: []string{`application "shop-prod"`, "sync failed: one or more objects failed to apply", `Deployment shop/api is Degraded: Deployment "api" exceeded its progress deadline`},
+	},
+	"timeout with resource health (error)": {
+		statuses: map[string][]argoAppStatus{
+			"shop-prod": {appStatus(argoSynced, pushedRevision, "Degraded", degradedDeployment, argoResource{
+				Kind: "Service", Namespace: "shop", Name: "api", Health: &argoHealth{Status: argoHealthy},
+			})},
+			"shop-staging": {appStatus(argoSynced, "fedcba", argoHealthy)},
+		},
+		sync: ArgoSyncRefresh,
+		wantErr: []string{
+			"context deadline exceeded",
+			`shop-prod: sync status Synced, health status Degraded` + "\n" + `  Deployment shop/api is Degraded: Deployment "api" exceeded its progress deadline`,
+			`shop-staging: sync status Synced at revision "fedcba", health status Healthy`,
+		},
+	},
+	"unknown application (error)": {
+		statuses: map[string][]argoAppStatus{"shop-prod": {appStatus(argoSynced, pushedRevision, argoHealthy)}},
+		sync:     ArgoSyncRefresh,
+		wantErr:  []string{`refresh application "shop-staging"`, "404 Not Found", `applications.argoproj.io "shop-staging" not found`},
+	},
+	"invalid token (error)": {
+		statuses: map[string][]argoAppStatus{"shop-prod": {}, "shop-staging": {}},
+		token:    "expired",
+		sync:     ArgoSyncSync,
+		wantErr:  []string{"401 Unauthorized", "invalid session: token is invalid"},
+	},
+}
+
+func TestArgoCDSyncer(t *testing.T) {
+	for name, tc := range argoCDSyncerCases {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeArgoCD{statuses: tc.statuses, synced: map[string]string{}}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+			token := tc.token
+			if token == "" {
+				token = "argo-token"
+			}
+			client, err := NewArgoCD(server.URL, stepconf.Secret(token), ArgoCDTLS{})
+			require.NoError(t, err, "NewArgoCD")
+
+			err = ArgoCDSyncer{
+				ArgoCD:       client,
+				Applications: []string{"shop-prod", "shop-staging"},
+				Sync:         tc.sync,
+				Timeout:      200 * time.Millisecond,
+				PollInterval: time.Millisecond,
+			}.syncApplications(context.Background(), pushedRevision)
+			if len(tc.wantErr) > 0 {
+				require.Error(t, err, "syncApplications")
+				for _, want := range tc.wantErr {
+					assert.Contains(t, err.Error(), want)
+				}
+				return
+			}
+			require.NoError(t, err, "syncApplications")
+			assert.Equal(t, tc.wantRefresh, fake.refresh, "refreshed applications")
+			assert.Equal(t, tc.wantSynced, fake.synced, "synced applications")
+		})
+	}
+}
+
+func TestNewArgoCDTLS(t *testing.T) {
+	fake := &fakeArgoCD{
+		statuses: map[string][]argoAppStatus{"shop": {appStatus(argoSynced, pushedRevision, argoHealthy)}},
+		synced:   map[string]string{},
+	}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp dir")
+	defer os.RemoveAll(dir)
+	caCertFile := path.Join(dir, "ca.pem")
+	write(t, caCertFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+
+	for name, tc := range map[string]struct {
+		opts    ArgoCDTLS
+		wantErr bool
+	}{
+		"insecure":                  {opts: ArgoCDTLS{Insecure: true}},
+		"ca cert file":              {opts: ArgoCDTLS{CACertFile: caCertFile}},
+		"unknown authority (error)": {wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewArgoCD(host, "argo-token", tc.opts)
+			require.NoError(t, err, "NewArgoCD")
+			_, err = client.application(context.Background(), "shop", false)
+			if tc.wantErr {
+				require.Error(t, err, "application")
+				return
+			}
+			require.NoError(t, err, "application")
+		})
+	}
+
+	_, err = NewArgoCD(host, "argo-token", ArgoCDTLS{CACertFile: path.Join(dir, "missing.pem")})
+	require.Error(t, err, "missing ca cert file")
+}
@@ -0,0 +1,198 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// chartFile is the name of the Helm chart file of deploy folders.
+const chartFile = "Chart.yaml"
+
+// Bumps of the chart version.
+const (
+	// ChartBumpNone doesn't bump the chart version.
+	ChartBumpNone = "none"
+	// ChartBumpPatch bumps the patch version (e.g. 0.1.0 to 0.1.1).
+	ChartBumpPatch = "patch"
+	// ChartBumpMinor bumps the minor version (e.g. 0.1.3 to 0.2.0).
+	ChartBumpMinor = "minor"
+	// ChartBumpMajor bumps the major version (e.g. 0.1.3 to 1.0.0).
+	ChartBumpMajor = "major"
+	// ChartBumpPrerelease sets a prerelease of the next patch version with
+	// the build number (e.g. 0.1.3 to 0.1.4-build.12). Prereleases of
+	// a prerelease version keep its version (e.g. 0.1.4-build.13).
+	ChartBumpPrerelease = "prerelease"
+)
+
+// semverPattern matches semantic versions (with an optional `v` prefix).
+var semverPattern = regexp.MustCompile(`^(v?)(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+//go:generate moq -out chart_moq_test.go . chartVersioner
+type chartVersioner interface {
+	// bumpChartVersions bumps the version of charts whose deploy folder
+	// changed and returns the version of all charts.
+	bumpChartVersions(repo repositorier) ([]chartVersion, error)
+}
+
+// chartVersion is the version of the chart of a deploy folder.
+type chartVersion struct {
+	Folder  string `json:"folder"`
+	Version string `json:"version"`
+}
+
+// ChartVersionBumper bumps the version of the Helm chart (Chart.yaml) of
+// deploy folders whenever their rendered content changes. The rendered
+// version is ignored, the committed one is bumped (or kept if nothing else
+// changed). New charts keep their rendered version.
+type ChartVersionBumper struct {
+	// Folders are the deploy folders.
+	Folders []string
+	// Bump of the version (see ChartBump* constants).
+	Bump string
+	// BuildNumber is the prerelease of ChartBumpPrerelease bumps.
+	BuildNumber string
+}
+
+// ChartVersionBumper implements the chartVersioner interface.
+var _ chartVersioner = (*ChartVersionBumper)(nil)
+
+func (cb ChartVersionBumper) bumpChartVersions(repo repositorier) ([]chartVersion, error) {
+	// Committed versions are restored first, so a changed version
+	// isn't a change of the deploy folder on it's own.
+	var versions []chartVersion
+	committed := map[string]bool{}
+	for _, folder := range cb.Folders {
+		folder = filepath.ToSlash(filepath.Clean(folder))
+		file := path.Join(folder, chartFile)
+		rendered, err := ioutil.ReadFile(filepath.Join(repo.LocalPath(), filepath.FromSlash(file)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+		content, ok, err := repo.committedFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("committed %s: %w", file, err)
+		}
+		if !ok {
+			content = rendered
+		}
+		version, err := lookupYAMLScalar(content, []string{"version"})
+		if err != nil {
+			return nil, fmt.Errorf("version of %s: %w", file, err)
+		}
+		if ok {
+			if err := setChartVersion(repo.LocalPath(), file, rendered, version); err != nil {
+				return nil, err
+			}
+		}
+		committed[folder] = ok
+		versions = append(versions, chartVersion{Folder: folder, Version: version})
+	}
+	if len(versions) == 0 || cb.Bump == ChartBumpNone || cb.Bump == "" {
+		return versions, nil
+	}
+
+	changes, err := repo.changes()
+	if err != nil {
+		return nil, fmt.Errorf("changes of working directory: %w", err)
+	}
+	for i, v := range versions {
+		if !committed[v.Folder] || !folderChanged(changes, v.Folder) {
+			continue
+		}
+		bumped, err := bumpVersion(v.Version, cb.Bump, cb.BuildNumber)
+		if err != nil {
+			return nil, fmt.Errorf("bump version of %s: %w", v.Folder, err)
+		}
+		file := path.Join(v.Folder, chartFile)
+		content, err := ioutil.ReadFile(filepath.Join(repo.LocalPath(), filepath.FromSlash(file)))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", file, err)
+		}
+		if err := setChartVersion(repo.LocalPath(), file, content, bumped); err != nil {
+			return nil, err
+		}
+		versions[i].Version = bumped
+	}
+	return versions, nil
+}
+
+// setChartVersion sets the version of a chart file.
+func setChartVersion(root, file string, content []byte, version string) error {
+	edited, err := setYAMLScalar(content, []string{"version"}, version)
+	if err != nil {
+		return fmt.Errorf("set version of %s: %w", file, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, filepath.FromSlash(file)), edited, 0644); err != nil {
+		return fmt.Errorf("write %s: %w", file, err)
+	}
+	return nil
+}
+
+// folderChanged tells whether files of a folder (slash separated)
+// changed other than metadata.
+func folderChanged(changes []fileChange, folder string) bool {
+	for _, c := range changes {
+		if isMetadata(c.path) {
+			continue
+		}
+		if folder == "." || strings.HasPrefix(c.path, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bumpVersion bumps a semantic version. Bumps of a prerelease version
+// release it if they can (e.g. a patch bump of 0.1.4-build.12 is 0.1.4).
+// The build metadata of the version is dropped.
+func bumpVersion(version, bump, buildNumber string) (string, error) {
+	m := semverPattern.FindStringSubmatch(version)
+	if m == nil {
+		return "", fmt.Errorf("%q isn't a semantic version", version)
+	}
+	prefix, prerelease := m[1], m[5]
+	var v [3]int
+	for i := range v {
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return "", fmt.Errorf("%q isn't a semantic version: %w", version, err)
+		}
+		v[i] = n
+	}
+
+	suffix := ""
+	switch bump {
+	case ChartBumpMajor:
+		if prerelease == "" || v[1] != 0 || v[2] != 0 {
+			v = [3]int{v[0] + 1, 0, 0}
+		}
+	case ChartBumpMinor:
+		if prerelease == "" || v[2] != 0 {
+			v = [3]int{v[0], v[1] + 1, 0}
+		}
+	case ChartBumpPatch:
+		if prerelease == "" {
+			v[2]++
+		}
+	case ChartBumpPrerelease:
+		if buildNumber == "" {
+			return "", fmt.Errorf("build number is required for %s bumps", bump)
+		}
+		if prerelease == "" {
+			v[2]++
+		}
+		suffix = "-build." + buildNumber
+	default:
+		return "", fmt.Errorf("unknown bump %q", bump)
+	}
+	return fmt.Sprintf("%s%d.%d.%d%s", prefix, v[0], v[1], v[2], suffix), nil
+}
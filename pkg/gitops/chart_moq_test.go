@@ -0,0 +1,73 @@
+// Code generated by moq; DO NOT EDIT.
+// github.com/matryer/moq
+
+package gitops
+
+import (
+	"sync"
+)
+
+// Ensure, that chartVersionerMock does implement chartVersioner.
+// If this is not the case, regenerate this file with moq.
+var _ chartVersioner = &chartVersionerMock{}
+
+// chartVersionerMock is a mock implementation of chartVersioner.
+//
+//     func TestSomethingThatUseschartVersioner(t *testing.T) {
+//
+//         // make and configure a mocked chartVersioner
+//         mockedchartVersioner := &chartVersionerMock{
+//             bumpChartVersionsFunc: func(repo repositorier) ([]chartVersion, error) {
+// 	               panic("mock out the bumpChartVersions method")
+//             },
+//         }
+//
+//         // use mockedchartVersioner in code that requires chartVersioner
+//         // and then make assertions.
+//
+//     }
+type chartVersionerMock struct {
+	// bumpChartVersionsFunc mocks the bumpChartVersions method.
+	bumpChartVersionsFunc func(repo repositorier) ([]chartVersion, error)
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// bumpChartVersions holds details about calls to the bumpChartVersions method.
+		bumpChartVersions []struct {
+			// Repo is the repo argument value.
+			Repo repositorier
+		}
+	}
+	lockbumpChartVersions sync.RWMutex
+}
+
+// bumpChartVersions calls bumpChartVersionsFunc.
+func (mock *chartVersionerMock) bumpChartVersions(repo repositorier) ([]chartVersion, error) {
+	if mock.bumpChartVersionsFunc == nil {
+		panic("chartVersionerMock.bumpChartVersionsFunc: method is nil but chartVersioner.bumpChartVersions was just called")
+	}
+	callInfo := struct {
+		Repo repositorier
+	}{
+		Repo: repo,
+	}
+	mock.lockbumpChartVersions.Lock()
+	mock.calls.bumpChartVersions = append(mock.calls.bumpChartVersions, callInfo)
+	mock.lockbumpChartVersions.Unlock()
+	return mock.bumpChartVersionsFunc(repo)
+}
+
+// bumpChartVersionsCalls gets all the calls that were made to bumpChartVersions.
+// Check the length with:
+//     len(mockedchartVersioner.bumpChartVersionsCalls())
+func (mock *chartVersionerMock) bumpChartVersionsCalls() []struct {
+	Repo repositorier
+} {
+	var calls []struct {
+		Repo repositorier
+	}
+	mock.lockbumpChartVersions.RLock()
+	calls = mock.calls.bumpChartVersions
+	mock.lockbumpChartVersions.RUnlock()
+	return calls
+}
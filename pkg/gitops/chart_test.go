@@ -0,0 +1,93 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var bumpVersionCases = map[string]struct {
+	version     string
+	bump        string
+	buildNumber string
+	want        string
+	wantErr     bool
+}{
+	"patch":                            {version: "0.1.0", bump: ChartBumpPatch, want: "0.1.1"},
+	"minor":                            {version: "0.1.3", bump: ChartBumpMinor, want: "0.2.0"},
+	"major":                            {version: "0.1.3", bump: ChartBumpMajor, want: "1.0.0"},
+	"v prefix is kept":                 {version: "v1.2.3", bump: ChartBumpPatch, want: "v1.2.4"},
+	"build metadata is dropped":        {version: "1.2.3+abc", bump: ChartBumpPatch, want: "1.2.4"},
+	"prerelease of next patch":         {version: "0.1.3", bump: ChartBumpPrerelease, buildNumber: "12", want: "0.1.4-build.12"},
+	"prerelease of prerelease":         {version: "0.1.4-build.12", bump: ChartBumpPrerelease, buildNumber: "13", want: "0.1.4-build.13"},
+	"patch releases prerelease":        {version: "0.1.4-build.12", bump: ChartBumpPatch, want: "0.1.4"},
+	"minor releases prerelease":        {version: "0.2.0-rc.1", bump: ChartBumpMinor, want: "0.2.0"},
+	"minor of patch prerelease":        {version: "0.2.1-rc.1", bump: ChartBumpMinor, want: "0.3.0"},
+	"prerelease without build (error)": {version: "0.1.3", bump: ChartBumpPrerelease, wantErr: true},
+	"not a semantic version (error)":   {version: "1.2", bump: ChartBumpPatch, wantErr: true},
+	"unknown bump (error)":             {version: "1.2.3", bump: "huge", wantErr: true},
+}
+
+func TestBumpVersion(t *testing.T) {
+	for name, tc := range bumpVersionCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := bumpVersion(tc.version, tc.bump, tc.buildNumber)
+			if tc.wantErr {
+				require.Error(t, err, "bumpVersion")
+				return
+			}
+			require.NoError(t, err, "bumpVersion")
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestChartVersionBumper(t *testing.T) {
+	repo, close := localClone(t)
+	defer close()
+
+	// Charts of prod and staging are committed, dev is a new chart.
+	chart := func(version string) string {
+		return "apiVersion: v2\nname: sample\nversion: " + version + " # bumped by CI\nappVersion: v1\n"
+	}
+	for folder, version := range map[string]string{"prod": "0.1.5", "staging": "0.3.0"} {
+		require.NoError(t, os.MkdirAll(path.Join(repo.LocalPath(), folder), 0700))
+		write(t, path.Join(repo.LocalPath(), folder, chartFile), chart(version))
+		write(t, path.Join(repo.LocalPath(), folder, "values.yaml"), "tag: v1\n")
+	}
+	require.NoError(t, repo.gitCommitAndPush("charts"), "commit charts")
+
+	// Rendered charts have the version of the templates,
+	// only values of prod changed.
+	for _, folder := range []string{"prod", "staging", "dev"} {
+		require.NoError(t, os.MkdirAll(path.Join(repo.LocalPath(), folder), 0700))
+		write(t, path.Join(repo.LocalPath(), folder, chartFile), chart("0.1.0"))
+	}
+	write(t, path.Join(repo.LocalPath(), "prod", "values.yaml"), "tag: v2\n")
+
+	cb := ChartVersionBumper{Folders: []string{"prod", "staging/", "dev", "no-chart"}, Bump: ChartBumpPatch}
+	got, err := cb.bumpChartVersions(repo)
+	require.NoError(t, err, "bumpChartVersions")
+	assert.Equal(t, []chartVersion{
+		{Folder: "prod", Version: "0.1.6"},
+		{Folder: "staging", Version: "0.3.0"},
+		{Folder: "dev", Version: "0.1.0"},
+	}, got)
+
+	for folder, want := range map[string]string{"prod": "0.1.6", "staging": "0.3.0", "dev": "0.1.0"} {
+		content, err := ioutil.ReadFile(path.Join(repo.LocalPath(), folder, chartFile))
+		require.NoError(t, err, "read chart of %s", folder)
+		assert.Equal(t, chart(want), string(content), "chart of %s", folder)
+	}
+	changes, err := repo.changes()
+	require.NoError(t, err, "changes")
+	assert.Equal(t, []fileChange{
+		{path: "prod/Chart.yaml", status: fileModified},
+		{path: "prod/values.yaml", status: fileModified},
+		{path: "dev/Chart.yaml", status: fileAdded},
+	}, changes, "unchanged staging isn't bumped")
+}
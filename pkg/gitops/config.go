@@ -2,16 +2,167 @@ package gitops
 
 import (
 	"fmt"
+	"path"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-steputils/stepconf"
 )
 
+// Modes of the step.
+const (
+	// ModeGitOps renders templates to the deploy repository.
+	ModeGitOps = "gitops"
+	// ModeRender renders templates to a local folder only.
+	ModeRender = "render"
+	// ModeVerify compares rendered templates with the deploy repository.
+	ModeVerify = "verify"
+	// ModeReplay re-renders templates recorded by a lock file to a local folder.
+	ModeReplay = "replay"
+	// ModeRollback restores deploy folders to an earlier deployment.
+	ModeRollback = "rollback"
+	// ModePromote promotes a deploy folder to another one.
+	ModePromote = "promote"
+	// ModeUpdate updates values of existing YAML files without templates.
+	ModeUpdate = "update"
+	// ModePreview renders templates to the preview environment of a pull request.
+	ModePreview = "preview"
+	// ModeTeardown removes the preview environment of a pull request.
+	ModeTeardown = "teardown"
+	// ModeFlux updates Flux HelmReleases and Kustomizations without templates.
+	ModeFlux = "flux"
+)
+
 type config struct {
+	// Mode of the step (see Mode* constants).
+	Mode string `env:"mode,opt[gitops,render,verify,replay,rollback,promote,update,preview,teardown,flux]"`
+	// RenderOutputFolder is the local folder to render templates to
+	// in render and replay modes.
+	RenderOutputFolder string `env:"render_output_path"`
+	// ReplayLockPath is the lock file to replay in replay mode.
+	ReplayLockPath string `env:"replay_lock_path"`
+	// RollbackToSourceCommit rolls back to the deployment of a source commit
+	// in rollback mode (the previous deployment by default).
+	RollbackToSourceCommit string `env:"rollback_to_source_commit"`
+	// RollbackToBuildNumber rolls back to the deployment of a build
+	// in rollback mode (the previous deployment by default).
+	RollbackToBuildNumber string `env:"rollback_to_build_number"`
+	// PromoteFrom is the deploy folder to promote in promote mode.
+	PromoteFrom string `env:"promote_from"`
+	// PromoteTo is the deploy folder to promote to in promote mode.
+	PromoteTo string `env:"promote_to"`
+	// RawPromoteKeys are unparsed version of `PromoteKeys` field.
+	RawPromoteKeys []string `env:"promote_keys"`
+	// PromoteKeys are the only values promoted (whole files without them).
+	PromoteKeys []PromoteKey
+	// PreviewNumber is the pull request number of the preview environment
+	// in preview and teardown modes.
+	PreviewNumber string `env:"preview_pr_number"`
+	// PreviewFolder is the folder of preview environments.
+	PreviewFolder string `env:"preview_path"`
+	// RawPreviewVars are unparsed version of `PreviewVars` field.
+	RawPreviewVars string `env:"preview_vars"`
+	// PreviewVars are variables of the preview environment.
+	PreviewVars map[string]interface{}
+	// PreviewTTLDays removes previews which weren't deployed for this many
+	// days in preview and teardown modes (0 disables it).
+	PreviewTTLDays int `env:"preview_ttl_days"`
+	// RawYAMLUpdates are unparsed version of `YAMLUpdates` field.
+	RawYAMLUpdates []string `env:"yaml_updates"`
+	// YAMLUpdates are values of existing YAML files of the deploy repository
+	// to update in update mode (or after rendering templates in gitops mode).
+	YAMLUpdates []YAMLUpdate
+	// KustomizeResources regenerates the resources of the kustomization of
+	// deploy folders from the rendered manifests.
+	KustomizeResources bool `env:"kustomize_resources"`
+	// KustomizeFolder is the folder of the edited kustomization
+	// (the deploy folders by default).
+	KustomizeFolder string `env:"kustomize_path"`
+	// RawKustomizeImages are unparsed version of `Kustomize.Images` field.
+	RawKustomizeImages []string `env:"kustomize_images"`
+	// KustomizeAddResources are resources added to the kustomization.
+	KustomizeAddResources []string `env:"kustomize_add_resources"`
+	// KustomizeRemoveResources are resources removed from the kustomization.
+	KustomizeRemoveResources []string `env:"kustomize_remove_resources"`
+	// RawKustomizeLabels are unparsed version of `Kustomize.CommonLabels` field.
+	RawKustomizeLabels []string `env:"kustomize_common_labels"`
+	// RawKustomizeAnnotations are unparsed version of
+	// `Kustomize.CommonAnnotations` field.
+	RawKustomizeAnnotations []string `env:"kustomize_common_annotations"`
+	// Kustomize is the edit of the kustomization in update mode
+	// (or after rendering templates in gitops mode).
+	Kustomize KustomizeEdit
+	// FluxResources are the names of the edited Flux HelmReleases and
+	// Kustomizations (`[<namespace>/]<name>`).
+	FluxResources []string `env:"flux_resources"`
+	// FluxFolder is the folder of the edited Flux resources
+	// (the deploy folders by default).
+	FluxFolder string `env:"flux_path"`
+	// RawFluxValues are unparsed version of `Flux.Values` field.
+	RawFluxValues []string `env:"flux_values"`
+	// FluxChartVersion is the chart version of the edited HelmReleases.
+	FluxChartVersion string `env:"flux_chart_version"`
+	// FluxKustomizationPath is the path of the edited Kustomizations.
+	FluxKustomizationPath string `env:"flux_kustomization_path"`
+	// FluxReconcile requests Flux to reconcile the edited resources immediately.
+	FluxReconcile bool `env:"flux_reconcile"`
+	// Flux is the edit of Flux resources in flux mode
+	// (or after rendering templates).
+	Flux FluxEdit
+	// ChartVersionBump bumps the version of charts of changed deploy folders
+	// (see ChartBump* constants).
+	ChartVersionBump string `env:"chart_version_bump,opt[none,patch,minor,major,prerelease]"`
+	// ArgoAppName is the name of the generated ArgoCD application
+	// (suffixed by the environment name with environments).
+	ArgoAppName string `env:"argocd_app_name"`
+	// ArgoApplicationSet is the name of the ApplicationSet applications
+	// are elements of (Application manifests are generated without it).
+	ArgoApplicationSet string `env:"argocd_applicationset_name"`
+	// ArgoAppsFolder is the folder of ArgoCD manifests in the deploy repository.
+	ArgoAppsFolder string `env:"argocd_apps_path"`
+	// ArgoNamespace is the namespace of ArgoCD.
+	ArgoNamespace string `env:"argocd_namespace"`
+	// ArgoProject is the ArgoCD project of the applications.
+	ArgoProject string `env:"argocd_project"`
+	// ArgoTargetRevision is the revision of the deploy repository the
+	// applications track (the deploy branch by default).
+	ArgoTargetRevision string `env:"argocd_target_revision"`
+	// ArgoDestinationNamespace is the namespace applications are deployed to.
+	ArgoDestinationNamespace string `env:"argocd_destination_namespace"`
+	// ArgoDestinationCluster is the URL or the name of the cluster
+	// applications are deployed to.
+	ArgoDestinationCluster string `env:"argocd_destination_cluster"`
+	// ArgoSyncPolicy is the sync policy of the applications (see Sync* constants).
+	ArgoSyncPolicy string `env:"argocd_sync_policy,opt[manual,automated,automated-prune,automated-prune-self-heal]"`
+	// ArgoSync syncs (or refreshes) ArgoCD applications after pushing and
+	// waits until they are healthy (see ArgoSync* constants).
+	ArgoSync string `env:"argocd_sync,opt[none,refresh,sync]"`
+	// ArgoSyncApps are the synced applications (the generated ones by default).
+	ArgoSyncApps []string `env:"argocd_sync_apps"`
+	// ArgoSyncTimeout is the timeout of waiting for the applications in seconds.
+	ArgoSyncTimeout int `env:"argocd_sync_timeout"`
+	// ArgoServerURL is the URL of the ArgoCD API server.
+	ArgoServerURL string `env:"argocd_server_url"`
+	// ArgoToken is the token of an ArgoCD account.
+	ArgoToken stepconf.Secret `env:"argocd_token"`
+	// ArgoInsecure skips the verification of the ArgoCD server certificate.
+	ArgoInsecure bool `env:"argocd_insecure"`
+	// ArgoCACertFile is a PEM file of the CA certificates of the ArgoCD server.
+	ArgoCACertFile string `env:"argocd_ca_cert_path"`
+	// ValidateManifests validates rendered Kubernetes manifests
+	// before they are pushed.
+	ValidateManifests bool `env:"validate_manifests"`
+	// KubernetesSchemasFolder is a folder of schemas of custom resources
+	// (CRDs or OpenAPI definitions).
+	KubernetesSchemasFolder string `env:"kubernetes_schemas_path"`
+	// LockFile writes a render lock file to each deploy folder.
+	LockFile bool `env:"lock_file"`
 	// DeployRepositoryURL is the URL of the deployment (GitOps) repository.
-	DeployRepositoryURL string `env:"deploy_repository_url,required"`
+	DeployRepositoryURL string `env:"deploy_repository_url"`
 	// DeployFolder is the folder to render templates to in the deploy repository.
-	DeployFolder string `env:"deploy_path,required"`
+	DeployFolder string `env:"deploy_path"`
 	// DeployBranch is the branch to render templates to in the deploy repository.
 	DeployBranch string `env:"deploy_branch,required"`
 	// PullRequest won't push to the branch. It will open a PR only instead.
@@ -22,26 +173,538 @@ type config struct {
 	PullRequestBody string `env:"pull_request_body"`
 	// RawVars are unparsed version of `Vars` field (to-be-parsed manually).
 	RawVars string `env:"vars"`
+	// VarsFile is the path to a YAML or JSON file of variables.
+	VarsFile string `env:"vars_file"`
+	// ValuesFiles are paths to YAML or JSON files of variables (later wins).
+	ValuesFiles []string `env:"values_files"`
 	// Vars are variables applied to the template files.
-	Vars map[string]string
+	Vars map[string]interface{}
+	// StrictVars treats provided but unused variables as errors.
+	StrictVars bool `env:"strict_vars"`
+	// RawEnvironments are unparsed version of `Environments` field.
+	RawEnvironments string `env:"environments"`
+	// Environments to render templates to (instead of the DeployFolder).
+	Environments []Environment
+	// Verbose enables debug logging.
+	Verbose bool `env:"verbose"`
 	// TemplatesFolder is the path to the deployment templates folder.
-	TemplatesFolder string `env:"templates_folder_path,dir"`
+	TemplatesFolder string `env:"templates_folder_path"`
+	// PartialsFolder is the path to an optional folder of shared partials.
+	PartialsFolder string `env:"partials_folder_path"`
+	// SuffixedTemplatesOnly renders only `.tmpl` and `.gotmpl` files.
+	SuffixedTemplatesOnly bool `env:"suffixed_templates_only"`
+	// VerbatimPatterns are globs of files which are copied verbatim.
+	VerbatimPatterns []string `env:"verbatim_patterns"`
+	// RawDelimiters are unparsed version of `Delimiters` field.
+	RawDelimiters []string `env:"template_delimiters"`
+	// Delimiters are custom template delimiters of matching files.
+	Delimiters []DelimitersRule
+	// HTMLEscape HTML-escapes string values substituted into templates.
+	HTMLEscape bool `env:"html_escape"`
 	// DeployPAT is the Personal Access Token to interact with Github API.
-	DeployPAT stepconf.Secret `env:"deploy_pat,required"`
+	DeployPAT stepconf.Secret `env:"deploy_pat"`
 	// CommitMessage is the created commit's message.
 	CommitMessage string `env:"commit_message,required"`
+	// DryRun only shows the diff of the would-be change.
+	DryRun bool `env:"dry_run"`
+	// DiffPath is the file the diff is written to in dry-run mode.
+	DiffPath string `env:"diff_path"`
+	// SummaryPath is the JSON file the step outputs are written to.
+	SummaryPath string `env:"summary_path"`
+	// ReportPath is the JSON file the run report is written to.
+	ReportPath string `env:"report_path"`
+	// HistoryLedger appends each change to a ledger in the deploy folder.
+	HistoryLedger bool `env:"history_ledger"`
+	// HistoryMaxEntries caps the length of the history ledger.
+	HistoryMaxEntries int `env:"history_max_entries"`
+	// SourceRepository is the URL of the templates (source) repository.
+	SourceRepository string `env:"source_repository_url"`
+	// SourceCommit is the commit of the templates (source) repository.
+	SourceCommit string `env:"source_commit"`
+	// BuildURL is the URL of the CI build.
+	BuildURL string `env:"build_url"`
+	// BuildNumber is the number of the CI build.
+	BuildNumber string `env:"build_number"`
+	// Actor is who triggered the change.
+	Actor string `env:"actor"`
+	// VerifyFailOnDrift fails in verify mode if the deploy repository drifted.
+	VerifyFailOnDrift bool `env:"verify_fail_on_drift"`
 }
 
 // NewConfig returns a new configuration initialized from environment variables.
 func NewConfig() (config, error) {
+	cfg, err := parseConfig()
+	if err != nil {
+		return config{}, classify(errorClassConfig, err)
+	}
+	return cfg, nil
+}
+
+// parseConfig parses and validates the configuration.
+func parseConfig() (config, error) {
 	var cfg config
 	if err := stepconf.Parse(&cfg); err != nil {
 		return config{}, fmt.Errorf("parse step config: %w", err)
 	}
-	cfg.Vars = parseMap(cfg.RawVars)
+	vars, err := parseVars(cfg.RawVars)
+	if err != nil {
+		return config{}, fmt.Errorf("parse vars: %w", err)
+	}
+	cfg.Vars = vars
+	cfg.ValuesFiles = nonEmpty(cfg.ValuesFiles)
+	cfg.VarsFile = strings.TrimSpace(cfg.VarsFile)
+	delimiters, err := parseDelimitersRules(cfg.RawDelimiters)
+	if err != nil {
+		return config{}, fmt.Errorf("parse template delimiters: %w", err)
+	}
+	cfg.Delimiters = delimiters
+	promoteKeys, err := ParsePromoteKeys(cfg.RawPromoteKeys)
+	if err != nil {
+		return config{}, fmt.Errorf("parse promote keys: %w", err)
+	}
+	cfg.PromoteKeys = promoteKeys
+	yamlUpdates, err := ParseYAMLUpdates(cfg.RawYAMLUpdates)
+	if err != nil {
+		return config{}, fmt.Errorf("parse yaml updates: %w", err)
+	}
+	cfg.YAMLUpdates = yamlUpdates
+	kustomize, err := cfg.kustomizeEdit()
+	if err != nil {
+		return config{}, fmt.Errorf("parse kustomize inputs: %w", err)
+	}
+	cfg.Kustomize = kustomize
+	flux, err := cfg.fluxEdit(time.Now())
+	if err != nil {
+		return config{}, fmt.Errorf("parse flux inputs: %w", err)
+	}
+	cfg.Flux = flux
+	cfg.FluxResources = nonEmpty(cfg.FluxResources)
+	envs, err := parseEnvironments(cfg.RawEnvironments)
+	if err != nil {
+		return config{}, fmt.Errorf("parse environments: %w", err)
+	}
+	cfg.Environments = envs
+	previewVars, err := parseVars(cfg.RawPreviewVars)
+	if err != nil {
+		return config{}, fmt.Errorf("parse preview vars: %w", err)
+	}
+	cfg.PreviewVars = previewVars
+	// The preview is the only environment in preview and teardown modes.
+	if cfg.Mode == ModePreview || cfg.Mode == ModeTeardown {
+		if len(cfg.Environments) > 0 {
+			return config{}, fmt.Errorf("environments aren't supported in %s mode", cfg.Mode)
+		}
+		cfg.Environments = cfg.previewEnvironments()
+	}
+	if err := cfg.validate(); err != nil {
+		return config{}, fmt.Errorf("validate step config: %w", err)
+	}
 	return cfg, nil
 }
 
+// validate checks inputs required by the mode of the step.
+func (cfg config) validate() error {
+	if cfg.rendersTemplates() && cfg.TemplatesFolder == "" {
+		return fmt.Errorf("templates_folder_path is required in %s mode", cfg.Mode)
+	}
+	if cfg.Mode == ModeRender || cfg.Mode == ModeReplay {
+		if cfg.RenderOutputFolder == "" {
+			return fmt.Errorf("render_output_path is required in %s mode", cfg.Mode)
+		}
+		if cfg.Mode == ModeReplay && cfg.ReplayLockPath == "" {
+			return fmt.Errorf("replay_lock_path is required in %s mode", cfg.Mode)
+		}
+		return nil
+	}
+	if cfg.DeployRepositoryURL == "" {
+		return fmt.Errorf("deploy_repository_url is required")
+	}
+	if cfg.DeployPAT == "" {
+		return fmt.Errorf("deploy_pat is required")
+	}
+	if cfg.Mode == ModePromote {
+		if cfg.PromoteFrom == "" || cfg.PromoteTo == "" {
+			return fmt.Errorf("promote_from and promote_to are required in %s mode", cfg.Mode)
+		}
+		if filepath.Clean(cfg.PromoteFrom) == filepath.Clean(cfg.PromoteTo) {
+			return fmt.Errorf("promote_from and promote_to must differ")
+		}
+	} else if cfg.Mode == ModePreview || cfg.Mode == ModeTeardown {
+		if cfg.PreviewNumber == "" && (cfg.Mode == ModePreview || cfg.PreviewTTLDays == 0) {
+			return fmt.Errorf("preview_pr_number is required in %s mode", cfg.Mode)
+		}
+		if cfg.PreviewNumber != "" && !previewNumberPattern.MatchString(cfg.PreviewNumber) {
+			return fmt.Errorf("preview_pr_number must be a number: %q", cfg.PreviewNumber)
+		}
+		if cfg.PreviewFolder == "" {
+			return fmt.Errorf("preview_path is required in %s mode", cfg.Mode)
+		}
+	} else if cfg.Mode == ModeUpdate {
+		if len(cfg.YAMLUpdates) == 0 && cfg.Kustomize.Empty() {
+			return fmt.Errorf("either yaml_updates or kustomize_* inputs are required in %s mode", cfg.Mode)
+		}
+	} else if cfg.Mode == ModeFlux {
+		if len(cfg.FluxResources) == 0 {
+			return fmt.Errorf("flux_resources is required in %s mode", cfg.Mode)
+		}
+	} else if cfg.DeployFolder == "" && len(cfg.Environments) == 0 {
+		return fmt.Errorf("either deploy_path or environments is required")
+	}
+	if len(cfg.FluxResources) > 0 && cfg.Flux.Empty() {
+		return fmt.Errorf("flux_resources requires flux_values, flux_chart_version, flux_kustomization_path or flux_reconcile")
+	}
+	if len(cfg.FluxResources) == 0 && !cfg.Flux.Empty() {
+		return fmt.Errorf("flux_resources is required by flux_* inputs")
+	}
+	if cfg.RollbackToSourceCommit != "" && cfg.RollbackToBuildNumber != "" {
+		return fmt.Errorf("only one of rollback_to_source_commit and rollback_to_build_number can be given")
+	}
+	if cfg.ChartVersionBump == ChartBumpPrerelease && cfg.BuildNumber == "" {
+		return fmt.Errorf("build_number is required by %s chart version bumps", ChartBumpPrerelease)
+	}
+	if cfg.ArgoAppName == "" && cfg.ArgoApplicationSet != "" {
+		return fmt.Errorf("argocd_app_name is required by argocd_applicationset_name")
+	}
+	if cfg.GeneratesArgoApplications() && len(cfg.DeployFolders()) == 0 {
+		return fmt.Errorf("argocd_app_name requires deploy_path or environments in %s mode", cfg.Mode)
+	}
+	if cfg.SyncsArgoApplications() {
+		if cfg.ArgoServerURL == "" || cfg.ArgoToken == "" {
+			return fmt.Errorf("argocd_server_url and argocd_token are required by argocd_sync")
+		}
+		if len(cfg.ArgoSyncApplications()) == 0 {
+			return fmt.Errorf("either argocd_sync_apps or argocd_app_name is required by argocd_sync")
+		}
+		if cfg.ArgoSyncTimeout < 0 {
+			return fmt.Errorf("argocd_sync_timeout can't be negative")
+		}
+	}
+	if cfg.PreviewTTLDays < 0 {
+		return fmt.Errorf("preview_ttl_days can't be negative")
+	}
+	if cfg.HistoryMaxEntries < 0 {
+		return fmt.Errorf("history_max_entries can't be negative")
+	}
+	return nil
+}
+
+// rendersTemplates tells whether templates are rendered in the mode of the step.
+func (cfg config) rendersTemplates() bool {
+	switch cfg.Mode {
+	case ModeRollback, ModePromote, ModeUpdate, ModeTeardown, ModeFlux:
+		return false
+	}
+	return true
+}
+
+// DeployFolders returns all deploy folders templates are rendered to
+// (or the folder promoted to in promote mode). Files of the whole deploy
+// repository are updated in update and flux modes, unless a deploy folder
+// is given.
+// Only torn down previews are removed in teardown mode (expired ones
+// aren't known in advance).
+func (cfg config) DeployFolders() []string {
+	if cfg.Mode == ModePromote {
+		return []string{cfg.PromoteTo}
+	}
+	if (cfg.Mode == ModeUpdate || cfg.Mode == ModeFlux || cfg.Mode == ModeTeardown) && cfg.DeployFolder == "" && len(cfg.Environments) == 0 {
+		return nil
+	}
+	if len(cfg.Environments) == 0 {
+		return []string{cfg.DeployFolder}
+	}
+	var folders []string
+	for _, env := range cfg.Environments {
+		folders = append(folders, env.DeployPath)
+	}
+	return folders
+}
+
+// KustomizeFolders returns the folders of edited kustomizations.
+func (cfg config) KustomizeFolders() []string {
+	if cfg.KustomizeFolder != "" {
+		return []string{cfg.KustomizeFolder}
+	}
+	if folders := cfg.DeployFolders(); len(folders) > 0 {
+		return folders
+	}
+	return []string{"."}
+}
+
+// FluxFolders returns the folders of edited Flux resources.
+func (cfg config) FluxFolders() []string {
+	if cfg.FluxFolder != "" {
+		return []string{cfg.FluxFolder}
+	}
+	if folders := cfg.DeployFolders(); len(folders) > 0 {
+		return folders
+	}
+	return []string{"."}
+}
+
+// Updaters returns the in-place updaters of existing files of the deploy
+// repository (cloned to a root folder).
+func (cfg config) Updaters(root string) Renderers {
+	var updaters Renderers
+	if len(cfg.YAMLUpdates) > 0 {
+		updaters = append(updaters, YAMLUpdater{Root: root, Updates: cfg.YAMLUpdates})
+	}
+	if !cfg.Kustomize.Empty() {
+		updaters = append(updaters, KustomizeUpdater{
+			Root:    root,
+			Folders: cfg.KustomizeFolders(),
+			Edit:    cfg.Kustomize,
+		})
+	}
+	if len(cfg.FluxResources) > 0 {
+		updaters = append(updaters, FluxUpdater{
+			Root:      root,
+			Folders:   cfg.FluxFolders(),
+			Resources: cfg.FluxResources,
+			Edit:      cfg.Flux,
+		})
+	}
+	return updaters
+}
+
+// kustomizeEdit returns the edit of kustomizations given by the inputs.
+func (cfg config) kustomizeEdit() (KustomizeEdit, error) {
+	images, err := ParseKustomizeImages(cfg.RawKustomizeImages)
+	if err != nil {
+		return KustomizeEdit{}, fmt.Errorf("images: %w", err)
+	}
+	labels, err := parseKeyValues(cfg.RawKustomizeLabels)
+	if err != nil {
+		return KustomizeEdit{}, fmt.Errorf("common labels: %w", err)
+	}
+	annotations, err := parseKeyValues(cfg.RawKustomizeAnnotations)
+	if err != nil {
+		return KustomizeEdit{}, fmt.Errorf("common annotations: %w", err)
+	}
+	return KustomizeEdit{
+		Images:            images,
+		AddResources:      nonEmpty(cfg.KustomizeAddResources),
+		RemoveResources:   nonEmpty(cfg.KustomizeRemoveResources),
+		CommonLabels:      labels,
+		CommonAnnotations: annotations,
+	}, nil
+}
+
+// fluxEdit returns the edit of Flux resources given by the inputs.
+// A reconciliation is requested at the given time.
+func (cfg config) fluxEdit(now time.Time) (FluxEdit, error) {
+	values, err := parseKeyValues(cfg.RawFluxValues)
+	if err != nil {
+		return FluxEdit{}, fmt.Errorf("values: %w", err)
+	}
+	for key := range values {
+		if _, err := parseKeyPath(key); err != nil {
+			return FluxEdit{}, fmt.Errorf("values: %w", err)
+		}
+	}
+	edit := FluxEdit{
+		Values:       values,
+		ChartVersion: cfg.FluxChartVersion,
+		Path:         cfg.FluxKustomizationPath,
+	}
+	if cfg.FluxReconcile {
+		edit.RequestedAt = now.UTC().Format(time.RFC3339)
+	}
+	return edit, nil
+}
+
+// nonEmpty returns the trimmed non-empty strings of a list.
+func nonEmpty(a []string) []string {
+	var result []string
+	for _, s := range a {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// BumpsChartVersion tells whether chart versions are bumped.
+func (cfg config) BumpsChartVersion() bool {
+	return cfg.ChartVersionBump != "" && cfg.ChartVersionBump != ChartBumpNone
+}
+
+// GeneratesArgoApplications tells whether ArgoCD applications are generated
+// (previews always have one, torn down ones are removed instead).
+func (cfg config) GeneratesArgoApplications() bool {
+	if cfg.Mode == ModeTeardown {
+		return false
+	}
+	return cfg.ArgoAppName != "" || cfg.Mode == ModePreview
+}
+
+// argoAppName returns the name of the generated ArgoCD application
+// (the name prefix of previews is `preview` by default).
+func (cfg config) argoAppName() string {
+	if cfg.ArgoAppName == "" && (cfg.Mode == ModePreview || cfg.Mode == ModeTeardown) {
+		return "preview"
+	}
+	return cfg.ArgoAppName
+}
+
+// ArgoApplications returns the generator of the ArgoCD applications of
+// deploy folders in the deploy repository (cloned to a root folder). Each
+// environment has its own application.
+func (cfg config) ArgoApplications(root string) ArgoApplications {
+	revision := cfg.ArgoTargetRevision
+	if revision == "" {
+		revision = cfg.DeployBranch
+	}
+	app := func(name, folder string) ArgoApplication {
+		a := ArgoApplication{
+			Name:           name,
+			Project:        cfg.ArgoProject,
+			RepoURL:        repoURLWithoutCredentials(cfg.DeployRepositoryURL),
+			TargetRevision: revision,
+			Path:           filepath.ToSlash(filepath.Clean(folder)),
+			Namespace:      cfg.ArgoDestinationNamespace,
+			Cluster:        cfg.ArgoDestinationCluster,
+			SyncPolicy:     cfg.ArgoSyncPolicy,
+		}
+		// Previews are deployed to their own namespace,
+		// which is deleted together with the preview.
+		if cfg.Mode == ModePreview {
+			a.Namespace = name
+			a.CreateNamespace = true
+			a.DeleteResources = true
+		}
+		return a
+	}
+	var apps []ArgoApplication
+	if cfg.Mode != ModePromote && len(cfg.Environments) > 0 {
+		for _, env := range cfg.Environments {
+			apps = append(apps, app(cfg.argoAppName()+"-"+env.Name, env.DeployPath))
+		}
+	} else {
+		for _, folder := range cfg.DeployFolders() {
+			apps = append(apps, app(cfg.argoAppName(), folder))
+		}
+	}
+	return ArgoApplications{
+		Root:           root,
+		Folder:         cfg.ArgoAppsFolder,
+		Namespace:      cfg.ArgoNamespace,
+		ApplicationSet: cfg.ArgoApplicationSet,
+		Applications:   apps,
+	}
+}
+
+// SyncsArgoApplications tells whether ArgoCD applications are synced
+// (or refreshed) after pushing. Removed previews aren't synced.
+func (cfg config) SyncsArgoApplications() bool {
+	return cfg.ArgoSync != "" && cfg.ArgoSync != ArgoSyncNone && cfg.Mode != ModeTeardown
+}
+
+// ArgoSyncApplications returns the names of the synced ArgoCD applications.
+func (cfg config) ArgoSyncApplications() []string {
+	if apps := nonEmpty(cfg.ArgoSyncApps); len(apps) > 0 {
+		return apps
+	}
+	if !cfg.GeneratesArgoApplications() {
+		return nil
+	}
+	var names []string
+	for _, app := range cfg.ArgoApplications("").Applications {
+		names = append(names, app.Name)
+	}
+	return names
+}
+
+// previewEnvironments returns the preview environment of the pull request
+// (if its number is given).
+func (cfg config) previewEnvironments() []Environment {
+	if cfg.PreviewNumber == "" {
+		return nil
+	}
+	return []Environment{{
+		Name:       PreviewName(cfg.PreviewNumber),
+		DeployPath: path.Join(filepath.ToSlash(filepath.Clean(cfg.PreviewFolder)), cfg.PreviewNumber),
+		Vars:       cfg.PreviewVars,
+	}}
+}
+
+// SweepsPreviews tells whether expired previews are removed.
+func (cfg config) SweepsPreviews() bool {
+	return (cfg.Mode == ModePreview || cfg.Mode == ModeTeardown) && cfg.PreviewTTLDays > 0
+}
+
+// PreviewRemover returns the remover of the torn down preview (in teardown
+// mode) and the expired ones of the deploy repository.
+func (cfg config) PreviewRemover(repo repositorier) *PreviewRemover {
+	pr := &PreviewRemover{
+		Repo:   repo,
+		Folder: cfg.PreviewFolder,
+		TTL:    time.Duration(cfg.PreviewTTLDays) * 24 * time.Hour,
+		Applications: ArgoApplications{
+			Root:           repo.LocalPath(),
+			Folder:         cfg.ArgoAppsFolder,
+			ApplicationSet: cfg.ArgoApplicationSet,
+		},
+		AppName: cfg.argoAppName(),
+	}
+	if cfg.Mode == ModeTeardown && cfg.PreviewNumber != "" {
+		pr.Numbers = []string{cfg.PreviewNumber}
+	} else {
+		pr.Keep = cfg.PreviewNumber
+	}
+	return pr
+}
+
+// Build returns the source and build of the change.
+func (cfg config) Build() BuildInfo {
+	return BuildInfo{
+		SourceRepository: redactURL(cfg.SourceRepository),
+		SourceCommit:     cfg.SourceCommit,
+		BuildURL:         cfg.BuildURL,
+		BuildNumber:      cfg.BuildNumber,
+		Actor:            cfg.Actor,
+	}
+}
+
+// ReportInputs returns all step inputs by their names for the run report.
+// Secret inputs, credentials of URLs and secret looking variables and values
+// (like in lock files) are redacted.
+func (cfg config) ReportInputs() map[string]string {
+	inputs := map[string]string{}
+	v := reflect.ValueOf(cfg)
+	for i := 0; i < v.NumField(); i++ {
+		tag := v.Type().Field(i).Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		// Variables and values may have inline secrets.
+		switch name {
+		case "vars", "preview_vars":
+			inputs[name] = maskVars(v.Field(i).String())
+			continue
+		case "environments":
+			inputs[name] = maskEnvironments(v.Field(i).String())
+			continue
+		case "flux_values", "yaml_updates":
+			inputs[name] = strings.Join(maskKeyValues(v.Field(i).Interface().([]string)), "|")
+			continue
+		}
+		switch value := v.Field(i).Interface().(type) {
+		case stepconf.Secret:
+			inputs[name] = ""
+			if value != "" {
+				inputs[name] = redacted
+			}
+		case []string:
+			inputs[name] = strings.Join(value, "|")
+		default:
+			inputs[name] = redactURL(fmt.Sprint(value))
+		}
+	}
+	return inputs
+}
+
 // parseMap returns a deserialized map[string]string from a given string.
 // Assumption: keys don't contain spaces, values can.
 // (it cannot be confidently deserialized if we allow both)
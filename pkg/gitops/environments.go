@@ -0,0 +1,112 @@
+package gitops
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment is a deploy target of the same templates with it's own
+// deploy folder and variables.
+type Environment struct {
+	// Name of the environment (e.g. staging).
+	Name string `yaml:"name"`
+	// DeployPath is the folder to render templates to in the deploy repository.
+	DeployPath string `yaml:"deploy_path"`
+	// ValuesFiles are values files of the environment (later wins).
+	ValuesFiles []string `yaml:"values_files"`
+	// Vars are inline variables of the environment.
+	Vars map[string]interface{} `yaml:"vars"`
+}
+
+// parseEnvironments returns environments deserialized from a YAML (or JSON) list.
+func parseEnvironments(s string) ([]Environment, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var envs []Environment
+	if err := yaml.Unmarshal([]byte(s), &envs); err != nil {
+		return nil, fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	names := map[string]bool{}
+	for i, env := range envs {
+		if env.Name == "" {
+			return nil, fmt.Errorf("environment #%d: name is required", i)
+		}
+		if env.DeployPath == "" {
+			return nil, fmt.Errorf("environment %q: deploy_path is required", env.Name)
+		}
+		if names[env.Name] {
+			return nil, fmt.Errorf("environment %q: name is not unique", env.Name)
+		}
+		names[env.Name] = true
+	}
+	return envs, nil
+}
+
+// EnvironmentsRenderer renders the same templates to multiple environments.
+type EnvironmentsRenderer struct {
+	// Templates is the renderer of templates shared by all environments.
+	// It's destination folder is overridden by each environment.
+	Templates TemplatesRenderer
+	// Environments to render the templates to.
+	Environments []Environment
+}
+
+// environmentsRenderer implements the renderAllFileser interface.
+var _ renderAllFileser = (*EnvironmentsRenderer)(nil)
+
+func (er EnvironmentsRenderer) renderAllFiles() ([]string, error) {
+	var rendered []string
+	for _, env := range er.Environments {
+		files, err := er.renderer(env).renderAllFiles()
+		if err != nil {
+			return nil, fmt.Errorf("environment %q: %w", env.Name, err)
+		}
+		rendered = append(rendered, files...)
+	}
+	return rendered, nil
+}
+
+// renderer returns a templates renderer of a given environment.
+// Values files and inline variables of the environment are merged after all
+// shared variables (values files, the vars file and inline vars).
+func (er EnvironmentsRenderer) renderer(env Environment) TemplatesRenderer {
+	tr := er.Templates
+	tr.DestinationFolder = env.DeployPath
+	tr.EnvironmentValuesFiles = env.ValuesFiles
+	tr.EnvironmentVars = env.Vars
+	return tr
+}
+
+// environmentsSummary returns a markdown summary of changes per environment.
+func environmentsSummary(envs []Environment, changes []fileChange) string {
+	var b strings.Builder
+	for _, env := range envs {
+		fmt.Fprintf(&b, "### %s (`%s`)\n", env.Name, env.DeployPath)
+		var n int
+		for _, c := range changes {
+			if !inFolder(c.path, env.DeployPath) {
+				continue
+			}
+			fmt.Fprintf(&b, "- %s `%s`\n", c.status, c.path)
+			n++
+		}
+		if n == 0 {
+			b.WriteString("No changes.\n")
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// inFolder tells whether a slash separated path is inside a given folder.
+func inFolder(path, folder string) bool {
+	folder = filepath.ToSlash(filepath.Clean(folder))
+	if folder == "." {
+		return true
+	}
+	return strings.HasPrefix(path, folder+"/")
+}
@@ -0,0 +1,148 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var parseEnvironmentsCases = map[string]struct {
+	s       string
+	want    []Environment
+	wantErr bool
+}{
+	"no environments": {
+		s: "",
+	},
+	"multiple environments": {
+		s: `
+- name: staging
+  deploy_path: apps/staging
+  values_files: [values/staging.yaml]
+- name: prod
+  deploy_path: apps/prod
+  vars:
+    image:
+      tag: v1
+`,
+		want: []Environment{
+			{
+				Name:        "staging",
+				DeployPath:  "apps/staging",
+				ValuesFiles: []string{"values/staging.yaml"},
+			},
+			{
+				Name:       "prod",
+				DeployPath: "apps/prod",
+				Vars: map[string]interface{}{
+					"image": map[string]interface{}{"tag": "v1"},
+				},
+			},
+		},
+	},
+	"name is missing (error)": {
+		s:       "- deploy_path: apps/staging\n",
+		wantErr: true,
+	},
+	"deploy path is missing (error)": {
+		s:       "- name: staging\n",
+		wantErr: true,
+	},
+	"names are not unique (error)": {
+		s:       "- {name: a, deploy_path: a}\n- {name: a, deploy_path: b}\n",
+		wantErr: true,
+	},
+}
+
+func TestParseEnvironments(t *testing.T) {
+	for name, tc := range parseEnvironmentsCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseEnvironments(tc.s)
+			if tc.wantErr {
+				require.Error(t, err, "parseEnvironments")
+				return
+			}
+			require.NoError(t, err, "parseEnvironments")
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestEnvironmentsRenderAllFiles(t *testing.T) {
+	// Create temporary directory for templates (and values files).
+	templatesDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp templates dir")
+	defer os.RemoveAll(templatesDir)
+	write(t, path.Join(templatesDir, "values.yaml"), templateValuesYAML)
+
+	valuesDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp values dir")
+	defer os.RemoveAll(valuesDir)
+	prodValuesPath := path.Join(valuesDir, "prod.yaml")
+	write(t, prodValuesPath, "repository: foo\n")
+	varsFilePath := path.Join(valuesDir, "vars.yaml")
+	write(t, varsFilePath, "repository: myrepo\ntag: mytag\n")
+
+	// Create a mock temporary directory for local clone of repository.
+	renderRepo, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp render repo")
+	defer os.RemoveAll(renderRepo)
+	for _, folder := range []string{"staging", "prod"} {
+		require.NoError(t, os.Mkdir(path.Join(renderRepo, folder), 0700))
+	}
+
+	er := EnvironmentsRenderer{
+		Templates: TemplatesRenderer{
+			SourceFolder:    templatesDir,
+			VarsFile:        varsFilePath,
+			DestinationRoot: renderRepo,
+		},
+		Environments: []Environment{
+			{
+				Name:       "staging",
+				DeployPath: "staging",
+			},
+			{
+				Name:        "prod",
+				DeployPath:  "prod",
+				ValuesFiles: []string{prodValuesPath},
+				Vars:        map[string]interface{}{"tag": "bar"},
+			},
+		},
+	}
+	_, err = er.renderAllFiles()
+	require.NoError(t, err, "environmentsRenderer.renderAllFiles")
+
+	got, err := ioutil.ReadFile(path.Join(renderRepo, "staging", "values.yaml"))
+	require.NoError(t, err, "read staging values.yaml")
+	assert.Equal(t, myRenderedValuesYAML, string(got), "staging values.yaml")
+
+	// Values files of the environment win over the shared vars file, inline
+	// vars of the environment win over both.
+	got, err = ioutil.ReadFile(path.Join(renderRepo, "prod", "values.yaml"))
+	require.NoError(t, err, "read prod values.yaml")
+	assert.Equal(t, "---\napi-service:\n  image:\n    name: \"foo:bar\"\n", string(got), "prod values.yaml")
+}
+
+func TestEnvironmentsSummary(t *testing.T) {
+	envs := []Environment{
+		{Name: "staging", DeployPath: "apps/staging"},
+		{Name: "prod", DeployPath: "apps/prod/"},
+	}
+	changes := []fileChange{
+		{path: "apps/staging/values.yaml", status: fileModified},
+		{path: "apps/staging/ingress.yaml", status: fileAdded},
+		{path: "apps/staging-old/values.yaml", status: fileDeleted},
+	}
+	want := "### staging (`apps/staging`)\n" +
+		"- modified `apps/staging/values.yaml`\n" +
+		"- added `apps/staging/ingress.yaml`\n" +
+		"\n" +
+		"### prod (`apps/prod/`)\n" +
+		"No changes.\n"
+	assert.Equal(t, want, environmentsSummary(envs, changes))
+}
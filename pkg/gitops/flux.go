@@ -0,0 +1,221 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Flux resources edited by the FluxUpdater.
+const (
+	fluxHelmRelease   = "HelmRelease"
+	fluxKustomization = "Kustomization"
+	// fluxAPIGroup is the suffix of the API groups of Flux resources.
+	fluxAPIGroup = "toolkit.fluxcd.io/"
+	// fluxReconcileAnnotation requests Flux to reconcile a resource
+	// immediately (instead of waiting for its next interval).
+	fluxReconcileAnnotation = "reconcile.fluxcd.io/requestedAt"
+)
+
+// FluxEdit is an edit of Flux HelmReleases and Kustomizations.
+type FluxEdit struct {
+	// Values are set in the values of HelmReleases by their key paths
+	// (e.g. `image.tag`).
+	Values map[string]string
+	// ChartVersion is the chart version of HelmReleases.
+	ChartVersion string
+	// Path is the path of Kustomizations.
+	Path string
+	// RequestedAt is the time of the requested reconciliation of edited
+	// resources (they aren't annotated without it).
+	RequestedAt string
+}
+
+// reconcileOnly tells whether the edit only requests a reconciliation.
+func (e FluxEdit) reconcileOnly() bool {
+	return len(e.Values) == 0 && e.ChartVersion == "" && e.Path == "" && e.RequestedAt != ""
+}
+
+// Empty tells whether the edit doesn't change anything.
+func (e FluxEdit) Empty() bool {
+	return len(e.Values) == 0 && e.ChartVersion == "" && e.Path == "" && e.RequestedAt == ""
+}
+
+// FluxUpdater edits Flux HelmReleases and Kustomizations (found in YAML files
+// of folders by their names) in place, so the diff of the change is minimal.
+type FluxUpdater struct {
+	// Root is the root folder of the resources (e.g. the local clone).
+	Root string
+	// Folders searched for the resources (relative to the root).
+	Folders []string
+	// Resources are the names of the edited resources, each of them is
+	// either `<name>` or `<namespace>/<name>`.
+	Resources []string
+	// Edit of the resources.
+	Edit FluxEdit
+}
+
+// FluxUpdater implements the renderAllFileser interface.
+var _ renderAllFileser = (*FluxUpdater)(nil)
+
+// fluxResource identifies a Flux resource.
+type fluxResource struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// renderAllFiles edits the resources and returns slash separated paths of
+// their files. All resources must be found.
+func (fu FluxUpdater) renderAllFiles() ([]string, error) {
+	found := map[string]bool{}
+	var files []string
+	for _, folder := range fu.Folders {
+		folder = filepath.ToSlash(filepath.Clean(folder))
+		contents, err := folderFiles(filepath.Join(fu.Root, filepath.FromSlash(folder)))
+		if err != nil {
+			return nil, fmt.Errorf("read folder %q: %w", folder, err)
+		}
+		names := make([]string, 0, len(contents))
+		for name := range contents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			file := path.Join(folder, name)
+			ext := path.Ext(name)
+			if (ext != ".yaml" && ext != ".yml") || !bytes.Contains(contents[name], []byte(fluxAPIGroup)) {
+				continue
+			}
+			edited, err := fu.editFile(contents[name], found)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			if bytes.Equal(edited, contents[name]) {
+				continue
+			}
+			if err := ioutil.WriteFile(filepath.Join(fu.Root, filepath.FromSlash(file)), edited, 0644); err != nil {
+				return nil, fmt.Errorf("write %s: %w", file, err)
+			}
+			files = append(files, file)
+		}
+	}
+	for _, r := range fu.Resources {
+		if !found[r] {
+			return nil, fmt.Errorf("flux resource %q not found in %s", r, strings.Join(fu.Folders, ", "))
+		}
+	}
+	return files, nil
+}
+
+// editFile edits all matching resources of a (multi-document) YAML file and
+// records which of the resources are found.
+func (fu FluxUpdater) editFile(content []byte, found map[string]bool) ([]byte, error) {
+	return editYAMLDocuments(content, func(doc []byte) ([]byte, error) {
+		docs, err := parseYAMLDocuments(doc)
+		if err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", err)
+		}
+		if len(docs) == 0 {
+			return doc, nil
+		}
+		r, ok := fluxResourceOf(docs[0])
+		if !ok {
+			return doc, nil
+		}
+		matched := false
+		for _, name := range fu.Resources {
+			if name == r.name || name == r.namespace+"/"+r.name {
+				found[name], matched = true, true
+			}
+		}
+		if !matched {
+			return doc, nil
+		}
+		edited, err := editFluxResource(doc, r.kind, fu.Edit)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", r.kind, r.name, err)
+		}
+		return edited, nil
+	})
+}
+
+// fluxResourceOf returns the HelmRelease or Kustomization of a document
+// (false if it isn't one of them).
+func fluxResourceOf(doc *yaml.Node) (fluxResource, bool) {
+	text := func(keyPath ...string) string {
+		node := findYAMLNode(doc, keyPath)
+		if node == nil || node.Kind != yaml.ScalarNode {
+			return ""
+		}
+		return node.Value
+	}
+	r := fluxResource{kind: text("kind"), namespace: text("metadata", "namespace"), name: text("metadata", "name")}
+	apiVersion := text("apiVersion")
+	switch {
+	case r.kind == fluxHelmRelease && strings.HasPrefix(apiVersion, "helm."+fluxAPIGroup):
+	case r.kind == fluxKustomization && strings.HasPrefix(apiVersion, "kustomize."+fluxAPIGroup):
+	default:
+		return fluxResource{}, false
+	}
+	return r, r.name != ""
+}
+
+// editFluxResource edits the YAML document of a HelmRelease or Kustomization.
+// Values and the chart version are set in HelmReleases only, the path in
+// Kustomizations only. Both are annotated by the requested reconciliation if
+// they were changed (or if only the reconciliation is requested), so
+// unchanged resources aren't committed again and again.
+func editFluxResource(doc []byte, kind string, edit FluxEdit) ([]byte, error) {
+	original := doc
+	var err error
+	if kind == fluxHelmRelease {
+		keys := make([]string, 0, len(edit.Values))
+		for key := range edit.Values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath, err := parseKeyPath(key)
+			if err != nil {
+				return nil, err
+			}
+			value := edit.Values[key]
+			if doc, err = setYAMLPath(doc, append([]string{"spec", "values"}, keyPath...), value, resolveTag(value)); err != nil {
+				return nil, fmt.Errorf("set value %s: %w", key, err)
+			}
+		}
+		if edit.ChartVersion != "" {
+			root, err := yamlRoot(doc)
+			if err != nil {
+				return nil, err
+			}
+			// Releases of chart references (instead of chart templates)
+			// are versioned by the referenced resource.
+			if findYAMLNode(root, []string{"spec", "chart", "spec"}) == nil {
+				return nil, fmt.Errorf("chart version can't be set without spec.chart.spec")
+			}
+			if doc, err = setYAMLPath(doc, []string{"spec", "chart", "spec", "version"}, edit.ChartVersion, "!!str"); err != nil {
+				return nil, fmt.Errorf("set chart version: %w", err)
+			}
+		}
+	}
+	if kind == fluxKustomization && edit.Path != "" {
+		if doc, err = setYAMLPath(doc, []string{"spec", "path"}, edit.Path, "!!str"); err != nil {
+			return nil, fmt.Errorf("set path: %w", err)
+		}
+	}
+	if edit.RequestedAt != "" && (edit.reconcileOnly() || !bytes.Equal(doc, original)) {
+		keyPath := []string{"metadata", "annotations", fluxReconcileAnnotation}
+		if doc, err = setYAMLPath(doc, keyPath, edit.RequestedAt, "!!str"); err != nil {
+			return nil, fmt.Errorf("request reconciliation: %w", err)
+		}
+	}
+	return doc, nil
+}
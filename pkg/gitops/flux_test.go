@@ -0,0 +1,199 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const shopRelease = `# Shop API
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: shop
+spec:
+  url: https://charts.acme.dev
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: api
+  namespace: shop
+spec:
+  interval: 5m
+  chart:
+    spec:
+      chart: api
+      version: "1.2.0" # pinned
+  values:
+    replicas: 2
+    image:
+      tag: v1.0.0
+`
+
+const shopKustomization = `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: shop
+  namespace: flux-system
+  annotations:
+    reconcile.fluxcd.io/requestedAt: "2024-01-01T00:00:00Z"
+spec:
+  path: ./shop/v1
+  prune: true
+`
+
+var fluxUpdaterCases = map[string]struct {
+	resources []string
+	edit      FluxEdit
+	wantFiles []string
+	want      map[string]string
+	wantErr   bool
+}{
+	"helm release values and chart version": {
+		resources: []string{"shop/api"},
+		edit: FluxEdit{
+			Values:       map[string]string{"image.tag": "v1.1.0", "replicas": "3", "ingress.host": "shop.acme.dev"},
+			ChartVersion: "1.3.0",
+		},
+		wantFiles: []string{"clusters/prod/api.yaml"},
+		want: map[string]string{"clusters/prod/api.yaml": `# Shop API
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: shop
+spec:
+  url: https://charts.acme.dev
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: api
+  namespace: shop
+spec:
+  interval: 5m
+  chart:
+    spec:
+      chart: api
+      version: "1.3.0" # pinned
+  values:
+    replicas: 3
+    image:
+      tag: v1.1.0
+    ingress:
+      host: shop.acme.dev
+`},
+	},
+	"kustomization path and reconciliation": {
+		resources: []string{"shop", "api"},
+		edit:      FluxEdit{Path: "./shop/v2", RequestedAt: "2024-05-01T12:00:00Z"},
+		wantFiles: []string{"clusters/prod/shop.yml"},
+		want: map[string]string{
+			"clusters/prod/api.yaml": shopRelease,
+			"clusters/prod/shop.yml": `apiVersion: kustomize.toolkit.fluxcd.io/v1
+kind: Kustomization
+metadata:
+  name: shop
+  namespace: flux-system
+  annotations:
+    reconcile.fluxcd.io/requestedAt: "2024-05-01T12:00:00Z"
+spec:
+  path: ./shop/v2
+  prune: true
+`,
+		},
+	},
+	"reconciliation only": {
+		resources: []string{"shop/api"},
+		edit:      FluxEdit{RequestedAt: "2024-05-01T12:00:00Z"},
+		wantFiles: []string{"clusters/prod/api.yaml"},
+		want: map[string]string{
+			"clusters/prod/api.yaml": `# Shop API
+apiVersion: source.toolkit.fluxcd.io/v1beta2
+kind: HelmRepository
+metadata:
+  name: shop
+spec:
+  url: https://charts.acme.dev
+---
+apiVersion: helm.toolkit.fluxcd.io/v2beta1
+kind: HelmRelease
+metadata:
+  name: api
+  namespace: shop
+  annotations:
+    reconcile.fluxcd.io/requestedAt: "2024-05-01T12:00:00Z"
+spec:
+  interval: 5m
+  chart:
+    spec:
+      chart: api
+      version: "1.2.0" # pinned
+  values:
+    replicas: 2
+    image:
+      tag: v1.0.0
+`,
+			"clusters/prod/shop.yml": shopKustomization,
+		},
+	},
+	"unchanged resource isn't annotated": {
+		resources: []string{"flux-system/shop"},
+		edit:      FluxEdit{Path: "./shop/v1", RequestedAt: "2024-05-01T12:00:00Z"},
+		want:      map[string]string{"clusters/prod/shop.yml": shopKustomization},
+	},
+	"missing resource (error)": {
+		resources: []string{"shop/web"},
+		edit:      FluxEdit{ChartVersion: "1.3.0"},
+		wantErr:   true,
+	},
+	"chart reference (error)": {
+		resources: []string{"api-ref"},
+		edit:      FluxEdit{ChartVersion: "1.3.0"},
+		wantErr:   true,
+	},
+}
+
+func TestFluxUpdater(t *testing.T) {
+	for name, tc := range fluxUpdaterCases {
+		t.Run(name, func(t *testing.T) {
+			root := templatesDir(t, map[string]string{
+				"clusters/prod/api.yaml":           shopRelease,
+				"clusters/prod/shop.yml":           shopKustomization,
+				"clusters/prod/kustomization.yaml": "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nmetadata:\n  name: api\n",
+				"clusters/prod/ref.yaml": `apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: api-ref
+spec:
+  chartRef:
+    kind: OCIRepository
+    name: api
+`,
+			})
+			defer os.RemoveAll(root)
+
+			files, err := FluxUpdater{
+				Root:      root,
+				Folders:   []string{"clusters/prod/"},
+				Resources: tc.resources,
+				Edit:      tc.edit,
+			}.renderAllFiles()
+			if tc.wantErr {
+				require.Error(t, err, "renderAllFiles")
+				return
+			}
+			require.NoError(t, err, "renderAllFiles")
+			assert.Equal(t, tc.wantFiles, files)
+			for file, want := range tc.want {
+				got, err := ioutil.ReadFile(path.Join(root, file))
+				require.NoError(t, err, "read %s", file)
+				assert.Equal(t, want, string(got), file)
+			}
+		})
+	}
+}
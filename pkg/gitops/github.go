@@ -14,7 +14,7 @@ import (
 type githuber interface {
 	AddKey(context.Context, []byte) (int64, error)
 	DeleteKey(context.Context, int64) error
-	OpenPullRequest(context.Context, openPullRequestParams) (string, error)
+	OpenPullRequest(context.Context, openPullRequestParams) (pullRequest, error)
 }
 
 // github implements the githuber interface.
@@ -37,7 +37,7 @@ func NewGithub(ctx context.Context, repoURL string, pat stepconf.Secret) (*githu
 	// Determine owner and repository name from url (all API requests need it).
 	owner, repoName, err := githubOwnerRepo(repoURL)
 	if err != nil {
-		return nil, fmt.Errorf("owner and repo from url (%q): %w", repoURL, err)
+		return nil, classify(errorClassGithub, fmt.Errorf("owner and repo from url (%q): %w", repoURL, err))
 	}
 	return &github{
 		client:   ghClient,
@@ -72,7 +72,13 @@ type openPullRequestParams struct {
 	base  string
 }
 
-func (gh github) OpenPullRequest(ctx context.Context, p openPullRequestParams) (string, error) {
+// pullRequest is an opened pull request.
+type pullRequest struct {
+	url    string
+	number int
+}
+
+func (gh github) OpenPullRequest(ctx context.Context, p openPullRequestParams) (pullRequest, error) {
 	// Title is required for PRs. Generate  one if it's omitted.
 	if p.title == "" {
 		p.title = "Merge " + p.head
@@ -85,9 +91,9 @@ func (gh github) OpenPullRequest(ctx context.Context, p openPullRequestParams) (
 	}
 	pr, _, err := gh.client.PullRequests.Create(ctx, gh.owner, gh.repoName, req)
 	if err != nil {
-		return "", fmt.Errorf("create: %w", err)
+		return pullRequest{}, fmt.Errorf("create: %w", err)
 	}
-	return *pr.HTMLURL, nil
+	return pullRequest{url: pr.GetHTMLURL(), number: pr.GetNumber()}, nil
 }
 
 func githubOwnerRepo(s string) (string, string, error) {
@@ -24,7 +24,7 @@ var _ githuber = &githuberMock{}
 //             DeleteKeyFunc: func(in1 context.Context, in2 int64) error {
 // 	               panic("mock out the DeleteKey method")
 //             },
-//             OpenPullRequestFunc: func(in1 context.Context, in2 openPullRequestParams) (string, error) {
+//             OpenPullRequestFunc: func(in1 context.Context, in2 openPullRequestParams) (pullRequest, error) {
 // 	               panic("mock out the OpenPullRequest method")
 //             },
 //         }
@@ -41,7 +41,7 @@ type githuberMock struct {
 	DeleteKeyFunc func(in1 context.Context, in2 int64) error
 
 	// OpenPullRequestFunc mocks the OpenPullRequest method.
-	OpenPullRequestFunc func(in1 context.Context, in2 openPullRequestParams) (string, error)
+	OpenPullRequestFunc func(in1 context.Context, in2 openPullRequestParams) (pullRequest, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -143,7 +143,7 @@ func (mock *githuberMock) DeleteKeyCalls() []struct {
 }
 
 // OpenPullRequest calls OpenPullRequestFunc.
-func (mock *githuberMock) OpenPullRequest(in1 context.Context, in2 openPullRequestParams) (string, error) {
+func (mock *githuberMock) OpenPullRequest(in1 context.Context, in2 openPullRequestParams) (pullRequest, error) {
 	if mock.OpenPullRequestFunc == nil {
 		panic("githuberMock.OpenPullRequestFunc: method is nil but githuber.OpenPullRequest was just called")
 	}
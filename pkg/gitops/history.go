@@ -0,0 +1,183 @@
+package gitops
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// historyFolder is the folder of step metadata inside each deploy folder.
+// It isn't part of the rendered templates (e.g. verify ignores it).
+const historyFolder = ".gitops"
+
+// historyFile is the history ledger inside the history folder.
+const historyFile = "history.jsonl"
+
+//go:generate moq -out history_moq_test.go . appendHistoryer
+type appendHistoryer interface {
+	// appendHistory appends an entry to the ledger of each changed deploy
+	// folder (relative to a root folder) given the rendered files.
+	appendHistory(root string, files []renderedFile, changes []fileChange) error
+}
+
+// BuildInfo is the source and build which produced a deploy repository state.
+type BuildInfo struct {
+	// SourceRepository is the URL of the templates (source) repository.
+	SourceRepository string `json:"source_repository,omitempty"`
+	// SourceCommit is the commit of the templates (source) repository.
+	SourceCommit string `json:"source_commit,omitempty"`
+	// BuildURL is the URL of the CI build.
+	BuildURL string `json:"build_url,omitempty"`
+	// BuildNumber is the number of the CI build.
+	BuildNumber string `json:"build_number,omitempty"`
+	// Actor is who triggered the change (e.g. author of the source commit).
+	Actor string `json:"actor,omitempty"`
+}
+
+// historyEntry is an entry of the history ledger.
+type historyEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	BuildInfo
+	// VarsHash is the hash of the fully merged variables
+	// (not set for rollbacks, which aren't rendered).
+	VarsHash string `json:"vars_hash,omitempty"`
+	// Rollback tells what a rollback restored (e.g. "build 12").
+	Rollback string `json:"rollback,omitempty"`
+	// PromotedFrom is the deploy folder a promotion copied from.
+	PromotedFrom string `json:"promoted_from,omitempty"`
+	// Files are the SHA-256 hashes of the rendered files
+	// by their slash separated path relative to the deploy folder.
+	Files map[string]string `json:"files"`
+}
+
+// HistoryLedger appends deployments to a ledger file in each deploy folder
+// (`.gitops/history.jsonl`), which is committed together with the changes.
+type HistoryLedger struct {
+	// Templates is the renderer of the templates (of all environments).
+	Templates TemplatesRenderer
+	// Environments the templates are rendered to (if any).
+	Environments []Environment
+	// Build is the source and build of the change.
+	Build BuildInfo
+	// MaxEntries caps the length of the ledger (oldest entries are dropped).
+	// Zero means unlimited.
+	MaxEntries int
+	// Rollback tells what a rollback restored (empty if it isn't a rollback).
+	Rollback string
+	// PromotedFrom is the deploy folder a promotion copied from
+	// (empty if it isn't a promotion).
+	PromotedFrom string
+}
+
+// HistoryLedger implements the appendHistoryer interface.
+var _ appendHistoryer = (*HistoryLedger)(nil)
+
+func (hl HistoryLedger) appendHistory(root string, files []renderedFile, changes []fileChange) error {
+	renderers := []TemplatesRenderer{hl.Templates}
+	if len(hl.Environments) > 0 {
+		er := EnvironmentsRenderer{Templates: hl.Templates, Environments: hl.Environments}
+		renderers = nil
+		for _, env := range hl.Environments {
+			renderers = append(renderers, er.renderer(env))
+		}
+	}
+
+	timestamp := time.Now().UTC()
+	for _, tr := range renderers {
+		// Nothing was deployed to unchanged deploy folders.
+		if !folderChanged(changes, path.Clean(filepath.ToSlash(tr.DestinationFolder))) {
+			continue
+		}
+		entry := historyEntry{
+			Timestamp:    timestamp,
+			BuildInfo:    hl.Build,
+			Rollback:     hl.Rollback,
+			PromotedFrom: hl.PromotedFrom,
+			Files:        folderHashes(files, tr.DestinationFolder),
+		}
+		// Variables are only hashed if templates were rendered.
+		if hl.Rollback == "" && hl.PromotedFrom == "" {
+			vars, _, err := tr.mergedValues()
+			if err != nil {
+				return fmt.Errorf("values of %q: %w", tr.DestinationFolder, err)
+			}
+			if entry.VarsHash, err = hashValues(vars); err != nil {
+				return fmt.Errorf("hash values of %q: %w", tr.DestinationFolder, err)
+			}
+		}
+		ledgerPath := filepath.Join(root, tr.DestinationFolder, historyFolder, historyFile)
+		if err := appendLedger(ledgerPath, entry, hl.MaxEntries); err != nil {
+			return fmt.Errorf("append ledger %q: %w", ledgerPath, err)
+		}
+	}
+	return nil
+}
+
+// hashValues returns the SHA-256 hash of variables
+// (serialized to JSON, which has sorted keys).
+func hashValues(vars map[string]interface{}) (string, error) {
+	b, err := json.Marshal(vars)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// folderHashes returns hashes of rendered files inside a deploy folder
+// by their path relative to the folder.
+func folderHashes(files []renderedFile, folder string) map[string]string {
+	hashes := map[string]string{}
+	prefix := path.Clean(filepath.ToSlash(folder)) + "/"
+	for _, f := range files {
+		if !inFolder(f.Path, folder) {
+			continue
+		}
+		hashes[strings.TrimPrefix(f.Path, prefix)] = f.SHA256
+	}
+	return hashes
+}
+
+// appendLedger appends an entry to a JSON lines ledger file keeping
+// the last maxEntries entries only (if it's not zero).
+func appendLedger(ledgerPath string, entry interface{}, maxEntries int) error {
+	var lines [][]byte
+	b, err := ioutil.ReadFile(ledgerPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("read: %w", err)
+	default:
+		for _, line := range bytes.Split(b, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	lines = append(lines, line)
+	if maxEntries > 0 && len(lines) > maxEntries {
+		lines = lines[len(lines)-maxEntries:]
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ledgerPath), 0755); err != nil {
+		return fmt.Errorf("create folder: %w", err)
+	}
+	content := append(bytes.Join(lines, []byte("\n")), '\n')
+	if err := ioutil.WriteFile(ledgerPath, content, 0644); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
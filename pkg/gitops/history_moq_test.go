@@ -0,0 +1,85 @@
+// Code generated by moq; DO NOT EDIT.
+// github.com/matryer/moq
+
+package gitops
+
+import (
+	"sync"
+)
+
+// Ensure, that appendHistoryerMock does implement appendHistoryer.
+// If this is not the case, regenerate this file with moq.
+var _ appendHistoryer = &appendHistoryerMock{}
+
+// appendHistoryerMock is a mock implementation of appendHistoryer.
+//
+//     func TestSomethingThatUsesappendHistoryer(t *testing.T) {
+//
+//         // make and configure a mocked appendHistoryer
+//         mockedappendHistoryer := &appendHistoryerMock{
+//             appendHistoryFunc: func(root string, files []renderedFile, changes []fileChange) error {
+// 	               panic("mock out the appendHistory method")
+//             },
+//         }
+//
+//         // use mockedappendHistoryer in code that requires appendHistoryer
+//         // and then make assertions.
+//
+//     }
+type appendHistoryerMock struct {
+	// appendHistoryFunc mocks the appendHistory method.
+	appendHistoryFunc func(root string, files []renderedFile, changes []fileChange) error
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// appendHistory holds details about calls to the appendHistory method.
+		appendHistory []struct {
+			// Root is the root argument value.
+			Root string
+			// Files is the files argument value.
+			Files []renderedFile
+			// Changes is the changes argument value.
+			Changes []fileChange
+		}
+	}
+	lockappendHistory sync.RWMutex
+}
+
+// appendHistory calls appendHistoryFunc.
+func (mock *appendHistoryerMock) appendHistory(root string, files []renderedFile, changes []fileChange) error {
+	if mock.appendHistoryFunc == nil {
+		panic("appendHistoryerMock.appendHistoryFunc: method is nil but appendHistoryer.appendHistory was just called")
+	}
+	callInfo := struct {
+		Root    string
+		Files   []renderedFile
+		Changes []fileChange
+	}{
+		Root:    root,
+		Files:   files,
+		Changes: changes,
+	}
+	mock.lockappendHistory.Lock()
+	mock.calls.appendHistory = append(mock.calls.appendHistory, callInfo)
+	mock.lockappendHistory.Unlock()
+	return mock.appendHistoryFunc(root, files, changes)
+}
+
+// appendHistoryCalls gets all the calls that were made to appendHistory.
+// Check the length with:
+//     len(mockedappendHistoryer.appendHistoryCalls())
+func (mock *appendHistoryerMock) appendHistoryCalls() []struct {
+	Root    string
+	Files   []renderedFile
+	Changes []fileChange
+} {
+	var calls []struct {
+		Root    string
+		Files   []renderedFile
+		Changes []fileChange
+	}
+	mock.lockappendHistory.RLock()
+	calls = mock.calls.appendHistory
+	mock.lockappendHistory.RUnlock()
+	return calls
+}
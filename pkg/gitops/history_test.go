@@ -0,0 +1,103 @@
+package gitops
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryLedger(t *testing.T) {
+	repoDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp repo dir")
+	defer os.RemoveAll(repoDir)
+
+	hl := HistoryLedger{
+		Templates: TemplatesRenderer{
+			Vars: map[string]interface{}{"tag": "a"},
+		},
+		Environments: []Environment{
+			{Name: "staging", DeployPath: "staging"},
+			{Name: "prod", DeployPath: "prod", Vars: map[string]interface{}{"tag": "b"}},
+		},
+		Build: BuildInfo{
+			SourceRepository: "https://github.com/foo/templates",
+			SourceCommit:     "abc123",
+			BuildURL:         "https://app.bitrise.io/build/1",
+			BuildNumber:      "1",
+			Actor:            "John Doe",
+		},
+		MaxEntries: 2,
+	}
+	files := []renderedFile{
+		{Path: "staging/values.yaml", SHA256: "staginghash"},
+		{Path: "prod/values.yaml", SHA256: "prodhash"},
+		{Path: "prod/nested/app.yaml", SHA256: "apphash"},
+	}
+
+	changes := []fileChange{
+		{path: "staging/values.yaml", status: fileModified},
+		{path: "prod/nested/app.yaml", status: fileAdded},
+	}
+
+	// Ledger is capped at the max number of entries.
+	for i := 0; i < 3; i++ {
+		hl.Build.BuildNumber = string(rune('1' + i))
+		require.NoError(t, hl.appendHistory(repoDir, files, changes), "appendHistory #%d", i)
+	}
+
+	// Unchanged deploy folders (or ones with changed metadata only)
+	// aren't appended to.
+	hl.Build.BuildNumber = "4"
+	require.NoError(t, hl.appendHistory(repoDir, files, []fileChange{
+		{path: "prod/values.yaml", status: fileModified},
+		{path: "staging/.gitops/render.lock.yaml", status: fileModified},
+	}), "appendHistory of prod")
+
+	stagingEntries := readLedger(t, path.Join(repoDir, "staging", ".gitops", "history.jsonl"))
+	prodEntries := readLedger(t, path.Join(repoDir, "prod", ".gitops", "history.jsonl"))
+	require.Len(t, stagingEntries, 2, "staging entries")
+	require.Len(t, prodEntries, 2, "prod entries")
+
+	// Oldest entry is dropped.
+	assert.Equal(t, "2", stagingEntries[0].BuildNumber, "first staging entry")
+	assert.Equal(t, "3", stagingEntries[1].BuildNumber, "last staging entry")
+	assert.Equal(t, "4", prodEntries[1].BuildNumber, "last prod entry")
+
+	got := prodEntries[1]
+	assert.False(t, got.Timestamp.IsZero(), "timestamp")
+	assert.Equal(t, "abc123", got.SourceCommit, "source commit")
+	assert.Equal(t, "https://app.bitrise.io/build/1", got.BuildURL, "build url")
+	assert.Equal(t, "John Doe", got.Actor, "actor")
+	assert.Equal(t, map[string]string{
+		"values.yaml":     "prodhash",
+		"nested/app.yaml": "apphash",
+	}, got.Files, "prod files")
+	assert.Equal(t, map[string]string{"values.yaml": "staginghash"},
+		stagingEntries[1].Files, "staging files")
+
+	// Variables of the environments differ.
+	assert.NotEmpty(t, got.VarsHash, "vars hash")
+	assert.NotEqual(t, stagingEntries[1].VarsHash, got.VarsHash, "vars hash per environment")
+}
+
+func readLedger(t *testing.T, ledgerPath string) []historyEntry {
+	f, err := os.Open(ledgerPath)
+	require.NoError(t, err, "open ledger")
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry historyEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), "unmarshal entry")
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err(), "scan ledger")
+	return entries
+}
@@ -0,0 +1,393 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kustomizationFiles are the recognized names of kustomization files.
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// KustomizeImage overrides an image of a kustomization (an `images:` entry).
+type KustomizeImage struct {
+	// Name of the overridden image.
+	Name string
+	// NewName replaces the name of the image (if it isn't empty).
+	NewName string
+	// NewTag replaces the tag of the image (if it isn't empty).
+	NewTag string
+	// Digest replaces the tag of the image by a digest (if it isn't empty).
+	Digest string
+}
+
+// ParseKustomizeImages parses a list of images in the format of
+// `kustomize edit set image`: `<name>=<new name>:<new tag>@<digest>`
+// (e.g. `api=registry.io/api:v1.2.3`), where each part is optional.
+// Without a `<name>=` prefix, the name of the image is its own name
+// (e.g. `api:v1.2.3` only sets the tag of `api`).
+func ParseKustomizeImages(a []string) ([]KustomizeImage, error) {
+	var images []KustomizeImage
+	for _, s := range a {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		var img KustomizeImage
+		ref := s
+		renamed := strings.Contains(s, "=")
+		if renamed {
+			i := strings.Index(s, "=")
+			img.Name, ref = s[:i], s[i+1:]
+		}
+		if i := strings.Index(ref, "@"); i >= 0 {
+			ref, img.Digest = ref[:i], ref[i+1:]
+		}
+		// A colon before the last slash is the port of the registry.
+		if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+			ref, img.NewTag = ref[:i], ref[i+1:]
+		}
+		if renamed {
+			img.NewName = ref
+		} else {
+			img.Name = ref
+		}
+		if img.Name == "" || (img.NewName == "" && img.NewTag == "" && img.Digest == "") {
+			return nil, fmt.Errorf("image %q: must be [<name>=]<new name>[:<new tag>][@<digest>]", s)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// KustomizeEdit is an edit of kustomization files.
+type KustomizeEdit struct {
+	// Images are overridden images.
+	Images []KustomizeImage
+	// AddResources are resources added (if they aren't listed yet).
+	AddResources []string
+	// RemoveResources are resources removed (if they are listed).
+	RemoveResources []string
+	// CommonLabels are set labels of all resources.
+	CommonLabels map[string]string
+	// CommonAnnotations are set annotations of all resources.
+	CommonAnnotations map[string]string
+}
+
+// Empty tells whether the edit doesn't change anything.
+func (ke KustomizeEdit) Empty() bool {
+	return len(ke.Images) == 0 && len(ke.AddResources) == 0 && len(ke.RemoveResources) == 0 &&
+		len(ke.CommonLabels) == 0 && len(ke.CommonAnnotations) == 0
+}
+
+// KustomizeUpdater edits kustomization files in place (e.g. to override
+// the tag of an image), so the diff of the change is minimal.
+type KustomizeUpdater struct {
+	// Root is the root folder of kustomizations (e.g. the local clone).
+	Root string
+	// Folders of the edited kustomizations (relative to the root).
+	Folders []string
+	// Edit of the kustomizations.
+	Edit KustomizeEdit
+}
+
+// KustomizeUpdater implements the renderAllFileser interface.
+var _ renderAllFileser = (*KustomizeUpdater)(nil)
+
+// renderAllFiles edits the kustomization file of all folders and returns
+// their slash separated paths.
+func (ku KustomizeUpdater) renderAllFiles() ([]string, error) {
+	var files []string
+	for _, folder := range ku.Folders {
+		name, err := kustomizationFile(filepath.Join(ku.Root, folder))
+		if err != nil {
+			return nil, fmt.Errorf("folder %q: %w", folder, err)
+		}
+		filePath := filepath.Join(ku.Root, folder, name)
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("read kustomization: %w", err)
+		}
+		edited, err := editKustomization(content, ku.Edit)
+		if err != nil {
+			return nil, fmt.Errorf("edit kustomization %q: %w", filePath, err)
+		}
+		if err := ioutil.WriteFile(filePath, edited, 0644); err != nil {
+			return nil, fmt.Errorf("write kustomization: %w", err)
+		}
+		files = append(files, path.Join(filepath.ToSlash(filepath.Clean(folder)), name))
+	}
+	return files, nil
+}
+
+// kustomizationFile returns the name of the kustomization file of a folder.
+func kustomizationFile(folder string) (string, error) {
+	for _, name := range kustomizationFiles {
+		_, err := os.Stat(filepath.Join(folder, name))
+		if err == nil {
+			return name, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("kustomization file not found (%s)", strings.Join(kustomizationFiles, ", "))
+}
+
+// editKustomization applies an edit to the content of a kustomization file.
+func editKustomization(content []byte, edit KustomizeEdit) ([]byte, error) {
+	var err error
+	for _, img := range edit.Images {
+		if content, err = setKustomizeImage(content, img); err != nil {
+			return nil, fmt.Errorf("image %q: %w", img.Name, err)
+		}
+	}
+	for _, r := range edit.AddResources {
+		if content, err = addKustomizeResource(content, r); err != nil {
+			return nil, fmt.Errorf("add resource %q: %w", r, err)
+		}
+	}
+	for _, r := range edit.RemoveResources {
+		if content, err = removeKustomizeResource(content, r); err != nil {
+			return nil, fmt.Errorf("remove resource %q: %w", r, err)
+		}
+	}
+	for _, field := range []struct {
+		key    string
+		values map[string]string
+	}{
+		{"commonLabels", edit.CommonLabels},
+		{"commonAnnotations", edit.CommonAnnotations},
+	} {
+		keys := make([]string, 0, len(field.values))
+		for key := range field.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if content, err = setYAMLEntry(content, []string{field.key}, key, field.values[key]); err != nil {
+				return nil, fmt.Errorf("set %s %q: %w", field.key, key, err)
+			}
+		}
+	}
+	return content, nil
+}
+
+// setKustomizeImage sets the fields of an `images:` entry (and adds it if
+// it's missing). As `kustomize edit set image`, a new tag removes the digest
+// and a new digest removes the tag.
+func setKustomizeImage(content []byte, img KustomizeImage) ([]byte, error) {
+	type imageField struct{ key, value, removes string }
+	fields := []imageField{
+		{"newName", img.NewName, ""},
+		{"newTag", img.NewTag, "digest"},
+		{"digest", img.Digest, "newTag"},
+	}
+	if img.NewTag != "" && img.Digest != "" {
+		fields[1].removes, fields[2].removes = "", ""
+	}
+
+	index, err := sequenceIndex(content, []string{"images"}, []string{"name"}, img.Name)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		var item []string
+		for _, f := range append([]imageField{{"name", img.Name, ""}}, fields...) {
+			if f.value == "" {
+				continue
+			}
+			text, err := plainText(f.value)
+			if err != nil {
+				return nil, err
+			}
+			item = append(item, f.key+": "+text)
+		}
+		return appendYAMLItem(content, []string{"images"}, item)
+	}
+
+	keyPath := []string{"images", fmt.Sprint(index)}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		if content, err = setYAMLEntry(content, keyPath, f.key, f.value); err != nil {
+			return nil, err
+		}
+		if f.removes != "" {
+			if content, err = removeYAMLEntry(content, append(keyPath, f.removes)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return content, nil
+}
+
+// addKustomizeResource adds a resource (if it isn't listed yet).
+func addKustomizeResource(content []byte, resource string) ([]byte, error) {
+	index, err := sequenceIndex(content, []string{"resources"}, nil, resource)
+	if err != nil || index >= 0 {
+		return content, err
+	}
+	text, err := plainText(resource)
+	if err != nil {
+		return nil, err
+	}
+	return appendYAMLItem(content, []string{"resources"}, []string{text})
+}
+
+// removeKustomizeResource removes a resource (if it's listed).
+func removeKustomizeResource(content []byte, resource string) ([]byte, error) {
+	index, err := sequenceIndex(content, []string{"resources"}, nil, resource)
+	if err != nil || index < 0 {
+		return content, err
+	}
+	return removeYAMLItem(content, []string{"resources"}, index)
+}
+
+// sequenceIndex returns the index of the first item of a sequence whose
+// value at a key path is a given value (-1 if there isn't any).
+func sequenceIndex(content []byte, keyPath, itemKeyPath []string, value string) (int, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return 0, err
+	}
+	seq := findYAMLNode(root, keyPath)
+	if seq == nil || isNull(seq) {
+		return -1, nil
+	}
+	if seq.Kind != yaml.SequenceNode {
+		return 0, fmt.Errorf("%s isn't a sequence", strings.Join(keyPath, "."))
+	}
+	for i, item := range seq.Content {
+		node := findYAMLNode(item, itemKeyPath)
+		if node != nil && node.Kind == yaml.ScalarNode && node.Value == value {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// parseKeyValues parses a list of `<key>=<value>` strings.
+func parseKeyValues(a []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, s := range a {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q: must be <key>=<value>", s)
+		}
+		values[strings.TrimSpace(s[:i])] = s[i+1:]
+	}
+	return values, nil
+}
+
+// writeKustomizationResources regenerates the resources of the kustomization
+// of a folder from the rendered Kubernetes manifests in it (slash separated
+// paths relative to the root). Resources which aren't YAML files of the
+// folder (e.g. other kustomizations or remote resources) are kept.
+// The resources are sorted. It returns the path of the kustomization.
+func writeKustomizationResources(root, folder string, rendered []string) (string, error) {
+	name, err := kustomizationFile(filepath.Join(root, folder))
+	if err != nil {
+		return "", err
+	}
+	file := path.Join(filepath.ToSlash(filepath.Clean(folder)), name)
+	filePath := filepath.Join(root, filepath.FromSlash(file))
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("read kustomization: %w", err)
+	}
+
+	resources := map[string]bool{}
+	kustomizationRoot, err := yamlRoot(content)
+	if err != nil {
+		return "", fmt.Errorf("parse kustomization: %w", err)
+	}
+	if seq := findYAMLNode(kustomizationRoot, []string{"resources"}); seq != nil && seq.Kind == yaml.SequenceNode {
+		for _, item := range seq.Content {
+			if !isFolderManifest(item.Value) {
+				resources[item.Value] = true
+			}
+		}
+	}
+	for _, r := range rendered {
+		rel, err := filepath.Rel(filepath.Clean(folder), filepath.FromSlash(r))
+		if err != nil || !isFolderManifest(filepath.ToSlash(rel)) || isMetadata(r) {
+			continue
+		}
+		manifest, err := isManifest(filepath.Join(root, filepath.FromSlash(r)))
+		if err != nil {
+			return "", fmt.Errorf("read rendered file %q: %w", r, err)
+		}
+		if manifest {
+			resources[filepath.ToSlash(rel)] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(resources))
+	for r := range resources {
+		sorted = append(sorted, r)
+	}
+	sort.Strings(sorted)
+	edited, err := setYAMLSequence(content, []string{"resources"}, sorted)
+	if err != nil {
+		return "", fmt.Errorf("set resources of %s: %w", file, err)
+	}
+	if err := ioutil.WriteFile(filePath, edited, 0644); err != nil {
+		return "", fmt.Errorf("write kustomization: %w", err)
+	}
+	return file, nil
+}
+
+// isFolderManifest tells whether a slash separated resource path is a YAML
+// file inside the kustomization's folder (but not a kustomization itself).
+func isFolderManifest(resource string) bool {
+	ext := path.Ext(resource)
+	if ext != ".yaml" && ext != ".yml" {
+		return false
+	}
+	if resource == ".." || strings.HasPrefix(resource, "../") || path.IsAbs(resource) ||
+		strings.Contains(resource, "://") {
+		return false
+	}
+	for _, name := range kustomizationFiles {
+		if path.Base(resource) == name {
+			return false
+		}
+	}
+	return true
+}
+
+// isManifest tells whether a YAML file is a Kubernetes manifest
+// (its first document has both apiVersion and kind).
+func isManifest(filePath string) (bool, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	docs, err := parseYAMLDocuments(content)
+	if err != nil || len(docs) == 0 {
+		return false, nil
+	}
+	return findYAMLNode(docs[0], []string{"apiVersion"}) != nil &&
+		findYAMLNode(docs[0], []string{"kind"}) != nil, nil
+}
+
+// containsString tells whether a list contains a string.
+func containsString(a []string, s string) bool {
+	for _, item := range a {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
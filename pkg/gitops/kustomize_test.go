@@ -0,0 +1,206 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKustomizeImages(t *testing.T) {
+	images, err := ParseKustomizeImages([]string{
+		"api:v1.2.3",
+		"",
+		"worker=registry.io:5000/worker:v2@sha256:abc",
+		"web=registry.io/web",
+		"cron@sha256:def",
+	})
+	require.NoError(t, err, "ParseKustomizeImages")
+	assert.Equal(t, []KustomizeImage{
+		{Name: "api", NewTag: "v1.2.3"},
+		{Name: "worker", NewName: "registry.io:5000/worker", NewTag: "v2", Digest: "sha256:abc"},
+		{Name: "web", NewName: "registry.io/web"},
+		{Name: "cron", Digest: "sha256:def"},
+	}, images)
+
+	for _, s := range []string{"api", "=api:v1", "registry.io:5000/api"} {
+		_, err = ParseKustomizeImages([]string{s})
+		require.Error(t, err, "invalid image %q", s)
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	values, err := parseKeyValues([]string{"team=api", "", "app.kubernetes.io/part-of=shop=v2"})
+	require.NoError(t, err, "parseKeyValues")
+	assert.Equal(t, map[string]string{"team": "api", "app.kubernetes.io/part-of": "shop=v2"}, values)
+
+	_, err = parseKeyValues([]string{"=api"})
+	require.Error(t, err, "without key")
+}
+
+const kustomization = `# API of the shop
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml # the app
+  - service.yaml
+images:
+  - name: api
+    newTag: v1 # bumped by CI
+  - name: worker
+    digest: sha256:abc
+commonLabels:
+  team: api
+`
+
+var editKustomizationCases = map[string]struct {
+	content string
+	edit    KustomizeEdit
+	want    string
+	wantErr bool
+}{
+	"tag of an existing image": {
+		content: kustomization,
+		edit:    KustomizeEdit{Images: []KustomizeImage{{Name: "api", NewTag: "1.20"}}},
+		want: `# API of the shop
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml # the app
+  - service.yaml
+images:
+  - name: api
+    newTag: "1.20" # bumped by CI
+  - name: worker
+    digest: sha256:abc
+commonLabels:
+  team: api
+`,
+	},
+	"tag replaces digest and name is added": {
+		content: kustomization,
+		edit: KustomizeEdit{Images: []KustomizeImage{
+			{Name: "worker", NewName: "registry.io/worker", NewTag: "v2"},
+		}},
+		want: `# API of the shop
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml # the app
+  - service.yaml
+images:
+  - name: api
+    newTag: v1 # bumped by CI
+  - name: worker
+    newName: registry.io/worker
+    newTag: v2
+commonLabels:
+  team: api
+`,
+	},
+	"new image, resources, labels and annotations": {
+		content: kustomization,
+		edit: KustomizeEdit{
+			Images:            []KustomizeImage{{Name: "web", NewTag: "v3", Digest: "sha256:def"}},
+			AddResources:      []string{"ingress.yaml", "service.yaml"},
+			RemoveResources:   []string{"deployment.yaml", "missing.yaml"},
+			CommonLabels:      map[string]string{"team": "shop", "tier": "backend"},
+			CommonAnnotations: map[string]string{"owner": "shop@example.com"},
+		},
+		want: `# API of the shop
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - service.yaml
+  - ingress.yaml
+images:
+  - name: api
+    newTag: v1 # bumped by CI
+  - name: worker
+    digest: sha256:abc
+  - name: web
+    newTag: v3
+    digest: sha256:def
+commonLabels:
+  team: shop
+  tier: backend
+commonAnnotations:
+  owner: shop@example.com
+`,
+	},
+	"missing fields are added": {
+		content: "resources:\n- deployment.yaml\nimages:\ncommonLabels:",
+		edit: KustomizeEdit{
+			Images:          []KustomizeImage{{Name: "api", NewTag: "v2"}},
+			RemoveResources: []string{"deployment.yaml"},
+			CommonLabels:    map[string]string{"team": "api"},
+		},
+		want: "images:\n- name: api\n  newTag: v2\ncommonLabels:\n  team: api\n",
+	},
+	"flow style sequence (error)": {
+		content: "resources: [deployment.yaml]\n",
+		edit:    KustomizeEdit{AddResources: []string{"service.yaml"}},
+		wantErr: true,
+	},
+}
+
+func TestEditKustomization(t *testing.T) {
+	for name, tc := range editKustomizationCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := editKustomization([]byte(tc.content), tc.edit)
+			if tc.wantErr {
+				require.Error(t, err, "editKustomization")
+				return
+			}
+			require.NoError(t, err, "editKustomization")
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestKustomizeUpdater(t *testing.T) {
+	root := templatesDir(t, map[string]string{
+		"apps/prod/kustomization.yml":  "images:\n- name: api\n  newTag: v1\n",
+		"apps/staging/deployment.yaml": "kind: Deployment\n",
+	})
+	defer os.RemoveAll(root)
+	edit := KustomizeEdit{Images: []KustomizeImage{{Name: "api", NewTag: "v2"}}}
+
+	files, err := KustomizeUpdater{Root: root, Folders: []string{"apps/prod/"}, Edit: edit}.renderAllFiles()
+	require.NoError(t, err, "renderAllFiles")
+	assert.Equal(t, []string{"apps/prod/kustomization.yml"}, files)
+	got, err := ioutil.ReadFile(path.Join(root, "apps/prod/kustomization.yml"))
+	require.NoError(t, err, "read kustomization")
+	assert.Equal(t, "images:\n- name: api\n  newTag: v2\n", string(got))
+
+	_, err = KustomizeUpdater{Root: root, Folders: []string{"apps/staging"}, Edit: edit}.renderAllFiles()
+	require.Error(t, err, "folder without kustomization")
+}
+
+func TestWriteKustomizationResources(t *testing.T) {
+	root := templatesDir(t, map[string]string{
+		"prod/kustomization.yaml":    "resources:\n- https://example.com/base.yaml\n- hand-written.yaml\n",
+		"prod/hand-written.yaml":     "apiVersion: v1\nkind: ConfigMap\n",
+		"prod/apps/deployment.yml":   "apiVersion: apps/v1\nkind: Deployment\n---\nkind: Service\n",
+		"prod/Chart.yaml":            "apiVersion: v2\nname: shop\n",
+		"prod/.gitops/history.jsonl": "{}\n",
+		"staging/deployment.yaml":    "apiVersion: apps/v1\nkind: Deployment\n",
+	})
+	defer os.RemoveAll(root)
+
+	file, err := writeKustomizationResources(root, "prod", []string{
+		"prod/apps/deployment.yml", "prod/Chart.yaml", "staging/deployment.yaml",
+	})
+	require.NoError(t, err, "writeKustomizationResources")
+	assert.Equal(t, "prod/kustomization.yaml", file)
+	got, err := ioutil.ReadFile(path.Join(root, file))
+	require.NoError(t, err, "read kustomization")
+	assert.Equal(t, "resources:\n- apps/deployment.yml\n- https://example.com/base.yaml\n", string(got),
+		"only rendered manifests of the folder and remote resources are kept")
+
+	_, err = writeKustomizationResources(root, "staging", []string{"staging/deployment.yaml"})
+	require.Error(t, err, "folder without kustomization")
+}
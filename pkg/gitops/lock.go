@@ -0,0 +1,346 @@
+package gitops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lockFile is the render lock inside the history folder of a deploy folder.
+const lockFile = "render.lock.yaml"
+
+// secretKeyPattern matches names of variables which are masked in lock files.
+var secretKeyPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|api_?key|private_?key|credential)`)
+
+// renderLock records everything a deploy folder was rendered from, so the
+// same output can be re-rendered (replayed) later.
+// It's YAML, so types of variables (e.g. ints) survive the round-trip.
+type renderLock struct {
+	// SourceCommit is the commit of the templates (source) repository.
+	SourceCommit string `yaml:"source_commit,omitempty"`
+	// DeployFolder the templates were rendered to.
+	DeployFolder string `yaml:"deploy_folder"`
+	// Templates are SHA-256 checksums of template files by their slash
+	// separated path relative to the templates folder.
+	Templates map[string]string `yaml:"templates"`
+	// Partials are SHA-256 checksums of partials by their slash separated
+	// path relative to the templates (or partials) folder.
+	Partials map[string]string `yaml:"partials,omitempty"`
+	// Vars are the fully merged variables (secrets masked).
+	Vars map[string]interface{} `yaml:"vars"`
+	// MaskedVars are key paths of masked variables.
+	MaskedVars []string `yaml:"masked_vars,omitempty"`
+	// Render settings of the templates.
+	SuffixedTemplatesOnly bool             `yaml:"suffixed_templates_only,omitempty"`
+	VerbatimPatterns      []string         `yaml:"verbatim_patterns,omitempty"`
+	Delimiters            []DelimitersRule `yaml:"delimiters,omitempty"`
+	HTMLEscape            bool             `yaml:"html_escape,omitempty"`
+}
+
+// writeLock writes the render lock of rendered templates
+// to the history folder of the destination folder.
+func (tr TemplatesRenderer) writeLock(files, partialPaths []string, vars map[string]interface{}) error {
+	lock := renderLock{
+		SourceCommit:          tr.SourceCommit,
+		DeployFolder:          filepath.ToSlash(tr.DestinationFolder),
+		Templates:             map[string]string{},
+		Partials:              map[string]string{},
+		SuffixedTemplatesOnly: tr.SuffixedTemplatesOnly,
+		VerbatimPatterns:      tr.VerbatimPatterns,
+		Delimiters:            tr.Delimiters,
+		HTMLEscape:            tr.HTMLEscape,
+	}
+	for _, file := range files {
+		sum, err := fileChecksum(filepath.Join(tr.SourceFolder, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		lock.Templates[file] = sum
+	}
+	for _, path := range partialPaths {
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		lock.Partials[tr.partialName(path)] = sum
+	}
+	lock.Vars, lock.MaskedVars = maskSecrets(vars, "")
+	sort.Strings(lock.MaskedVars)
+
+	b, err := yaml.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("marshal lock: %w", err)
+	}
+	lockPath := filepath.Join(tr.DestinationRoot, tr.DestinationFolder, historyFolder, lockFile)
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return fmt.Errorf("create lock folder: %w", err)
+	}
+	if err := ioutil.WriteFile(lockPath, b, 0644); err != nil {
+		return fmt.Errorf("write lock: %w", err)
+	}
+	return nil
+}
+
+// partialName returns the slash separated path of a partial relative to the
+// templates folder (or to the partials folder if it's outside of it).
+func (tr TemplatesRenderer) partialName(path string) string {
+	for _, folder := range []string{tr.SourceFolder, tr.PartialsFolder} {
+		if folder == "" {
+			continue
+		}
+		rel, err := filepath.Rel(folder, path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(filepath.Base(path))
+}
+
+// fileChecksum returns the hex encoded SHA-256 checksum of a file.
+func fileChecksum(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// maskSecrets returns a copy of variables with values of secret looking keys
+// masked, and the dot separated key paths of the masked variables.
+func maskSecrets(vars map[string]interface{}, prefix string) (map[string]interface{}, []string) {
+	masked := map[string]interface{}{}
+	var keyPaths []string
+	for k, v := range vars {
+		if secretKeyPattern.MatchString(k) {
+			masked[k] = redacted
+			keyPaths = append(keyPaths, prefix+joinKeyPath(k))
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			var nested []string
+			masked[k], nested = maskSecrets(m, prefix+joinKeyPath(k)+".")
+			keyPaths = append(keyPaths, nested...)
+			continue
+		}
+		masked[k] = v
+	}
+	return masked, keyPaths
+}
+
+// readLock reads a render lock file.
+func readLock(path string) (renderLock, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return renderLock{}, fmt.Errorf("read file: %w", err)
+	}
+	var lock renderLock
+	if err := yaml.Unmarshal(b, &lock); err != nil {
+		return renderLock{}, fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	if lock.Vars == nil {
+		lock.Vars = map[string]interface{}{}
+	}
+	return lock, nil
+}
+
+// ReplayParams are parameters for Replay function.
+type ReplayParams struct {
+	// LockPath is the render lock file to replay.
+	LockPath string
+	// TemplatesFolder is the templates folder (checked out at the locked commit).
+	TemplatesFolder string
+	// PartialsFolder is the optional folder of shared partials.
+	PartialsFolder string
+	// Vars give the masked variables of the lock (e.g. secrets). Other
+	// variables are replayed as locked.
+	Vars map[string]interface{}
+	// OutputFolder is the local folder to render templates to.
+	OutputFolder string
+	// ExportEnv is an environment variable exporter.
+	ExportEnv envExporter
+}
+
+// Replay re-renders templates exactly as recorded by a render lock file to
+// a local folder. Templates must match the locked checksums and masked
+// variables must be given again. Given variables which aren't masked don't
+// override the locked ones (a warning is logged).
+func Replay(p ReplayParams) error {
+	lock, err := readLock(p.LockPath)
+	if err != nil {
+		return fmt.Errorf("read lock %q: %w", p.LockPath, err)
+	}
+	vars, err := lockedVars(lock, p.Vars)
+	if err != nil {
+		return err
+	}
+	if ignored := overriddenVars(lock, p.Vars); len(ignored) > 0 {
+		log.Printf("warning: variables aren't masked in the lock, their locked values are replayed: %s\n",
+			strings.Join(ignored, ", "))
+	}
+
+	tr := TemplatesRenderer{
+		SourceFolder:          p.TemplatesFolder,
+		PartialsFolder:        p.PartialsFolder,
+		Vars:                  vars,
+		SuffixedTemplatesOnly: lock.SuffixedTemplatesOnly,
+		VerbatimPatterns:      lock.VerbatimPatterns,
+		Delimiters:            lock.Delimiters,
+		HTMLEscape:            lock.HTMLEscape,
+		DestinationRoot:       p.OutputFolder,
+		DestinationFolder:     filepath.FromSlash(lock.DeployFolder),
+	}
+	if err := tr.checkLockedTemplates(lock); err != nil {
+		return fmt.Errorf("templates differ from the lock (check out commit %q): %w",
+			lock.SourceCommit, err)
+	}
+	return Render(RenderParams{Renderer: tr, OutputFolder: p.OutputFolder, ExportEnv: p.ExportEnv})
+}
+
+// checkLockedTemplates checks that templates and partials match
+// the checksums of a lock.
+func (tr TemplatesRenderer) checkLockedTemplates(lock renderLock) error {
+	files, partialPaths, err := tr.sourceFiles()
+	if err != nil {
+		return fmt.Errorf("source files in %q: %w", tr.SourceFolder, err)
+	}
+	got := map[string]string{}
+	for _, file := range files {
+		if got[file], err = fileChecksum(filepath.Join(tr.SourceFolder, filepath.FromSlash(file))); err != nil {
+			return err
+		}
+	}
+	gotPartials := map[string]string{}
+	for _, path := range partialPaths {
+		if gotPartials[tr.partialName(path)], err = fileChecksum(path); err != nil {
+			return err
+		}
+	}
+
+	diffs := append(checksumDiffs("template", lock.Templates, got),
+		checksumDiffs("partial", lock.Partials, gotPartials)...)
+	if len(diffs) > 0 {
+		return fmt.Errorf("%s", strings.Join(diffs, ", "))
+	}
+	return nil
+}
+
+// checksumDiffs returns descriptions of files with different checksums.
+func checksumDiffs(kind string, want, got map[string]string) []string {
+	var diffs []string
+	for file, sum := range want {
+		switch gotSum, ok := got[file]; {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s %q is missing", kind, file))
+		case gotSum != sum:
+			diffs = append(diffs, fmt.Sprintf("%s %q changed", kind, file))
+		}
+	}
+	for file := range got {
+		if _, ok := want[file]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s %q is new", kind, file))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// lockedVars returns the variables recorded by a lock with the masked ones
+// taken from given variables (e.g. the inputs). All masked variables must
+// be given.
+func lockedVars(lock renderLock, vars map[string]interface{}) (map[string]interface{}, error) {
+	locked, _ := mergeValues([]valuesLayer{{name: "lock", values: lock.Vars}})
+	var missing []string
+	for _, keyPath := range lock.MaskedVars {
+		keys, err := parseKeyPath(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("masked variable: %w", err)
+		}
+		v, ok := lookupKeys(vars, keys)
+		if !ok || v == redacted {
+			missing = append(missing, keyPath)
+			continue
+		}
+		setKeys(locked, keys, v)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("masked variables must be given in vars: %s", strings.Join(missing, ", "))
+	}
+	return locked, nil
+}
+
+// overriddenVars returns the sorted key paths of given variables which
+// aren't masked in a lock and differ from the locked values.
+func overriddenVars(lock renderLock, vars map[string]interface{}) []string {
+	var overridden []string
+	for _, keyPath := range leafKeyPaths(vars, "") {
+		if isMaskedKeyPath(lock.MaskedVars, keyPath) {
+			continue
+		}
+		v, _ := lookupKeyPath(vars, keyPath)
+		if locked, ok := lookupKeyPath(lock.Vars, keyPath); !ok || !reflect.DeepEqual(locked, v) {
+			overridden = append(overridden, keyPath)
+		}
+	}
+	sort.Strings(overridden)
+	return overridden
+}
+
+// isMaskedKeyPath tells whether a key path is (or is below) a masked one.
+func isMaskedKeyPath(masked []string, keyPath string) bool {
+	for _, m := range masked {
+		if keyPath == m || strings.HasPrefix(keyPath, m+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// setKeys sets the value of a key path (creating missing maps on the way).
+func setKeys(vars map[string]interface{}, keys []string, value interface{}) {
+	m := vars
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+// lookupKeyPath returns the value of a dot separated key path
+// (see parseKeyPath).
+func lookupKeyPath(vars map[string]interface{}, keyPath string) (interface{}, bool) {
+	keys, err := parseKeyPath(keyPath)
+	if err != nil {
+		return nil, false
+	}
+	return lookupKeys(vars, keys)
+}
+
+// lookupKeys returns the value of a key path.
+func lookupKeys(vars map[string]interface{}, keys []string) (interface{}, bool) {
+	var v interface{} = vars
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,146 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var replayCases = map[string]struct {
+	changeTemplate bool
+	vars           map[string]interface{}
+	wantErr        bool
+}{
+	"replay is byte-identical": {
+		vars: map[string]interface{}{"db": map[string]interface{}{"password": "hunter2"}},
+	},
+	"locked variables aren't overridden": {
+		vars: map[string]interface{}{
+			"replicas": 3,
+			"image":    map[string]interface{}{"tag": "2.0"},
+			"db":       map[string]interface{}{"password": "hunter2"},
+		},
+	},
+	"masked secret isn't given (error)": {
+		wantErr: true,
+	},
+	"template changed since the lock (error)": {
+		changeTemplate: true,
+		vars:           map[string]interface{}{"db": map[string]interface{}{"password": "hunter2"}},
+		wantErr:        true,
+	},
+}
+
+func TestReplay(t *testing.T) {
+	for name, tc := range replayCases {
+		t.Run(name, func(t *testing.T) {
+			templatesDir := templatesDir(t, map[string]string{
+				"deployment.yaml": "replicas: {{ .replicas }}\nimage: {{ .image.tag }}\n",
+				"secret.yaml":     "password: {{ .db.password }}\n",
+				"_helpers.tpl":    `{{ define "name" }}app{{ end }}`,
+			})
+			defer os.RemoveAll(templatesDir)
+			renderedDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err, "new temp rendered dir")
+			defer os.RemoveAll(renderedDir)
+			replayedDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err, "new temp replayed dir")
+			defer os.RemoveAll(replayedDir)
+
+			// Render with a lock file.
+			tr := TemplatesRenderer{
+				SourceFolder: templatesDir,
+				Vars: map[string]interface{}{
+					"replicas": 1000000,
+					"image":    map[string]interface{}{"tag": "1.0"},
+					"db":       map[string]interface{}{"password": "hunter2"},
+				},
+				DestinationRoot:   renderedDir,
+				DestinationFolder: "prod",
+				LockFile:          true,
+				SourceCommit:      "abc123",
+			}
+			_, err = tr.renderAllFiles()
+			require.NoError(t, err, "renderAllFiles")
+
+			// Secrets are masked in the lock file.
+			lockPath := path.Join(renderedDir, "prod", ".gitops", "render.lock.yaml")
+			lock, err := readLock(lockPath)
+			require.NoError(t, err, "read lock")
+			assert.Equal(t, "abc123", lock.SourceCommit, "source commit")
+			assert.Equal(t, "prod", lock.DeployFolder, "deploy folder")
+			assert.Len(t, lock.Templates, 2, "template checksums")
+			assert.Len(t, lock.Partials, 1, "partial checksums")
+			assert.Equal(t, []string{"db.password"}, lock.MaskedVars, "masked vars")
+			assert.Equal(t, map[string]interface{}{"password": "REDACTED"}, lock.Vars["db"], "masked secret")
+
+			if tc.changeTemplate {
+				write(t, path.Join(templatesDir, "deployment.yaml"), "replicas: 1\n")
+			}
+
+			err = Replay(ReplayParams{
+				LockPath:        lockPath,
+				TemplatesFolder: templatesDir,
+				Vars:            tc.vars,
+				OutputFolder:    replayedDir,
+				ExportEnv: func(string, string) error {
+					return nil
+				},
+			})
+			if tc.wantErr {
+				require.Error(t, err, "Replay")
+				return
+			}
+			require.NoError(t, err, "Replay")
+
+			for _, file := range []string{"deployment.yaml", "secret.yaml"} {
+				want, err := ioutil.ReadFile(path.Join(renderedDir, "prod", file))
+				require.NoError(t, err, "read rendered %s", file)
+				got, err := ioutil.ReadFile(path.Join(replayedDir, "prod", file))
+				require.NoError(t, err, "read replayed %s", file)
+				assert.Equal(t, string(want), string(got), "replayed %s", file)
+			}
+		})
+	}
+}
+
+func TestOverriddenVars(t *testing.T) {
+	lock := renderLock{
+		Vars: map[string]interface{}{
+			"replicas": 2,
+			"image":    map[string]interface{}{"tag": "1.0", "repository": "api"},
+			"db":       map[string]interface{}{"password": redacted},
+		},
+		MaskedVars: []string{"db.password"},
+	}
+	got := overriddenVars(lock, map[string]interface{}{
+		"replicas": 2,
+		"image":    map[string]interface{}{"tag": "2.0", "repository": "api"},
+		"db":       map[string]interface{}{"password": "hunter2"},
+		"extra":    true,
+	})
+	assert.Equal(t, []string{"extra", "image.tag"}, got)
+}
+
+func TestLockedVars(t *testing.T) {
+	vars := map[string]interface{}{
+		"tls":  map[string]interface{}{"tls.secret": "s3cr3t", "tls.crt": "cert"},
+		"name": "api",
+	}
+	masked, keyPaths := maskSecrets(vars, "")
+	require.Equal(t, []string{`tls.tls\.secret`}, keyPaths, "masked key paths")
+
+	lock := renderLock{Vars: masked, MaskedVars: keyPaths}
+	got, err := lockedVars(lock, vars)
+	require.NoError(t, err, "lockedVars")
+	assert.Equal(t, vars, got, "masked variables are filled")
+	assert.Empty(t, overriddenVars(lock, vars), "overridden vars")
+
+	_, err = lockedVars(lock, map[string]interface{}{"name": "api"})
+	require.Error(t, err, "masked variables are missing")
+	assert.Contains(t, err.Error(), `tls.tls\.secret`)
+}
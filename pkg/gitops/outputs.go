@@ -0,0 +1,105 @@
+package gitops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// outputs are the results of a run exported for following steps.
+type outputs struct {
+	// HasChanges is true if the deploy repository changed.
+	HasChanges bool `json:"has_changes"`
+	// DryRun is true if changes weren't pushed (dry-run mode).
+	DryRun bool `json:"dry_run"`
+	// CommitSHA is the pushed commit (or the branch tip without changes).
+	CommitSHA string `json:"commit_sha"`
+	// Branch is the branch the commit was pushed to.
+	Branch string `json:"branch"`
+	// ChangedFiles are all added, modified and deleted files.
+	ChangedFiles []string `json:"changed_files"`
+	// AddedFiles are the added files.
+	AddedFiles []string `json:"added_files"`
+	// ModifiedFiles are the modified files.
+	ModifiedFiles []string `json:"modified_files"`
+	// DeletedFiles are the deleted files.
+	DeletedFiles []string `json:"deleted_files"`
+	// PullRequestURL is the URL of the opened pull request (if any).
+	PullRequestURL string `json:"pull_request_url,omitempty"`
+	// PullRequestNumber is the number of the opened pull request (if any).
+	PullRequestNumber int `json:"pull_request_number,omitempty"`
+	// ChartVersions are the versions of the charts of deploy folders
+	// (if chart versions are bumped).
+	ChartVersions []chartVersion `json:"chart_versions,omitempty"`
+}
+
+// setChanges sets changed files of the outputs.
+func (o *outputs) setChanges(changes []fileChange) {
+	o.HasChanges = len(changes) > 0
+	o.ChangedFiles = []string{}
+	o.AddedFiles = []string{}
+	o.ModifiedFiles = []string{}
+	o.DeletedFiles = []string{}
+	for _, c := range changes {
+		o.ChangedFiles = append(o.ChangedFiles, c.path)
+		switch c.status {
+		case fileAdded:
+			o.AddedFiles = append(o.AddedFiles, c.path)
+		case fileModified:
+			o.ModifiedFiles = append(o.ModifiedFiles, c.path)
+		case fileDeleted:
+			o.DeletedFiles = append(o.DeletedFiles, c.path)
+		}
+	}
+}
+
+// export exports outputs as environment variables (lists are newline
+// separated) and writes them to a JSON summary file (if it's path is given).
+func (o outputs) export(exportEnv envExporter, summaryPath string) error {
+	envs := []struct{ name, value string }{
+		{"GITOPS_HAS_CHANGES", strconv.FormatBool(o.HasChanges)},
+		{"GITOPS_COMMIT_SHA", o.CommitSHA},
+		{"GITOPS_BRANCH", o.Branch},
+		{"GITOPS_CHANGED_FILES", strings.Join(o.ChangedFiles, "\n")},
+		{"GITOPS_ADDED_FILES", strings.Join(o.AddedFiles, "\n")},
+		{"GITOPS_MODIFIED_FILES", strings.Join(o.ModifiedFiles, "\n")},
+		{"GITOPS_DELETED_FILES", strings.Join(o.DeletedFiles, "\n")},
+	}
+	if o.PullRequestURL != "" {
+		envs = append(envs,
+			struct{ name, value string }{"PR_URL", o.PullRequestURL},
+			struct{ name, value string }{"GITOPS_PR_NUMBER", strconv.Itoa(o.PullRequestNumber)},
+		)
+	}
+	if len(o.ChartVersions) > 0 {
+		versions := make([]string, 0, len(o.ChartVersions))
+		for _, v := range o.ChartVersions {
+			versions = append(versions, v.Version)
+		}
+		envs = append(envs,
+			struct{ name, value string }{"GITOPS_CHART_VERSION", strings.Join(versions, "\n")},
+		)
+	}
+	for _, env := range envs {
+		if err := exportEnv(env.name, env.value); err != nil {
+			return fmt.Errorf("export %s env var: %w", env.name, err)
+		}
+	}
+
+	if summaryPath == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal summary: %w", err)
+	}
+	if err := ioutil.WriteFile(summaryPath, b, 0644); err != nil {
+		return fmt.Errorf("write summary file: %w", err)
+	}
+	if err := exportEnv("GITOPS_SUMMARY_PATH", summaryPath); err != nil {
+		return fmt.Errorf("export GITOPS_SUMMARY_PATH env var: %w", err)
+	}
+	return nil
+}
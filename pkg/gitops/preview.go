@@ -0,0 +1,153 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trailerPreviewRemoved is the trailer key of removed preview environments.
+const trailerPreviewRemoved = "Gitops-Preview-Removed"
+
+// previewNumberPattern matches pull request numbers of preview environments.
+var previewNumberPattern = regexp.MustCompile(`^[0-9]+$`)
+
+// PreviewName returns the name of the preview environment of a pull request.
+func PreviewName(number string) string {
+	return "pr-" + number
+}
+
+// removedPreview is a removed preview environment.
+type removedPreview struct {
+	number string
+	reason string
+}
+
+// PreviewRemover removes preview environments of pull requests (their
+// deploy folder and ArgoCD application) when they're torn down or expired.
+type PreviewRemover struct {
+	// Repo is local clone of remote repository.
+	Repo repositorier
+	// Folder is the folder of preview environments (relative to the root),
+	// each of them is in a subfolder named by it's pull request number.
+	Folder string
+	// Numbers are the pull request numbers of torn down previews.
+	Numbers []string
+	// TTL removes previews which weren't deployed for this long
+	// (previews don't expire without it).
+	TTL time.Duration
+	// Keep is the pull request number of a preview which isn't removed
+	// even if it's expired (e.g. the one being deployed).
+	Keep string
+	// Applications are the ArgoCD manifests of previews (their applications
+	// aren't given, only the folder and the application set).
+	Applications ArgoApplications
+	// AppName is the name prefix of the applications of previews.
+	AppName string
+
+	now     func() time.Time
+	removed []removedPreview
+}
+
+// PreviewRemover implements the renderAllFileser and changeDescriber interfaces.
+var (
+	_ renderAllFileser = (*PreviewRemover)(nil)
+	_ changeDescriber  = (*PreviewRemover)(nil)
+)
+
+// renderAllFiles removes the previews and returns slash separated paths of
+// the edited files (removed files aren't returned).
+func (pr *PreviewRemover) renderAllFiles() ([]string, error) {
+	pr.removed = nil
+	for _, number := range pr.Numbers {
+		pr.removed = append(pr.removed, removedPreview{number: number, reason: "pull request closed"})
+	}
+	expired, err := pr.expired()
+	if err != nil {
+		return nil, err
+	}
+	pr.removed = append(pr.removed, expired...)
+
+	var names []string
+	for _, p := range pr.removed {
+		folder := path.Join(filepath.ToSlash(filepath.Clean(pr.Folder)), p.number)
+		log.Printf("Removing preview %s (%s)\n", folder, p.reason)
+		if err := os.RemoveAll(filepath.Join(pr.Repo.LocalPath(), filepath.FromSlash(folder))); err != nil {
+			return nil, fmt.Errorf("remove preview %s: %w", folder, err)
+		}
+		names = append(names, pr.AppName+"-"+PreviewName(p.number))
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	edited, err := pr.Applications.remove(names)
+	if err != nil {
+		return nil, fmt.Errorf("remove applications of previews: %w", err)
+	}
+	return edited, nil
+}
+
+// expired returns the previews which weren't deployed within the TTL
+// (sorted by their pull request number).
+func (pr *PreviewRemover) expired() ([]removedPreview, error) {
+	if pr.TTL <= 0 {
+		return nil, nil
+	}
+	folder := filepath.ToSlash(filepath.Clean(pr.Folder))
+	entries, err := ioutil.ReadDir(filepath.Join(pr.Repo.LocalPath(), filepath.FromSlash(folder)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read previews folder: %w", err)
+	}
+	now := time.Now
+	if pr.now != nil {
+		now = pr.now
+	}
+	var expired []removedPreview
+	for _, e := range entries {
+		number := e.Name()
+		if !e.IsDir() || !previewNumberPattern.MatchString(number) || number == pr.Keep || containsString(pr.Numbers, number) {
+			continue
+		}
+		deployed, err := pr.Repo.lastCommitTime(path.Join(folder, number))
+		if err != nil {
+			return nil, fmt.Errorf("last deployment of preview %s: %w", number, err)
+		}
+		if deployed.IsZero() || now().Sub(deployed) < pr.TTL {
+			continue
+		}
+		days := int(now().Sub(deployed).Hours() / 24)
+		expired = append(expired, removedPreview{number: number, reason: fmt.Sprintf("not deployed for %d days", days)})
+	}
+	// Numbers are sorted numerically (they don't have leading zeros).
+	sort.Slice(expired, func(i, j int) bool {
+		a, b := expired[i].number, expired[j].number
+		return len(a) < len(b) || len(a) == len(b) && a < b
+	})
+	return expired, nil
+}
+
+// describeChange lists the removed previews.
+func (pr *PreviewRemover) describeChange() (changeDescription, error) {
+	if len(pr.removed) == 0 {
+		return changeDescription{}, nil
+	}
+	var description changeDescription
+	lines := []string{"Removed preview environments:"}
+	for _, p := range pr.removed {
+		folder := path.Join(filepath.ToSlash(filepath.Clean(pr.Folder)), p.number)
+		lines = append(lines, fmt.Sprintf("- `%s` (%s)", folder, p.reason))
+		description.trailers = append(description.trailers, Trailer{trailerPreviewRemoved, p.number})
+	}
+	description.summary = strings.Join(lines, "\n")
+	return description, nil
+}
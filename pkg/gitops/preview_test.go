@@ -0,0 +1,75 @@
+package gitops
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewRemover(t *testing.T) {
+	repo, close := localClone(t)
+	defer close()
+
+	for _, number := range []string{"9", "17", "42", "50"} {
+		require.NoError(t, os.MkdirAll(path.Join(repo.LocalPath(), "previews", number), 0700))
+		write(t, path.Join(repo.LocalPath(), "previews", number, "deployment.yaml"), "kind: Deployment\n")
+	}
+	require.NoError(t, os.MkdirAll(path.Join(repo.LocalPath(), "apps"), 0700))
+	for _, number := range []string{"17", "42", "50"} {
+		write(t, path.Join(repo.LocalPath(), "apps", "shop-pr-"+number+".yaml"), "kind: Application\n")
+	}
+	require.NoError(t, os.MkdirAll(path.Join(repo.LocalPath(), "previews", "notes"), 0700))
+	write(t, path.Join(repo.LocalPath(), "previews", "notes", "README.md"), "Previews of pull requests.\n")
+	require.NoError(t, repo.gitCommitAndPush("previews"), "commit previews")
+
+	pr := &PreviewRemover{
+		Repo:    repo,
+		Folder:  "previews/",
+		TTL:     7 * 24 * time.Hour,
+		AppName: "shop",
+		Applications: ArgoApplications{
+			Root:   repo.LocalPath(),
+			Folder: "apps",
+		},
+		now: time.Now,
+	}
+	pr.Numbers = []string{"42"}
+	_, err := pr.renderAllFiles()
+	require.NoError(t, err, "renderAllFiles")
+	changes, err := repo.changes()
+	require.NoError(t, err, "changes")
+	assert.Equal(t, []fileChange{
+		{path: "apps/shop-pr-42.yaml", status: fileDeleted},
+		{path: "previews/42/deployment.yaml", status: fileDeleted},
+	}, changes, "torn down preview only (others aren't expired)")
+	require.NoError(t, repo.gitCommitAndPush("teardown"), "commit teardown")
+
+	pr.Numbers = nil
+	pr.Keep = "50"
+	pr.now = func() time.Time {
+		return time.Now().Add(10 * 24 * time.Hour)
+	}
+	_, err = pr.renderAllFiles()
+	require.NoError(t, err, "renderAllFiles")
+	changes, err = repo.changes()
+	require.NoError(t, err, "changes")
+	assert.Equal(t, []fileChange{
+		{path: "apps/shop-pr-17.yaml", status: fileDeleted},
+		{path: "previews/17/deployment.yaml", status: fileDeleted},
+		{path: "previews/9/deployment.yaml", status: fileDeleted},
+	}, changes, "expired previews except the kept one")
+
+	description, err := pr.describeChange()
+	require.NoError(t, err, "describeChange")
+	assert.Equal(t, "Removed preview environments:\n"+
+		"- `previews/9` (not deployed for 10 days)\n"+
+		"- `previews/17` (not deployed for 10 days)", description.summary)
+	assert.Equal(t, []Trailer{
+		{trailerPreviewRemoved, "9"},
+		{trailerPreviewRemoved, "17"},
+	}, description.trailers)
+}
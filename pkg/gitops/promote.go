@@ -0,0 +1,216 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxSummaryDiffLen caps the diff in pull request bodies
+// (Github limits the length of the body).
+const maxSummaryDiffLen = 50000
+
+//go:generate moq -out promote_moq_test.go . changeDescriber
+type changeDescriber interface {
+	// describeChange describes changes of renderers which don't render
+	// templates (e.g. promotion). The description is added to the commit
+	// and the pull request.
+	describeChange() (changeDescription, error)
+}
+
+// changeDescription describes a change.
+type changeDescription struct {
+	// summary is appended to the pull request body (markdown).
+	summary string
+	// trailers are appended to the commit message.
+	trailers []Trailer
+}
+
+// PromoteKey is a key of a file whose value is promoted.
+type PromoteKey struct {
+	// File is the slash separated path relative to the deploy folders.
+	File string
+	// KeyPath is the key path of the value in the YAML file.
+	KeyPath []string
+}
+
+// ParsePromoteKeys parses a list of `<file>:<key path>` strings
+// (e.g. `values.yaml:image.tag`).
+func ParsePromoteKeys(a []string) ([]PromoteKey, error) {
+	var keys []PromoteKey
+	for _, s := range a {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		i := strings.LastIndex(s, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("key %q: must be <file>:<key path>", s)
+		}
+		keyPath, err := parseKeyPath(s[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", s, err)
+		}
+		keys = append(keys, PromoteKey{File: s[:i], KeyPath: keyPath})
+	}
+	return keys, nil
+}
+
+// PromoteRenderer promotes the state of a deploy folder to another one in the
+// local clone (instead of rendering templates), so the promotion is pushed
+// (or opened as a pull request) like any other change.
+type PromoteRenderer struct {
+	// Repo is local clone of remote repository.
+	Repo repositorier
+	// From is the deploy folder to promote (e.g. staging).
+	From string
+	// To is the deploy folder to promote to (e.g. prod).
+	To string
+	// Keys are the only values promoted (whole files are promoted without them).
+	Keys []PromoteKey
+}
+
+// PromoteRenderer implements the renderAllFileser
+// and the changeDescriber interfaces.
+var (
+	_ renderAllFileser = (*PromoteRenderer)(nil)
+	_ changeDescriber  = (*PromoteRenderer)(nil)
+)
+
+// renderAllFiles copies all files of the source folder to the target folder
+// (deleting files which aren't in the source folder) or only the values of
+// the keys. Step metadata (history and lock) isn't promoted. It returns slash
+// separated paths of the promoted files.
+func (pr PromoteRenderer) renderAllFiles() ([]string, error) {
+	if len(pr.Keys) > 0 {
+		return pr.promoteKeys()
+	}
+	from := filepath.Join(pr.Repo.LocalPath(), pr.From)
+	to := filepath.Join(pr.Repo.LocalPath(), pr.To)
+	fromFiles, err := folderFiles(from)
+	if err != nil {
+		return nil, fmt.Errorf("files of %q: %w", pr.From, err)
+	}
+	if len(fromFiles) == 0 {
+		return nil, fmt.Errorf("folder %q has no files to promote", pr.From)
+	}
+	toFiles, err := folderFiles(to)
+	if err != nil {
+		return nil, fmt.Errorf("files of %q: %w", pr.To, err)
+	}
+
+	var promoted []string
+	for file, content := range fromFiles {
+		filePath := filepath.Join(to, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return nil, fmt.Errorf("create folder of %q: %w", file, err)
+		}
+		if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+			return nil, fmt.Errorf("write %q: %w", file, err)
+		}
+		promoted = append(promoted, path.Join(filepath.ToSlash(pr.To), file))
+	}
+	for file := range toFiles {
+		if _, ok := fromFiles[file]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(to, filepath.FromSlash(file))); err != nil {
+			return nil, fmt.Errorf("delete %q: %w", file, err)
+		}
+	}
+	sort.Strings(promoted)
+	return promoted, nil
+}
+
+// promoteKeys copies values of the keys from the source folder's files
+// to the same files of the target folder.
+func (pr PromoteRenderer) promoteKeys() ([]string, error) {
+	var promoted []string
+	for _, key := range pr.Keys {
+		name := fmt.Sprintf("%s:%s", key.File, strings.Join(key.KeyPath, "."))
+		fromContent, err := ioutil.ReadFile(filepath.Join(pr.Repo.LocalPath(), pr.From, filepath.FromSlash(key.File)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: read source file: %w", name, err)
+		}
+		value, err := lookupYAMLScalar(fromContent, key.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: source value: %w", name, err)
+		}
+
+		toPath := filepath.Join(pr.Repo.LocalPath(), pr.To, filepath.FromSlash(key.File))
+		toContent, err := ioutil.ReadFile(toPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: read target file: %w", name, err)
+		}
+		edited, err := setYAMLScalar(toContent, key.KeyPath, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: set target value: %w", name, err)
+		}
+		if err := ioutil.WriteFile(toPath, edited, 0644); err != nil {
+			return nil, fmt.Errorf("%s: write target file: %w", name, err)
+		}
+		promoted = append(promoted, path.Join(filepath.ToSlash(pr.To), key.File))
+	}
+	return promoted, nil
+}
+
+// describeChange describes the promotion: the source commit deployed to the
+// source folder and the diff of the target folder.
+func (pr PromoteRenderer) describeChange() (changeDescription, error) {
+	sourceCommit, err := pr.sourceCommit()
+	if err != nil {
+		return changeDescription{}, fmt.Errorf("promoted source commit: %w", err)
+	}
+	diff, err := pr.Repo.diff()
+	if err != nil {
+		return changeDescription{}, fmt.Errorf("diff: %w", err)
+	}
+	if len(diff) > maxSummaryDiffLen {
+		diff = diff[:maxSummaryDiffLen] + "\n... (truncated)\n"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "### Promotion of `%s` to `%s`\n", pr.From, pr.To)
+	if sourceCommit != "" {
+		fmt.Fprintf(&b, "Source commit: `%s`\n", sourceCommit)
+	} else {
+		b.WriteString("Source commit: unknown\n")
+	}
+	fmt.Fprintf(&b, "\n```diff\n%s```\n", diff)
+
+	trailers := []Trailer{{trailerPromotedFrom, filepath.ToSlash(filepath.Clean(pr.From))}}
+	if sourceCommit != "" {
+		trailers = append(trailers, Trailer{trailerSourceCommit, sourceCommit})
+	}
+	return changeDescription{summary: b.String(), trailers: trailers}, nil
+}
+
+// sourceCommit returns the source commit deployed to the source folder:
+// from it's lock file or from the latest commit of the step (if it wasn't
+// a rollback). It's empty if it's unknown.
+func (pr PromoteRenderer) sourceCommit() (string, error) {
+	lockPath := filepath.Join(pr.Repo.LocalPath(), pr.From, historyFolder, lockFile)
+	if _, err := os.Stat(lockPath); err == nil {
+		lock, err := readLock(lockPath)
+		if err != nil {
+			return "", fmt.Errorf("read lock: %w", err)
+		}
+		return lock.SourceCommit, nil
+	}
+
+	commits, err := pr.Repo.stepCommits(filepath.ToSlash(filepath.Clean(pr.From)))
+	if err != nil {
+		return "", fmt.Errorf("commits of %q: %w", pr.From, err)
+	}
+	if len(commits) == 0 || commits[0].isRollback() {
+		return "", nil
+	}
+	if shas := commits[0].trailers[trailerSourceCommit]; len(shas) > 0 {
+		return shas[0], nil
+	}
+	return "", nil
+}
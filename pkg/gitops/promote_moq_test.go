@@ -0,0 +1,66 @@
+// Code generated by moq; DO NOT EDIT.
+// github.com/matryer/moq
+
+package gitops
+
+import (
+	"sync"
+)
+
+// Ensure, that changeDescriberMock does implement changeDescriber.
+// If this is not the case, regenerate this file with moq.
+var _ changeDescriber = &changeDescriberMock{}
+
+// changeDescriberMock is a mock implementation of changeDescriber.
+//
+//     func TestSomethingThatUseschangeDescriber(t *testing.T) {
+//
+//         // make and configure a mocked changeDescriber
+//         mockedchangeDescriber := &changeDescriberMock{
+//             describeChangeFunc: func() (changeDescription, error) {
+// 	               panic("mock out the describeChange method")
+//             },
+//         }
+//
+//         // use mockedchangeDescriber in code that requires changeDescriber
+//         // and then make assertions.
+//
+//     }
+type changeDescriberMock struct {
+	// describeChangeFunc mocks the describeChange method.
+	describeChangeFunc func() (changeDescription, error)
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// describeChange holds details about calls to the describeChange method.
+		describeChange []struct {
+		}
+	}
+	lockdescribeChange sync.RWMutex
+}
+
+// describeChange calls describeChangeFunc.
+func (mock *changeDescriberMock) describeChange() (changeDescription, error) {
+	if mock.describeChangeFunc == nil {
+		panic("changeDescriberMock.describeChangeFunc: method is nil but changeDescriber.describeChange was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockdescribeChange.Lock()
+	mock.calls.describeChange = append(mock.calls.describeChange, callInfo)
+	mock.lockdescribeChange.Unlock()
+	return mock.describeChangeFunc()
+}
+
+// describeChangeCalls gets all the calls that were made to describeChange.
+// Check the length with:
+//     len(mockedchangeDescriber.describeChangeCalls())
+func (mock *changeDescriberMock) describeChangeCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockdescribeChange.RLock()
+	calls = mock.calls.describeChange
+	mock.lockdescribeChange.RUnlock()
+	return calls
+}
@@ -0,0 +1,104 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePromoteKeys(t *testing.T) {
+	keys, err := ParsePromoteKeys([]string{"values.yaml:image.tag", "", "apps/api.yaml:spec.replicas"})
+	require.NoError(t, err, "ParsePromoteKeys")
+	assert.Equal(t, []PromoteKey{
+		{File: "values.yaml", KeyPath: []string{"image", "tag"}},
+		{File: "apps/api.yaml", KeyPath: []string{"spec", "replicas"}},
+	}, keys)
+
+	_, err = ParsePromoteKeys([]string{"values.yaml"})
+	require.Error(t, err, "without key path")
+	_, err = ParsePromoteKeys([]string{"values.yaml:image..tag"})
+	require.Error(t, err, "empty key")
+}
+
+var promoteCases = map[string]struct {
+	keys      []PromoteKey
+	wantFiles []string
+	wantProd  map[string]string
+}{
+	"whole folder": {
+		wantFiles: []string{"prod/new.yaml", "prod/values.yaml"},
+		wantProd: map[string]string{
+			"values.yaml":           "# staging\nimage:\n  tag: v2\nreplicas: 1\n",
+			"new.yaml":              "new\n",
+			".gitops/history.jsonl": "prod history\n",
+		},
+	},
+	"whitelisted keys only": {
+		keys:      []PromoteKey{{File: "values.yaml", KeyPath: []string{"image", "tag"}}},
+		wantFiles: []string{"prod/values.yaml"},
+		wantProd: map[string]string{
+			"values.yaml":           "# prod\nimage:\n  tag: v2 # promoted\nreplicas: 5\n",
+			"extra.yaml":            "prod only\n",
+			".gitops/history.jsonl": "prod history\n",
+		},
+	},
+}
+
+func TestPromoteRenderer(t *testing.T) {
+	for name, tc := range promoteCases {
+		t.Run(name, func(t *testing.T) {
+			repo, close := localClone(t)
+			defer close()
+
+			files := map[string]string{
+				"staging/values.yaml":           "# staging\nimage:\n  tag: v2\nreplicas: 1\n",
+				"staging/new.yaml":              "new\n",
+				"staging/.gitops/history.jsonl": "staging history\n",
+				"prod/values.yaml":              "# prod\nimage:\n  tag: v1 # promoted\nreplicas: 5\n",
+				"prod/extra.yaml":               "prod only\n",
+				"prod/.gitops/history.jsonl":    "prod history\n",
+			}
+			for file, content := range files {
+				filePath := path.Join(repo.LocalPath(), file)
+				require.NoError(t, os.MkdirAll(path.Dir(filePath), 0700))
+				write(t, filePath, content)
+			}
+			trailers := CommitTrailers([]string{"staging"}, BuildInfo{SourceCommit: "abc123"})
+			require.NoError(t, repo.gitCommitAndPush(withTrailers("deploy staging", trailers)))
+
+			pr := PromoteRenderer{Repo: repo, From: "staging", To: "prod", Keys: tc.keys}
+			gotFiles, err := pr.renderAllFiles()
+			require.NoError(t, err, "renderAllFiles")
+			assert.Equal(t, tc.wantFiles, gotFiles, "promoted files")
+
+			gotProd, err := folderFiles(path.Join(repo.LocalPath(), "prod"))
+			require.NoError(t, err, "files of prod")
+			for file, want := range tc.wantProd {
+				if file == ".gitops/history.jsonl" {
+					// Metadata isn't returned by folderFiles.
+					got, err := ioutil.ReadFile(path.Join(repo.LocalPath(), "prod", file))
+					require.NoError(t, err, "read %s", file)
+					assert.Equal(t, want, string(got), "prod %s", file)
+					continue
+				}
+				assert.Equal(t, want, string(gotProd[file]), "prod %s", file)
+			}
+			assert.Len(t, gotProd, len(tc.wantProd)-1, "files of prod")
+
+			// Promotion is described by the promoted source commit and the diff.
+			desc, err := pr.describeChange()
+			require.NoError(t, err, "describeChange")
+			assert.Contains(t, desc.summary, "Promotion of `staging` to `prod`")
+			assert.Contains(t, desc.summary, "Source commit: `abc123`")
+			assert.Contains(t, desc.summary, "+  tag: v2")
+			assert.Equal(t, []Trailer{
+				{trailerPromotedFrom, "staging"},
+				{trailerSourceCommit, "abc123"},
+			}, desc.trailers, "trailers")
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package gitops
+
+import (
+	"fmt"
+	"os"
+)
+
+// NewRenderer returns a renderer of templates. The same templates
+// are rendered to each environment (if there are any).
+func NewRenderer(templates TemplatesRenderer, envs []Environment) renderAllFileser {
+	if len(envs) == 0 {
+		return templates
+	}
+	return EnvironmentsRenderer{Templates: templates, Environments: envs}
+}
+
+// RenderParams are parameters for Render function.
+type RenderParams struct {
+	// Renderer renders templates to the output folder.
+	Renderer renderAllFileser
+	// OutputFolder is the local folder to render templates to.
+	OutputFolder string
+	// ExportEnv is an environment variable exporter.
+	ExportEnv envExporter
+	// Validator validates rendered manifests (optional).
+	Validator manifestValidator
+}
+
+// Render renders templates to a local folder only (without touching the
+// deploy repository). Path of the folder is exported to the
+// GITOPS_RENDERED_PATH environment variable.
+func Render(p RenderParams) error {
+	if err := os.MkdirAll(p.OutputFolder, 0755); err != nil {
+		return fmt.Errorf("create output folder: %w", err)
+	}
+	rendered, err := p.Renderer.renderAllFiles()
+	if err != nil {
+		return fmt.Errorf("render all files: %w", err)
+	}
+	if p.Validator != nil {
+		if err := p.Validator.validateManifests(p.OutputFolder, rendered); err != nil {
+			return fmt.Errorf("validate manifests: %w", err)
+		}
+	}
+	if err := p.ExportEnv("GITOPS_RENDERED_PATH", p.OutputFolder); err != nil {
+		return fmt.Errorf("export GITOPS_RENDERED_PATH env var: %w", err)
+	}
+	return nil
+}
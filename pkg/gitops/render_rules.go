@@ -0,0 +1,125 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// templateSuffixes are suffixes of files which are always rendered as
+// templates. They are stripped from the rendered file's name.
+var templateSuffixes = []string{".gotmpl", ".tmpl"}
+
+// binarySniffLen is the length of the prefix of a file used
+// to detect binary files (same as git does).
+const binarySniffLen = 8000
+
+// DelimitersRule sets template delimiters of files matching a pattern.
+type DelimitersRule struct {
+	// Pattern is a glob matching slash separated paths relative to the
+	// templates folder (or only file names if it doesn't contain a slash).
+	Pattern string `yaml:"pattern"`
+	// Left and Right are the template delimiters (e.g. `[[` and `]]`).
+	Left  string `yaml:"left"`
+	Right string `yaml:"right"`
+}
+
+// parseDelimitersRules returns delimiters rules deserialized from
+// a list of `<pattern> <left> <right>` strings.
+func parseDelimitersRules(a []string) ([]DelimitersRule, error) {
+	var rules []DelimitersRule
+	for _, s := range a {
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("rule %q: must be <pattern> <left> <right>", s)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", s, err)
+		}
+		rules = append(rules, DelimitersRule{
+			Pattern: fields[0],
+			Left:    fields[1],
+			Right:   fields[2],
+		})
+	}
+	return rules, nil
+}
+
+// renderRule tells how a file of the templates folder is rendered.
+type renderRule struct {
+	// verbatim files are copied as-is (they aren't templates).
+	verbatim bool
+	// left and right are the template delimiters (empty means default).
+	left, right string
+}
+
+// renderRule returns how a given file (slash separated path relative to the
+// source folder) is rendered. Files with a template suffix are always
+// rendered. Other files are copied verbatim if only suffixed files are
+// templates, if they match a verbatim pattern or if they are binary.
+func (tr TemplatesRenderer) renderRule(file string, content []byte) renderRule {
+	var rule renderRule
+	for _, r := range tr.Delimiters {
+		if matchPath(r.Pattern, file) {
+			rule.left, rule.right = r.Left, r.Right
+			break
+		}
+	}
+	if hasTemplateSuffix(file) {
+		return rule
+	}
+	if tr.SuffixedTemplatesOnly || isBinary(content) {
+		rule.verbatim = true
+		return rule
+	}
+	for _, pattern := range tr.VerbatimPatterns {
+		if matchPath(pattern, file) {
+			rule.verbatim = true
+			return rule
+		}
+	}
+	return rule
+}
+
+// matchPath tells whether a slash separated path matches a glob pattern.
+// Patterns without a slash are matched against the file name only.
+func matchPath(pattern, file string) bool {
+	if !strings.Contains(pattern, "/") {
+		file = path.Base(file)
+	}
+	match, _ := path.Match(pattern, file)
+	return match
+}
+
+// hasTemplateSuffix tells whether a file name has a template suffix.
+func hasTemplateSuffix(file string) bool {
+	return trimTemplateSuffix(file) != file
+}
+
+// trimTemplateSuffix returns a file name without it's template suffix.
+func trimTemplateSuffix(file string) string {
+	for _, suffix := range templateSuffixes {
+		if strings.HasSuffix(file, suffix) && len(file) > len(suffix) {
+			return strings.TrimSuffix(file, suffix)
+		}
+	}
+	return file
+}
+
+// isBinary tells whether a file content is binary: it contains a NUL byte
+// or it isn't valid UTF-8 (only it's prefix is checked).
+func isBinary(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+		// Don't break the last (possibly multi-byte) UTF-8 character.
+		for i := 0; i < utf8.UTFMax && !utf8.Valid(content); i++ {
+			content = content[:len(content)-1]
+		}
+	}
+	return bytes.IndexByte(content, 0) != -1 || !utf8.Valid(content)
+}
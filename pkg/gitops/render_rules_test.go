@@ -0,0 +1,116 @@
+package gitops
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var renderRuleCases = map[string]struct {
+	renderer TemplatesRenderer
+	file     string
+	content  string
+	want     renderRule
+}{
+	"text files are templates by default": {
+		file:    "values.yaml",
+		content: "tag: {{ .tag }}",
+		want:    renderRule{},
+	},
+	"binary files are copied verbatim": {
+		file:    "logo.png",
+		content: "\x89PNG\r\n\x1a\n\x00\x00",
+		want:    renderRule{verbatim: true},
+	},
+	"invalid UTF-8 files are copied verbatim": {
+		file:    "data.bin",
+		content: "\xff\xfe\xfd",
+		want:    renderRule{verbatim: true},
+	},
+	"only suffixed files are templates": {
+		renderer: TemplatesRenderer{SuffixedTemplatesOnly: true},
+		file:     "charts/templates/deployment.yaml",
+		content:  "{{ .Values.tag }}",
+		want:     renderRule{verbatim: true},
+	},
+	"suffixed files are always templates": {
+		renderer: TemplatesRenderer{
+			SuffixedTemplatesOnly: true,
+			VerbatimPatterns:      []string{"*.yaml.tmpl"},
+		},
+		file:    "values.yaml.tmpl",
+		content: "tag: {{ .tag }}",
+		want:    renderRule{},
+	},
+	"file names match verbatim patterns without a slash": {
+		renderer: TemplatesRenderer{VerbatimPatterns: []string{"*.json"}},
+		file:     "dashboards/api.json",
+		content:  `{"expr": "{{ job }}"}`,
+		want:     renderRule{verbatim: true},
+	},
+	"paths match verbatim patterns with a slash": {
+		renderer: TemplatesRenderer{VerbatimPatterns: []string{"charts/*/*.yaml"}},
+		file:     "charts/templates/service.yaml",
+		content:  "{{ .Values.port }}",
+		want:     renderRule{verbatim: true},
+	},
+	"paths don't match other verbatim patterns": {
+		renderer: TemplatesRenderer{VerbatimPatterns: []string{"charts/*.yaml"}},
+		file:     "charts/templates/service.yaml",
+		content:  "{{ .port }}",
+		want:     renderRule{},
+	},
+	"first matching delimiters rule is used": {
+		renderer: TemplatesRenderer{Delimiters: []DelimitersRule{
+			{Pattern: "*.txt", Left: "<<", Right: ">>"},
+			{Pattern: "dashboards/*.json", Left: "[[", Right: "]]"},
+			{Pattern: "*.json", Left: "((", Right: "))"},
+		}},
+		file:    "dashboards/api.json",
+		content: `{"expr": "{{ job }}", "tag": "[[ .tag ]]"}`,
+		want:    renderRule{left: "[[", right: "]]"},
+	},
+}
+
+func TestRenderRule(t *testing.T) {
+	for name, tc := range renderRuleCases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.renderer.renderRule(tc.file, []byte(tc.content))
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, isBinary([]byte("plain text")), "plain text")
+	assert.False(t, isBinary(nil), "empty file")
+	assert.True(t, isBinary([]byte("nul\x00byte")), "nul byte")
+
+	// Multi-byte character cut in half at the end of the sniffed prefix.
+	long := strings.Repeat("a", binarySniffLen-1) + "é"
+	assert.False(t, isBinary([]byte(long)), "long text")
+}
+
+func TestTrimTemplateSuffix(t *testing.T) {
+	assert.Equal(t, "values.yaml", trimTemplateSuffix("values.yaml.tmpl"))
+	assert.Equal(t, "apps/values.yaml", trimTemplateSuffix("apps/values.yaml.gotmpl"))
+	assert.Equal(t, "values.yaml", trimTemplateSuffix("values.yaml"))
+	assert.Equal(t, ".tmpl", trimTemplateSuffix(".tmpl"))
+}
+
+func TestParseDelimitersRules(t *testing.T) {
+	got, err := parseDelimitersRules([]string{"dashboards/*.json [[ ]]", " ", "*.txt <% %>\n"})
+	require.NoError(t, err, "parseDelimitersRules")
+	assert.Equal(t, []DelimitersRule{
+		{Pattern: "dashboards/*.json", Left: "[[", Right: "]]"},
+		{Pattern: "*.txt", Left: "<%", Right: "%>"},
+	}, got)
+
+	_, err = parseDelimitersRules([]string{"*.json [["})
+	assert.Error(t, err, "missing right delimiter")
+
+	_, err = parseDelimitersRules([]string{"[ [[ ]]"})
+	assert.Error(t, err, "invalid pattern")
+}
@@ -0,0 +1,83 @@
+package gitops
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRenderer(t *testing.T) {
+	templates := TemplatesRenderer{SourceFolder: "templates"}
+	assert.Equal(t, templates, NewRenderer(templates, nil), "without environments")
+
+	envs := []Environment{{Name: "prod", DeployPath: "prod"}}
+	assert.Equal(t, EnvironmentsRenderer{
+		Templates:    templates,
+		Environments: envs,
+	}, NewRenderer(templates, envs), "with environments")
+}
+
+func TestRender(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp output dir")
+	defer os.RemoveAll(outputDir)
+	// Output folder is created if it doesn't exist.
+	outputFolder := path.Join(outputDir, "rendered")
+
+	var gotFilesRendered bool
+	renderer := &renderAllFileserMock{
+		renderAllFilesFunc: func() ([]string, error) {
+			_, err := os.Stat(outputFolder)
+			gotFilesRendered = err == nil
+			return nil, nil
+		},
+	}
+	var gotEnvVarName, gotEnvVarValue string
+	exportEnv := func(name, value string) error {
+		gotEnvVarName = name
+		gotEnvVarValue = value
+		return nil
+	}
+
+	err = Render(RenderParams{
+		Renderer:     renderer,
+		OutputFolder: outputFolder,
+		ExportEnv:    exportEnv,
+	})
+	require.NoError(t, err, "Render")
+	assert.True(t, gotFilesRendered, "files are rendered to existing output folder")
+	assert.Equal(t, "GITOPS_RENDERED_PATH", gotEnvVarName, "env var name")
+	assert.Equal(t, outputFolder, gotEnvVarValue, "env var value")
+}
+
+func TestRenderValidation(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp output dir")
+	defer os.RemoveAll(outputDir)
+
+	validator := &manifestValidatorMock{
+		validateManifestsFunc: func(string, []string) error {
+			return errors.New("invalid manifests")
+		},
+	}
+	err = Render(RenderParams{
+		Renderer: &renderAllFileserMock{
+			renderAllFilesFunc: func() ([]string, error) {
+				return []string{"prod/deployment.yaml"}, nil
+			},
+		},
+		OutputFolder: outputDir,
+		ExportEnv: func(string, string) error {
+			return nil
+		},
+		Validator: validator,
+	})
+	require.Error(t, err, "Render")
+	require.Len(t, validator.validateManifestsCalls(), 1, "manifests are validated")
+	assert.Equal(t, outputDir, validator.validateManifestsCalls()[0].Root, "root of manifests")
+}
@@ -0,0 +1,396 @@
+package gitops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runOutcome is the outcome of an UpdateFiles run.
+type runOutcome string
+
+// Possible outcomes of an UpdateFiles run.
+const (
+	outcomePushed      runOutcome = "pushed"
+	outcomePullRequest runOutcome = "pull_request"
+	outcomeNoOp        runOutcome = "no_op"
+	outcomeDryRun      runOutcome = "dry_run"
+	outcomeError       runOutcome = "error"
+)
+
+// errorClass tells which part of a run failed.
+type errorClass string
+
+// Possible classes of a failed run.
+const (
+	errorClassConfig     errorClass = "config"
+	errorClassRender     errorClass = "render"
+	errorClassGit        errorClass = "git"
+	errorClassGithub     errorClass = "github"
+	errorClassFilesystem errorClass = "filesystem"
+	errorClassExport     errorClass = "export"
+	errorClassArgoCD     errorClass = "argocd"
+	errorClassValidation errorClass = "validation"
+	errorClassUnknown    errorClass = "unknown"
+)
+
+// classifiedError is an error of a given class.
+type classifiedError struct {
+	class errorClass
+	err   error
+}
+
+func (e classifiedError) Error() string { return e.err.Error() }
+func (e classifiedError) Unwrap() error { return e.err }
+
+// classify sets the class of an error (unless it has one already).
+func classify(class errorClass, err error) error {
+	var ce classifiedError
+	if errors.As(err, &ce) {
+		return err
+	}
+	return classifiedError{class: class, err: err}
+}
+
+// Report is the machine-readable report of a run of the step. It's started
+// before anything touches the deploy repository, so failures of the setup
+// (e.g. of the Github client or of the clone) are reported as well.
+type Report struct {
+	run       *runReport
+	path      string
+	exportEnv envExporter
+}
+
+// NewReport starts the report of a run. The report is written to a JSON file
+// by Finish (it isn't written if the path is empty).
+func NewReport(path string, inputs map[string]string, exportEnv envExporter) *Report {
+	return &Report{run: newRunReport(inputs), path: path, exportEnv: exportEnv}
+}
+
+// Repository returns a repository which records all of it's operations
+// to the report.
+func (r *Report) Repository(repo repositorier) repositorier {
+	return reportingRepository{repositorier: repo, report: r.run}
+}
+
+// Finish completes the report with the result of the run and writes it even
+// if the run failed. It returns the error of the run (or of writing the
+// report if the run succeeded).
+func (r *Report) Finish(err error) error {
+	r.run.finish(err)
+	if r.path == "" {
+		return err
+	}
+	if reportErr := r.write(); reportErr != nil {
+		if err != nil {
+			log.Printf("warning: %s\n", reportErr)
+			return err
+		}
+		return reportErr
+	}
+	return err
+}
+
+// write writes the report and exports it's path.
+func (r *Report) write() error {
+	if err := r.run.write(r.path); err != nil {
+		return fmt.Errorf("run report: %w", err)
+	}
+	if err := r.exportEnv("GITOPS_REPORT_PATH", r.path); err != nil {
+		return fmt.Errorf("export GITOPS_REPORT_PATH env var: %w", err)
+	}
+	return nil
+}
+
+// runReport is a machine-readable report of an UpdateFiles run
+// (e.g. for dashboards aggregating deployments of many builds).
+type runReport struct {
+	StartedAt     time.Time         `json:"started_at"`
+	FinishedAt    time.Time         `json:"finished_at"`
+	DurationMs    int64             `json:"duration_ms"`
+	Inputs        map[string]string `json:"inputs"`
+	RenderedFiles []renderedFile    `json:"rendered_files"`
+	GitOperations []operation       `json:"git_operations"`
+	GithubCalls   []operation       `json:"github_calls"`
+	Outcome       runOutcome        `json:"outcome"`
+	ErrorClass    errorClass        `json:"error_class,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	Outputs       *outputs          `json:"outputs,omitempty"`
+}
+
+// renderedFile is a rendered file of the deploy repository.
+type renderedFile struct {
+	// Path is slash separated and relative to the repository root.
+	Path string `json:"path"`
+	// SHA256 is the hex encoded hash of the rendered content.
+	SHA256 string `json:"sha256"`
+}
+
+// operation is a timed git operation or Github API call.
+type operation struct {
+	Name       string `json:"name"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newRunReport(inputs map[string]string) *runReport {
+	if inputs == nil {
+		inputs = map[string]string{}
+	}
+	return &runReport{
+		StartedAt:     time.Now(),
+		Inputs:        inputs,
+		RenderedFiles: []renderedFile{},
+		GitOperations: []operation{},
+		GithubCalls:   []operation{},
+	}
+}
+
+// addRenderedFiles hashes rendered files (relative to a root folder).
+func (r *runReport) addRenderedFiles(root string, files []string) error {
+	for _, file := range files {
+		b, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			return fmt.Errorf("read rendered file: %w", err)
+		}
+		sum := sha256.Sum256(b)
+		r.RenderedFiles = append(r.RenderedFiles, renderedFile{
+			Path:   file,
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+	return nil
+}
+
+// record runs and times an operation. The error of the operation is
+// classified as a Github error for Github calls, otherwise as a git error.
+func (r *runReport) record(name string, github bool, f func() error) error {
+	start := time.Now()
+	err := f()
+	op := operation{Name: name, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		op.Error = err.Error()
+	}
+	if github {
+		r.GithubCalls = append(r.GithubCalls, op)
+		if err != nil {
+			return classify(errorClassGithub, err)
+		}
+		return nil
+	}
+	r.GitOperations = append(r.GitOperations, op)
+	if err != nil {
+		return classify(errorClassGit, err)
+	}
+	return nil
+}
+
+// finish completes the report with the result of the run.
+func (r *runReport) finish(err error) {
+	r.FinishedAt = time.Now()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	if err == nil {
+		return
+	}
+	r.Outcome = outcomeError
+	r.Error = err.Error()
+	r.ErrorClass = errorClassUnknown
+	var ce classifiedError
+	if errors.As(err, &ce) {
+		r.ErrorClass = ce.class
+	}
+}
+
+// write writes the report to a JSON file.
+func (r *runReport) write(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("write report file: %w", err)
+	}
+	return nil
+}
+
+// reportingRepository records all operations of a repository to a report.
+type reportingRepository struct {
+	repositorier
+	report *runReport
+}
+
+// reportingRepository implements the repositorier interface.
+var _ repositorier = (*reportingRepository)(nil)
+
+func (r reportingRepository) gitClone() error {
+	return r.report.record("clone", false, r.repositorier.gitClone)
+}
+
+func (r reportingRepository) changes() (changes []fileChange, err error) {
+	err = r.report.record("status", false, func() error {
+		changes, err = r.repositorier.changes()
+		return err
+	})
+	return changes, err
+}
+
+func (r reportingRepository) diff() (diff string, err error) {
+	err = r.report.record("diff", false, func() error {
+		diff, err = r.repositorier.diff()
+		return err
+	})
+	return diff, err
+}
+
+func (r reportingRepository) gitCheckoutNewBranch() error {
+	return r.report.record("checkout new branch", false, r.repositorier.gitCheckoutNewBranch)
+}
+
+func (r reportingRepository) gitCommitAndPush(message string) error {
+	return r.report.record("commit and push", false, func() error {
+		return r.repositorier.gitCommitAndPush(message)
+	})
+}
+
+func (r reportingRepository) currentBranch() (branch string, err error) {
+	err = r.report.record("current branch", false, func() error {
+		branch, err = r.repositorier.currentBranch()
+		return err
+	})
+	return branch, err
+}
+
+func (r reportingRepository) headCommit() (sha string, err error) {
+	err = r.report.record("head commit", false, func() error {
+		sha, err = r.repositorier.headCommit()
+		return err
+	})
+	return sha, err
+}
+
+func (r reportingRepository) stepCommits(folder string) (commits []stepCommit, err error) {
+	err = r.report.record("log", false, func() error {
+		commits, err = r.repositorier.stepCommits(folder)
+		return err
+	})
+	return commits, err
+}
+
+func (r reportingRepository) restoreFolder(revision, folder string) (files []string, err error) {
+	err = r.report.record("restore folder", false, func() error {
+		files, err = r.repositorier.restoreFolder(revision, folder)
+		return err
+	})
+	return files, err
+}
+
+func (r reportingRepository) committedFile(file string) (content []byte, ok bool, err error) {
+	err = r.report.record("committed file", false, func() error {
+		content, ok, err = r.repositorier.committedFile(file)
+		return err
+	})
+	return content, ok, err
+}
+
+func (r reportingRepository) lastCommitTime(path string) (t time.Time, err error) {
+	err = r.report.record("last commit time", false, func() error {
+		t, err = r.repositorier.lastCommitTime(path)
+		return err
+	})
+	return t, err
+}
+
+func (r reportingRepository) openPullRequest(ctx context.Context, title, body string) (pr pullRequest, err error) {
+	err = r.report.record("open pull request", true, func() error {
+		pr, err = r.repositorier.openPullRequest(ctx, title, body)
+		return err
+	})
+	return pr, err
+}
+
+// redacted replaces secrets in reported values.
+const redacted = "REDACTED"
+
+// redactURL redacts the password (or token) of an URL with credentials.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	} else if u.Scheme == "http" || u.Scheme == "https" {
+		// A username only is a token over HTTP (e.g. https://<token>@github.com).
+		u.User = url.User(redacted)
+	}
+	return u.String()
+}
+
+// maskVars masks secret looking variables of a vars input (like lock files
+// do). The whole input is redacted if it can't be parsed.
+func maskVars(s string) string {
+	vars, err := parseVars(s)
+	if err != nil {
+		return redacted
+	}
+	masked, keyPaths := maskSecrets(vars, "")
+	if len(keyPaths) == 0 {
+		return s
+	}
+	return marshalMasked(masked)
+}
+
+// maskEnvironments masks secret looking variables of an environments input.
+// The whole input is redacted if it can't be parsed.
+func maskEnvironments(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return s
+	}
+	var envs []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(s), &envs); err != nil {
+		return redacted
+	}
+	var keyPaths []string
+	for i, env := range envs {
+		var masked []string
+		envs[i], masked = maskSecrets(env, "")
+		keyPaths = append(keyPaths, masked...)
+	}
+	if len(keyPaths) == 0 {
+		return s
+	}
+	return marshalMasked(envs)
+}
+
+// maskKeyValues masks the values of secret looking keys of `<key>=<value>`
+// inputs (e.g. `flux_values` or `yaml_updates`).
+func maskKeyValues(a []string) []string {
+	masked := make([]string, len(a))
+	for i, s := range a {
+		masked[i] = s
+		if j := strings.Index(s, "="); j > 0 && secretKeyPattern.MatchString(s[:j]) {
+			masked[i] = s[:j+1] + redacted
+		}
+	}
+	return masked
+}
+
+// marshalMasked returns masked values as YAML.
+func marshalMasked(v interface{}) string {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return redacted
+	}
+	return strings.TrimSpace(string(b))
+}
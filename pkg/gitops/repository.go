@@ -1,11 +1,15 @@
 package gitops
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,12 +17,19 @@ import (
 //go:generate moq -out repository_moq_test.go . repositorier
 type repositorier interface {
 	Close(ctx context.Context) []error
-	localPath() string
+	LocalPath() string
 	gitClone() error
-	workingDirectoryClean() (bool, error)
+	changes() ([]fileChange, error)
+	diff() (string, error)
 	gitCheckoutNewBranch() error
 	gitCommitAndPush(message string) error
-	openPullRequest(ctx context.Context, title, body string) (string, error)
+	currentBranch() (string, error)
+	headCommit() (string, error)
+	stepCommits(folder string) ([]stepCommit, error)
+	restoreFolder(revision, folder string) ([]string, error)
+	committedFile(file string) ([]byte, bool, error)
+	lastCommitTime(path string) (time.Time, error)
+	openPullRequest(ctx context.Context, title, body string) (pullRequest, error)
 }
 
 // repository implements the repositorier interface.
@@ -49,7 +60,7 @@ func NewRepository(ctx context.Context, p NewRepositoryParams) (*repository, err
 	// Temporary directory for local clone of repository.
 	tmpRepoPath, err := ioutil.TempDir("", "")
 	if err != nil {
-		return nil, fmt.Errorf("create temp dir for repo: %w", err)
+		return nil, classify(errorClassFilesystem, fmt.Errorf("create temp dir for repo: %w", err))
 	}
 	repo := &repository{
 		gh:          p.Github,
@@ -58,7 +69,7 @@ func NewRepository(ctx context.Context, p NewRepositoryParams) (*repository, err
 		tmpRepoPath: tmpRepoPath,
 	}
 	if err := repo.gitClone(); err != nil {
-		return nil, fmt.Errorf("git clone repo: %w", err)
+		return nil, classify(errorClassGit, fmt.Errorf("git clone repo: %w", err))
 	}
 	return repo, nil
 }
@@ -77,7 +88,8 @@ func (r repository) Close(ctx context.Context) []error {
 	return errs
 }
 
-func (r repository) localPath() string {
+// LocalPath returns the path of the local clone.
+func (r repository) LocalPath() string {
 	return r.tmpRepoPath
 }
 
@@ -87,12 +99,72 @@ func (r repository) gitClone() error {
 	return err
 }
 
-func (r repository) workingDirectoryClean() (bool, error) {
-	status, err := r.git("status")
+// fileChange is a changed file of the working directory.
+type fileChange struct {
+	// Slash separated path of the file relative to the repository root.
+	path string
+	// Status of the change.
+	status fileStatus
+}
+
+// fileStatus is the status of a changed file.
+type fileStatus string
+
+// Possible statuses of a changed file.
+const (
+	fileAdded    fileStatus = "added"
+	fileModified fileStatus = "modified"
+	fileDeleted  fileStatus = "deleted"
+)
+
+func (r repository) changes() ([]fileChange, error) {
+	// Entries are NUL separated and are never quoted that way.
+	status, err := r.git("status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(status), nil
+}
+
+// parseStatus parses output of git status --porcelain -z.
+func parseStatus(status string) []fileChange {
+	var changes []fileChange
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		xy, path := entry[:2], entry[3:]
+		var s fileStatus
+		switch {
+		case strings.ContainsAny(xy, "?A"):
+			s = fileAdded
+		case strings.Contains(xy, "D"):
+			s = fileDeleted
+		default:
+			s = fileModified
+		}
+		// Renamed and copied entries are followed by their original path.
+		if strings.ContainsAny(xy, "RC") {
+			i++
+		}
+		changes = append(changes, fileChange{path: path, status: s})
+	}
+	return changes
+}
+
+func (r repository) diff() (string, error) {
+	// Untracked files are added with intent only, so they are in the diff.
+	if _, err := r.git("add", "--all", "--intent-to-add"); err != nil {
+		return "", err
+	}
+	// Warnings of git (on stderr) aren't part of the diff.
+	diff, err := r.gitStdout("diff", "HEAD")
 	if err != nil {
-		return false, err
+		return "", err
 	}
-	return strings.Contains(status, "nothing to commit, working tree clean"), nil
+	return string(diff), nil
 }
 
 func (r repository) gitCheckoutNewBranch() error {
@@ -131,19 +203,130 @@ func (r repository) currentBranch() (string, error) {
 	return strings.TrimSpace(branch), nil
 }
 
+func (r repository) headCommit() (string, error) {
+	sha, err := r.git("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sha), nil
+}
+
+// stepCommits returns commits made by the step to a deploy folder
+// (newest first). They are recognised by their trailers.
+func (r repository) stepCommits(folder string) ([]stepCommit, error) {
+	out, err := r.gitStdout("log", "--format=%H%x1f%P%x1f%(trailers:unfold,only)%x1e", "--", folder)
+	if err != nil {
+		return nil, err
+	}
+	return parseStepCommits(string(out), folder), nil
+}
+
+// restoreFolder restores a folder of the working directory to it's state
+// at a given revision (files added since then are deleted). An empty
+// revision restores an empty folder. The history ledger of the folder isn't
+// restored. It returns slash separated paths of all restored files.
+func (r repository) restoreFolder(revision, folder string) ([]string, error) {
+	ledger := path.Join(folder, historyFolder, historyFile)
+	ledgerContent, ledgerErr := ioutil.ReadFile(filepath.Join(r.tmpRepoPath, filepath.FromSlash(ledger)))
+
+	if _, err := r.git("rm", "-r", "-q", "--ignore-unmatch", "--", folder); err != nil {
+		return nil, fmt.Errorf("remove folder: %w", err)
+	}
+	var files []byte
+	if revision != "" {
+		var err error
+		files, err = r.gitStdout("ls-tree", "-r", "--name-only", "-z", revision, "--", folder)
+		if err != nil {
+			return nil, fmt.Errorf("files at %s: %w", revision, err)
+		}
+	}
+	if len(files) > 0 {
+		if _, err := r.git("checkout", revision, "--", folder); err != nil {
+			return nil, fmt.Errorf("checkout folder: %w", err)
+		}
+	}
+
+	// History is kept (the rollback is appended to it).
+	if ledgerErr == nil {
+		ledgerPath := filepath.Join(r.tmpRepoPath, filepath.FromSlash(ledger))
+		if err := os.MkdirAll(filepath.Dir(ledgerPath), 0755); err != nil {
+			return nil, fmt.Errorf("create history folder: %w", err)
+		}
+		if err := ioutil.WriteFile(ledgerPath, ledgerContent, 0644); err != nil {
+			return nil, fmt.Errorf("write history: %w", err)
+		}
+	}
+
+	var restored []string
+	for _, file := range strings.Split(string(files), "\x00") {
+		if file != "" && file != ledger {
+			restored = append(restored, file)
+		}
+	}
+	return restored, nil
+}
+
+// committedFile returns the content of a file (slash separated path) at the
+// head commit. It returns false if the file isn't committed.
+func (r repository) committedFile(file string) ([]byte, bool, error) {
+	// Warnings of git (on stderr) aren't part of the output.
+	files, err := r.gitStdout("ls-tree", "--name-only", "HEAD", "--", file)
+	if err != nil {
+		return nil, false, err
+	}
+	if strings.TrimSpace(string(files)) == "" {
+		return nil, false, nil
+	}
+	content, err := r.gitStdout("show", "HEAD:"+file)
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+// lastCommitTime returns the time of the last commit changing a path
+// (the zero time if it was never committed).
+func (r repository) lastCommitTime(path string) (time.Time, error) {
+	out, err := r.gitStdout("log", "-1", "--format=%ct", "--", path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if strings.TrimSpace(string(out)) == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse commit time: %w", err)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
 func (r repository) git(args ...string) (string, error) {
-	// Change current directory to the repositorys local clone.
-	originalDir, err := os.Getwd()
+	// Run git command and returns it's combined output of stdout and stderr.
+	out, err := r.gitCommand(args...).CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("get current dir: %w", err)
+		return "", fmt.Errorf("run command %v: %w (output: %s)", args, err, out)
 	}
-	if err := os.Chdir(r.tmpRepoPath); err != nil {
-		return "", fmt.Errorf("change dir to %q: %w", r.tmpRepoPath, err)
+	return string(out), nil
+}
+
+// gitStdout runs a git command and returns it's stdout only (e.g. contents
+// of files), stderr is part of the error.
+func (r repository) gitStdout(args ...string) ([]byte, error) {
+	cmd := r.gitCommand(args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("run command %v: %w (output: %s)", args, err, stderr.String())
 	}
-	// Defer a revert of the current directory to the original one.
-	defer os.Chdir(originalDir)
+	return out, nil
+}
 
+// gitCommand returns a git command run in the repository's local clone.
+func (r repository) gitCommand(args ...string) *exec.Cmd {
 	cmd := exec.Command("git", args...)
+	cmd.Dir = r.tmpRepoPath
 
 	// Specify SSH key for git commands via environment variable.
 	cmd.Env = os.Environ()
@@ -151,30 +334,24 @@ func (r repository) git(args ...string) (string, error) {
 		"GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes",
 		r.sshKey.privateKeyPath(),
 	))
-
-	// Run git command and returns it's combined output of stdout and stderr.
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("run command %v: %w (output: %s)", args, err, out)
-	}
-	return string(out), nil
+	return cmd
 }
 
-func (r repository) openPullRequest(ctx context.Context, title, body string) (string, error) {
+func (r repository) openPullRequest(ctx context.Context, title, body string) (pullRequest, error) {
 	// PR will be open from the current branch.
 	currBranch, err := r.currentBranch()
 	if err != nil {
-		return "", fmt.Errorf("current branch: %w", err)
+		return pullRequest{}, fmt.Errorf("current branch: %w", err)
 	}
 	// Open pull request from current branch to the base branch.
-	url, err := r.gh.OpenPullRequest(ctx, openPullRequestParams{
+	pr, err := r.gh.OpenPullRequest(ctx, openPullRequestParams{
 		title: title,
 		body:  body,
 		head:  currBranch,
 		base:  r.remote.Branch,
 	})
 	if err != nil {
-		return "", fmt.Errorf("call github: %w", err)
+		return pullRequest{}, fmt.Errorf("call github: %w", err)
 	}
-	return url, nil
+	return pr, nil
 }
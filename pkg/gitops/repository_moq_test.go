@@ -6,6 +6,7 @@ package gitops
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // Ensure, that repositorierMock does implement repositorier.
@@ -21,6 +22,21 @@ var _ repositorier = &repositorierMock{}
 //             CloseFunc: func(ctx context.Context) []error {
 // 	               panic("mock out the Close method")
 //             },
+//             LocalPathFunc: func() string {
+// 	               panic("mock out the LocalPath method")
+//             },
+//             changesFunc: func() ([]fileChange, error) {
+// 	               panic("mock out the changes method")
+//             },
+//             committedFileFunc: func(file string) ([]byte, bool, error) {
+// 	               panic("mock out the committedFile method")
+//             },
+//             currentBranchFunc: func() (string, error) {
+// 	               panic("mock out the currentBranch method")
+//             },
+//             diffFunc: func() (string, error) {
+// 	               panic("mock out the diff method")
+//             },
 //             gitCheckoutNewBranchFunc: func() error {
 // 	               panic("mock out the gitCheckoutNewBranch method")
 //             },
@@ -30,14 +46,20 @@ var _ repositorier = &repositorierMock{}
 //             gitCommitAndPushFunc: func(message string) error {
 // 	               panic("mock out the gitCommitAndPush method")
 //             },
-//             localPathFunc: func() string {
-// 	               panic("mock out the localPath method")
+//             headCommitFunc: func() (string, error) {
+// 	               panic("mock out the headCommit method")
+//             },
+//             lastCommitTimeFunc: func(path string) (time.Time, error) {
+// 	               panic("mock out the lastCommitTime method")
 //             },
-//             openPullRequestFunc: func(ctx context.Context, title string, body string) (string, error) {
+//             openPullRequestFunc: func(ctx context.Context, title string, body string) (pullRequest, error) {
 // 	               panic("mock out the openPullRequest method")
 //             },
-//             workingDirectoryCleanFunc: func() (bool, error) {
-// 	               panic("mock out the workingDirectoryClean method")
+//             restoreFolderFunc: func(revision string, folder string) ([]string, error) {
+// 	               panic("mock out the restoreFolder method")
+//             },
+//             stepCommitsFunc: func(folder string) ([]stepCommit, error) {
+// 	               panic("mock out the stepCommits method")
 //             },
 //         }
 //
@@ -49,6 +71,21 @@ type repositorierMock struct {
 	// CloseFunc mocks the Close method.
 	CloseFunc func(ctx context.Context) []error
 
+	// LocalPathFunc mocks the LocalPath method.
+	LocalPathFunc func() string
+
+	// changesFunc mocks the changes method.
+	changesFunc func() ([]fileChange, error)
+
+	// committedFileFunc mocks the committedFile method.
+	committedFileFunc func(file string) ([]byte, bool, error)
+
+	// currentBranchFunc mocks the currentBranch method.
+	currentBranchFunc func() (string, error)
+
+	// diffFunc mocks the diff method.
+	diffFunc func() (string, error)
+
 	// gitCheckoutNewBranchFunc mocks the gitCheckoutNewBranch method.
 	gitCheckoutNewBranchFunc func() error
 
@@ -58,14 +95,20 @@ type repositorierMock struct {
 	// gitCommitAndPushFunc mocks the gitCommitAndPush method.
 	gitCommitAndPushFunc func(message string) error
 
-	// localPathFunc mocks the localPath method.
-	localPathFunc func() string
+	// headCommitFunc mocks the headCommit method.
+	headCommitFunc func() (string, error)
+
+	// lastCommitTimeFunc mocks the lastCommitTime method.
+	lastCommitTimeFunc func(path string) (time.Time, error)
 
 	// openPullRequestFunc mocks the openPullRequest method.
-	openPullRequestFunc func(ctx context.Context, title string, body string) (string, error)
+	openPullRequestFunc func(ctx context.Context, title string, body string) (pullRequest, error)
+
+	// restoreFolderFunc mocks the restoreFolder method.
+	restoreFolderFunc func(revision string, folder string) ([]string, error)
 
-	// workingDirectoryCleanFunc mocks the workingDirectoryClean method.
-	workingDirectoryCleanFunc func() (bool, error)
+	// stepCommitsFunc mocks the stepCommits method.
+	stepCommitsFunc func(folder string) ([]stepCommit, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -74,6 +117,23 @@ type repositorierMock struct {
 			// Ctx is the ctx argument value.
 			Ctx context.Context
 		}
+		// LocalPath holds details about calls to the LocalPath method.
+		LocalPath []struct {
+		}
+		// changes holds details about calls to the changes method.
+		changes []struct {
+		}
+		// committedFile holds details about calls to the committedFile method.
+		committedFile []struct {
+			// File is the file argument value.
+			File string
+		}
+		// currentBranch holds details about calls to the currentBranch method.
+		currentBranch []struct {
+		}
+		// diff holds details about calls to the diff method.
+		diff []struct {
+		}
 		// gitCheckoutNewBranch holds details about calls to the gitCheckoutNewBranch method.
 		gitCheckoutNewBranch []struct {
 		}
@@ -85,8 +145,13 @@ type repositorierMock struct {
 			// Message is the message argument value.
 			Message string
 		}
-		// localPath holds details about calls to the localPath method.
-		localPath []struct {
+		// headCommit holds details about calls to the headCommit method.
+		headCommit []struct {
+		}
+		// lastCommitTime holds details about calls to the lastCommitTime method.
+		lastCommitTime []struct {
+			// Path is the path argument value.
+			Path string
 		}
 		// openPullRequest holds details about calls to the openPullRequest method.
 		openPullRequest []struct {
@@ -97,17 +162,33 @@ type repositorierMock struct {
 			// Body is the body argument value.
 			Body string
 		}
-		// workingDirectoryClean holds details about calls to the workingDirectoryClean method.
-		workingDirectoryClean []struct {
+		// restoreFolder holds details about calls to the restoreFolder method.
+		restoreFolder []struct {
+			// Revision is the revision argument value.
+			Revision string
+			// Folder is the folder argument value.
+			Folder string
+		}
+		// stepCommits holds details about calls to the stepCommits method.
+		stepCommits []struct {
+			// Folder is the folder argument value.
+			Folder string
 		}
 	}
-	lockClose                 sync.RWMutex
-	lockgitCheckoutNewBranch  sync.RWMutex
-	lockgitClone              sync.RWMutex
-	lockgitCommitAndPush      sync.RWMutex
-	locklocalPath             sync.RWMutex
-	lockopenPullRequest       sync.RWMutex
-	lockworkingDirectoryClean sync.RWMutex
+	lockClose                sync.RWMutex
+	lockLocalPath            sync.RWMutex
+	lockchanges              sync.RWMutex
+	lockcommittedFile        sync.RWMutex
+	lockcurrentBranch        sync.RWMutex
+	lockdiff                 sync.RWMutex
+	lockgitCheckoutNewBranch sync.RWMutex
+	lockgitClone             sync.RWMutex
+	lockgitCommitAndPush     sync.RWMutex
+	lockheadCommit           sync.RWMutex
+	locklastCommitTime       sync.RWMutex
+	lockopenPullRequest      sync.RWMutex
+	lockrestoreFolder        sync.RWMutex
+	lockstepCommits          sync.RWMutex
 }
 
 // Close calls CloseFunc.
@@ -141,6 +222,141 @@ func (mock *repositorierMock) CloseCalls() []struct {
 	return calls
 }
 
+// LocalPath calls LocalPathFunc.
+func (mock *repositorierMock) LocalPath() string {
+	if mock.LocalPathFunc == nil {
+		panic("repositorierMock.LocalPathFunc: method is nil but repositorier.LocalPath was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockLocalPath.Lock()
+	mock.calls.LocalPath = append(mock.calls.LocalPath, callInfo)
+	mock.lockLocalPath.Unlock()
+	return mock.LocalPathFunc()
+}
+
+// LocalPathCalls gets all the calls that were made to LocalPath.
+// Check the length with:
+//     len(mockedrepositorier.LocalPathCalls())
+func (mock *repositorierMock) LocalPathCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockLocalPath.RLock()
+	calls = mock.calls.LocalPath
+	mock.lockLocalPath.RUnlock()
+	return calls
+}
+
+// changes calls changesFunc.
+func (mock *repositorierMock) changes() ([]fileChange, error) {
+	if mock.changesFunc == nil {
+		panic("repositorierMock.changesFunc: method is nil but repositorier.changes was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockchanges.Lock()
+	mock.calls.changes = append(mock.calls.changes, callInfo)
+	mock.lockchanges.Unlock()
+	return mock.changesFunc()
+}
+
+// changesCalls gets all the calls that were made to changes.
+// Check the length with:
+//     len(mockedrepositorier.changesCalls())
+func (mock *repositorierMock) changesCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockchanges.RLock()
+	calls = mock.calls.changes
+	mock.lockchanges.RUnlock()
+	return calls
+}
+
+// committedFile calls committedFileFunc.
+func (mock *repositorierMock) committedFile(file string) ([]byte, bool, error) {
+	if mock.committedFileFunc == nil {
+		panic("repositorierMock.committedFileFunc: method is nil but repositorier.committedFile was just called")
+	}
+	callInfo := struct {
+		File string
+	}{
+		File: file,
+	}
+	mock.lockcommittedFile.Lock()
+	mock.calls.committedFile = append(mock.calls.committedFile, callInfo)
+	mock.lockcommittedFile.Unlock()
+	return mock.committedFileFunc(file)
+}
+
+// committedFileCalls gets all the calls that were made to committedFile.
+// Check the length with:
+//     len(mockedrepositorier.committedFileCalls())
+func (mock *repositorierMock) committedFileCalls() []struct {
+	File string
+} {
+	var calls []struct {
+		File string
+	}
+	mock.lockcommittedFile.RLock()
+	calls = mock.calls.committedFile
+	mock.lockcommittedFile.RUnlock()
+	return calls
+}
+
+// currentBranch calls currentBranchFunc.
+func (mock *repositorierMock) currentBranch() (string, error) {
+	if mock.currentBranchFunc == nil {
+		panic("repositorierMock.currentBranchFunc: method is nil but repositorier.currentBranch was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockcurrentBranch.Lock()
+	mock.calls.currentBranch = append(mock.calls.currentBranch, callInfo)
+	mock.lockcurrentBranch.Unlock()
+	return mock.currentBranchFunc()
+}
+
+// currentBranchCalls gets all the calls that were made to currentBranch.
+// Check the length with:
+//     len(mockedrepositorier.currentBranchCalls())
+func (mock *repositorierMock) currentBranchCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockcurrentBranch.RLock()
+	calls = mock.calls.currentBranch
+	mock.lockcurrentBranch.RUnlock()
+	return calls
+}
+
+// diff calls diffFunc.
+func (mock *repositorierMock) diff() (string, error) {
+	if mock.diffFunc == nil {
+		panic("repositorierMock.diffFunc: method is nil but repositorier.diff was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockdiff.Lock()
+	mock.calls.diff = append(mock.calls.diff, callInfo)
+	mock.lockdiff.Unlock()
+	return mock.diffFunc()
+}
+
+// diffCalls gets all the calls that were made to diff.
+// Check the length with:
+//     len(mockedrepositorier.diffCalls())
+func (mock *repositorierMock) diffCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockdiff.RLock()
+	calls = mock.calls.diff
+	mock.lockdiff.RUnlock()
+	return calls
+}
+
 // gitCheckoutNewBranch calls gitCheckoutNewBranchFunc.
 func (mock *repositorierMock) gitCheckoutNewBranch() error {
 	if mock.gitCheckoutNewBranchFunc == nil {
@@ -224,34 +440,65 @@ func (mock *repositorierMock) gitCommitAndPushCalls() []struct {
 	return calls
 }
 
-// localPath calls localPathFunc.
-func (mock *repositorierMock) localPath() string {
-	if mock.localPathFunc == nil {
-		panic("repositorierMock.localPathFunc: method is nil but repositorier.localPath was just called")
+// headCommit calls headCommitFunc.
+func (mock *repositorierMock) headCommit() (string, error) {
+	if mock.headCommitFunc == nil {
+		panic("repositorierMock.headCommitFunc: method is nil but repositorier.headCommit was just called")
 	}
 	callInfo := struct {
 	}{}
-	mock.locklocalPath.Lock()
-	mock.calls.localPath = append(mock.calls.localPath, callInfo)
-	mock.locklocalPath.Unlock()
-	return mock.localPathFunc()
+	mock.lockheadCommit.Lock()
+	mock.calls.headCommit = append(mock.calls.headCommit, callInfo)
+	mock.lockheadCommit.Unlock()
+	return mock.headCommitFunc()
 }
 
-// localPathCalls gets all the calls that were made to localPath.
+// headCommitCalls gets all the calls that were made to headCommit.
 // Check the length with:
-//     len(mockedrepositorier.localPathCalls())
-func (mock *repositorierMock) localPathCalls() []struct {
+//     len(mockedrepositorier.headCommitCalls())
+func (mock *repositorierMock) headCommitCalls() []struct {
 } {
 	var calls []struct {
 	}
-	mock.locklocalPath.RLock()
-	calls = mock.calls.localPath
-	mock.locklocalPath.RUnlock()
+	mock.lockheadCommit.RLock()
+	calls = mock.calls.headCommit
+	mock.lockheadCommit.RUnlock()
+	return calls
+}
+
+// lastCommitTime calls lastCommitTimeFunc.
+func (mock *repositorierMock) lastCommitTime(path string) (time.Time, error) {
+	if mock.lastCommitTimeFunc == nil {
+		panic("repositorierMock.lastCommitTimeFunc: method is nil but repositorier.lastCommitTime was just called")
+	}
+	callInfo := struct {
+		Path string
+	}{
+		Path: path,
+	}
+	mock.locklastCommitTime.Lock()
+	mock.calls.lastCommitTime = append(mock.calls.lastCommitTime, callInfo)
+	mock.locklastCommitTime.Unlock()
+	return mock.lastCommitTimeFunc(path)
+}
+
+// lastCommitTimeCalls gets all the calls that were made to lastCommitTime.
+// Check the length with:
+//     len(mockedrepositorier.lastCommitTimeCalls())
+func (mock *repositorierMock) lastCommitTimeCalls() []struct {
+	Path string
+} {
+	var calls []struct {
+		Path string
+	}
+	mock.locklastCommitTime.RLock()
+	calls = mock.calls.lastCommitTime
+	mock.locklastCommitTime.RUnlock()
 	return calls
 }
 
 // openPullRequest calls openPullRequestFunc.
-func (mock *repositorierMock) openPullRequest(ctx context.Context, title string, body string) (string, error) {
+func (mock *repositorierMock) openPullRequest(ctx context.Context, title string, body string) (pullRequest, error) {
 	if mock.openPullRequestFunc == nil {
 		panic("repositorierMock.openPullRequestFunc: method is nil but repositorier.openPullRequest was just called")
 	}
@@ -289,28 +536,68 @@ func (mock *repositorierMock) openPullRequestCalls() []struct {
 	return calls
 }
 
-// workingDirectoryClean calls workingDirectoryCleanFunc.
-func (mock *repositorierMock) workingDirectoryClean() (bool, error) {
-	if mock.workingDirectoryCleanFunc == nil {
-		panic("repositorierMock.workingDirectoryCleanFunc: method is nil but repositorier.workingDirectoryClean was just called")
+// restoreFolder calls restoreFolderFunc.
+func (mock *repositorierMock) restoreFolder(revision string, folder string) ([]string, error) {
+	if mock.restoreFolderFunc == nil {
+		panic("repositorierMock.restoreFolderFunc: method is nil but repositorier.restoreFolder was just called")
 	}
 	callInfo := struct {
-	}{}
-	mock.lockworkingDirectoryClean.Lock()
-	mock.calls.workingDirectoryClean = append(mock.calls.workingDirectoryClean, callInfo)
-	mock.lockworkingDirectoryClean.Unlock()
-	return mock.workingDirectoryCleanFunc()
+		Revision string
+		Folder   string
+	}{
+		Revision: revision,
+		Folder:   folder,
+	}
+	mock.lockrestoreFolder.Lock()
+	mock.calls.restoreFolder = append(mock.calls.restoreFolder, callInfo)
+	mock.lockrestoreFolder.Unlock()
+	return mock.restoreFolderFunc(revision, folder)
+}
+
+// restoreFolderCalls gets all the calls that were made to restoreFolder.
+// Check the length with:
+//     len(mockedrepositorier.restoreFolderCalls())
+func (mock *repositorierMock) restoreFolderCalls() []struct {
+	Revision string
+	Folder   string
+} {
+	var calls []struct {
+		Revision string
+		Folder   string
+	}
+	mock.lockrestoreFolder.RLock()
+	calls = mock.calls.restoreFolder
+	mock.lockrestoreFolder.RUnlock()
+	return calls
+}
+
+// stepCommits calls stepCommitsFunc.
+func (mock *repositorierMock) stepCommits(folder string) ([]stepCommit, error) {
+	if mock.stepCommitsFunc == nil {
+		panic("repositorierMock.stepCommitsFunc: method is nil but repositorier.stepCommits was just called")
+	}
+	callInfo := struct {
+		Folder string
+	}{
+		Folder: folder,
+	}
+	mock.lockstepCommits.Lock()
+	mock.calls.stepCommits = append(mock.calls.stepCommits, callInfo)
+	mock.lockstepCommits.Unlock()
+	return mock.stepCommitsFunc(folder)
 }
 
-// workingDirectoryCleanCalls gets all the calls that were made to workingDirectoryClean.
+// stepCommitsCalls gets all the calls that were made to stepCommits.
 // Check the length with:
-//     len(mockedrepositorier.workingDirectoryCleanCalls())
-func (mock *repositorierMock) workingDirectoryCleanCalls() []struct {
+//     len(mockedrepositorier.stepCommitsCalls())
+func (mock *repositorierMock) stepCommitsCalls() []struct {
+	Folder string
 } {
 	var calls []struct {
+		Folder string
 	}
-	mock.lockworkingDirectoryClean.RLock()
-	calls = mock.calls.workingDirectoryClean
-	mock.lockworkingDirectoryClean.RUnlock()
+	mock.lockstepCommits.RLock()
+	calls = mock.calls.stepCommits
+	mock.lockstepCommits.RUnlock()
 	return calls
 }
@@ -36,13 +36,16 @@ func TestRepository(t *testing.T) {
 			defer close()
 
 			// Initialize mock Github client.
-			wantPullRequestURL := fmt.Sprintf("https://%s/pr/15", tc.repoURL)
+			wantPullRequest := pullRequest{
+				url:    fmt.Sprintf("https://%s/pr/15", tc.repoURL),
+				number: 15,
+			}
 			var gotHead, gotBase string
 			gh := &githuberMock{
-				OpenPullRequestFunc: func(_ context.Context, p openPullRequestParams) (string, error) {
+				OpenPullRequestFunc: func(_ context.Context, p openPullRequestParams) (pullRequest, error) {
 					gotHead = p.head
 					gotBase = p.base
-					return wantPullRequestURL, nil
+					return wantPullRequest, nil
 				},
 			}
 
@@ -70,35 +73,50 @@ func TestRepository(t *testing.T) {
 			require.NoError(t, err, "newRepository")
 
 			// The repository is clean if there weren't any changes.
-			clean, err := repo.workingDirectoryClean()
-			require.True(t, clean, "working directory is clean without changes")
+			changes, err := repo.changes()
+			require.NoError(t, err, "changes")
+			require.Empty(t, changes, "working directory is clean without changes")
 
 			// It's dirty after making some changes.
-			changePath := path.Join(repo.localPath(), "empty.go")
+			changePath := path.Join(repo.LocalPath(), "empty.go")
 			write(t, changePath, "package empty")
 
-			clean, err = repo.workingDirectoryClean()
-			require.False(t, clean, "working directory is dirty after changes")
+			changes, err = repo.changes()
+			require.NoError(t, err, "changes")
+			assert.Equal(t, []fileChange{
+				{path: "empty.go", status: fileAdded},
+			}, changes, "changes of working directory")
+
+			diff, err := repo.diff()
+			require.NoError(t, err, "diff")
+			assert.Contains(t, diff, "+++ b/empty.go", "diff of new file")
+			assert.Contains(t, diff, "+package empty", "diff of new file")
 
 			// Commit and push changes to upstream repository.
 			err = repo.gitCommitAndPush("test commit")
 			require.NoError(t, err, "commit and push test")
 
-			clean, err = repo.workingDirectoryClean()
-			require.True(t, clean, "working directory is clean after commit")
+			changes, err = repo.changes()
+			require.NoError(t, err, "changes")
+			require.Empty(t, changes, "working directory is clean after commit")
 
 			// Can create a new branch and push it to upstream as well,
 			// open new pull request from it to the base branch.
 			require.NoError(t, repo.gitCheckoutNewBranch(), "new branch")
-			changePath = path.Join(repo.localPath(), "another.go")
+			changePath = path.Join(repo.LocalPath(), "another.go")
 			write(t, changePath, "package another")
 
 			err = repo.gitCommitAndPush("another commit")
 			require.NoError(t, err, "commit and push another")
 
-			gotPullRequestURL, err := repo.openPullRequest(ctx, "", "")
+			// Head commit is the pushed one.
+			gotHeadCommit, err := repo.headCommit()
+			require.NoError(t, err, "head commit")
+			assert.Regexp(t, "^[0-9a-f]{40}$", gotHeadCommit, "head commit sha")
+
+			gotPullRequest, err := repo.openPullRequest(ctx, "", "")
 			require.NoError(t, err, "open pull request")
-			assert.Equal(t, wantPullRequestURL, gotPullRequestURL, "pr url")
+			assert.Equal(t, wantPullRequest, gotPullRequest, "pull request")
 
 			assert.Equal(t, tc.upstreamBranch, gotBase, "pr base")
 
@@ -115,6 +133,28 @@ func TestRepository(t *testing.T) {
 	}
 }
 
+// localClone returns a local clone of a new local upstream repository.
+func localClone(t *testing.T) (*repository, func()) {
+	ctx := context.Background()
+	upstreamPath, closeUpstream := localUpstreamRepo(t, "main")
+	repo, err := NewRepository(ctx, NewRepositoryParams{
+		SSHKey: &sshKeyerMock{
+			privateKeyPathFunc: func() string {
+				return ""
+			},
+			closeFunc: func(context.Context) []error {
+				return nil
+			},
+		},
+		Remote: RemoteConfig{URL: upstreamPath, Branch: "main"},
+	})
+	require.NoError(t, err, "NewRepository")
+	return repo, func() {
+		repo.Close(ctx)
+		closeUpstream()
+	}
+}
+
 func localUpstreamRepo(t *testing.T, branch string) (string, func()) {
 	repoPath, err := ioutil.TempDir("", "")
 	require.NoError(t, err, "new temp directory for local upstream")
@@ -150,3 +190,67 @@ func git(t *testing.T, repoPath string, args ...string) {
 	cmd := exec.Command("git", args...)
 	require.NoError(t, cmd.Run(), "git %+v", args)
 }
+
+func TestCommittedFile(t *testing.T) {
+	repo, close := localClone(t)
+	defer close()
+
+	// Traces of git are written to stderr, they aren't part of the content.
+	os.Setenv("GIT_TRACE", "1")
+	defer os.Unsetenv("GIT_TRACE")
+	got, ok, err := repo.committedFile("README.md")
+	require.NoError(t, err, "committedFile")
+	assert.True(t, ok, "README.md is committed")
+	assert.Equal(t, "A local upstream repository for testing.", string(got))
+
+	_, ok, err = repo.committedFile("missing.yaml")
+	require.NoError(t, err, "committedFile of missing file")
+	assert.False(t, ok, "missing.yaml isn't committed")
+}
+
+func TestDiff(t *testing.T) {
+	repo, close := localClone(t)
+	defer close()
+	write(t, path.Join(repo.LocalPath(), "values.yaml"), "tag: a\n")
+
+	// Traces of git are written to stderr, they aren't part of the diff.
+	os.Setenv("GIT_TRACE", "1")
+	defer os.Unsetenv("GIT_TRACE")
+	diff, err := repo.diff()
+	require.NoError(t, err, "diff")
+	assert.Regexp(t, "^diff --git a/values.yaml b/values.yaml\n", diff, "diff starts with the header")
+	assert.NotContains(t, diff, "trace:", "diff doesn't contain traces")
+}
+
+func TestRestoreEmptyFolder(t *testing.T) {
+	repo, close := localClone(t)
+	defer close()
+	prodPath := path.Join(repo.LocalPath(), "prod")
+	require.NoError(t, os.MkdirAll(path.Join(prodPath, historyFolder), 0700))
+	write(t, path.Join(prodPath, "values.yaml"), "tag: 1\n")
+	write(t, path.Join(prodPath, historyFolder, historyFile), "1\n")
+	require.NoError(t, repo.gitCommitAndPush("deploy"), "deploy")
+
+	// Only the history ledger is kept.
+	restored, err := repo.restoreFolder("", "prod")
+	require.NoError(t, err, "restoreFolder")
+	assert.Empty(t, restored, "restored files")
+	_, err = os.Stat(path.Join(prodPath, "values.yaml"))
+	assert.True(t, os.IsNotExist(err), "values.yaml is removed")
+	history, err := ioutil.ReadFile(path.Join(prodPath, historyFolder, historyFile))
+	require.NoError(t, err, "read history")
+	assert.Equal(t, "1\n", string(history), "history")
+}
+
+func TestParseStatus(t *testing.T) {
+	status := "?? new.yaml\x00 M values.yaml\x00 D old.yaml\x00" +
+		"R  renamed.yaml\x00original.yaml\x00A  dir/staged.yaml\x00"
+	want := []fileChange{
+		{path: "new.yaml", status: fileAdded},
+		{path: "values.yaml", status: fileModified},
+		{path: "old.yaml", status: fileDeleted},
+		{path: "renamed.yaml", status: fileModified},
+		{path: "dir/staged.yaml", status: fileAdded},
+	}
+	assert.Equal(t, want, parseStatus(status))
+}
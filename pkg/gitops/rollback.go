@@ -0,0 +1,218 @@
+package gitops
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Trailer keys of commits made by the step.
+const (
+	trailerDeployFolder = "Gitops-Deploy-Folder"
+	trailerSourceCommit = "Gitops-Source-Commit"
+	trailerBuildNumber  = "Gitops-Build-Number"
+	trailerBuildURL     = "Gitops-Build-URL"
+	trailerRollback     = "Gitops-Rollback"
+	trailerPromotedFrom = "Gitops-Promoted-From"
+)
+
+// Trailer is a git trailer (`Key: value` line at the end of a commit message).
+type Trailer struct {
+	Key, Value string
+}
+
+// CommitTrailers returns trailers recording the deploy folders and the build
+// of a commit made by the step (so it can be rolled back later).
+func CommitTrailers(folders []string, build BuildInfo) []Trailer {
+	var trailers []Trailer
+	for _, folder := range folders {
+		trailers = append(trailers, Trailer{trailerDeployFolder, filepath.ToSlash(filepath.Clean(folder))})
+	}
+	if build.SourceCommit != "" {
+		trailers = append(trailers, Trailer{trailerSourceCommit, build.SourceCommit})
+	}
+	if build.BuildNumber != "" {
+		trailers = append(trailers, Trailer{trailerBuildNumber, build.BuildNumber})
+	}
+	if build.BuildURL != "" {
+		trailers = append(trailers, Trailer{trailerBuildURL, build.BuildURL})
+	}
+	return trailers
+}
+
+// withTrailers appends trailers to a commit message (as it's last paragraph).
+func withTrailers(message string, trailers []Trailer) string {
+	if len(trailers) == 0 {
+		return message
+	}
+	lines := make([]string, 0, len(trailers))
+	for _, t := range trailers {
+		lines = append(lines, fmt.Sprintf("%s: %s", t.Key, t.Value))
+	}
+	return appendParagraph(strings.TrimRight(message, "\n"), strings.Join(lines, "\n"))
+}
+
+// stepCommit is a commit made by the step.
+type stepCommit struct {
+	sha string
+	// root tells whether the commit has no parents (it's the first one).
+	root     bool
+	trailers map[string][]string
+}
+
+// isRollback tells whether the commit rolled back an earlier one.
+func (c stepCommit) isRollback() bool {
+	return len(c.trailers[trailerRollback]) > 0
+}
+
+// has tells whether the commit has a trailer with a given value.
+func (c stepCommit) has(key, value string) bool {
+	for _, v := range c.trailers[key] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStepCommits parses `git log` output of records separated by RS,
+// each of them is a SHA, the parent SHAs and trailers separated by US. Only
+// commits with a trailer of the given deploy folder are returned.
+func parseStepCommits(log, folder string) []stepCommit {
+	var commits []stepCommit
+	for _, record := range strings.Split(log, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		c := stepCommit{
+			sha:      fields[0],
+			root:     strings.TrimSpace(fields[1]) == "",
+			trailers: map[string][]string{},
+		}
+		for _, line := range strings.Split(fields[2], "\n") {
+			kv := strings.SplitN(line, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key := strings.TrimSpace(kv[0])
+			c.trailers[key] = append(c.trailers[key], strings.TrimSpace(kv[1]))
+		}
+		if c.has(trailerDeployFolder, folder) {
+			commits = append(commits, c)
+		}
+	}
+	return commits
+}
+
+// rollbackPrevious is the value of the rollback trailer of commits which
+// undid the most recent deployment.
+const rollbackPrevious = "previous deployment"
+
+// RollbackTrailer returns the trailer marking a rollback commit.
+func RollbackTrailer(toSourceCommit, toBuildNumber string) Trailer {
+	switch {
+	case toSourceCommit != "":
+		return Trailer{trailerRollback, "source commit " + toSourceCommit}
+	case toBuildNumber != "":
+		return Trailer{trailerRollback, "build " + toBuildNumber}
+	}
+	return Trailer{trailerRollback, rollbackPrevious}
+}
+
+// RollbackRenderer restores deploy folders of the local clone to an earlier
+// state deployed by the step (instead of rendering templates), so the
+// rollback is pushed (or opened as a pull request) like any other change.
+type RollbackRenderer struct {
+	// Repo is local clone of remote repository.
+	Repo repositorier
+	// Folders are the deploy folders to roll back.
+	Folders []string
+	// ToSourceCommit rolls back to the deployment of a source commit.
+	ToSourceCommit string
+	// ToBuildNumber rolls back to the deployment of a build.
+	ToBuildNumber string
+}
+
+// RollbackRenderer implements the renderAllFileser interface.
+var _ renderAllFileser = (*RollbackRenderer)(nil)
+
+// renderAllFiles restores every deploy folder and returns slash separated
+// paths of the restored files. Without a target, the most recent deployment
+// of the step which isn't a rollback itself (and wasn't undone by a
+// rollback yet) is undone. The history ledger isn't restored.
+func (rr RollbackRenderer) renderAllFiles() ([]string, error) {
+	var restored []string
+	for _, folder := range rr.Folders {
+		folder = filepath.ToSlash(filepath.Clean(folder))
+		commits, err := rr.Repo.stepCommits(folder)
+		if err != nil {
+			return nil, fmt.Errorf("commits of %q: %w", folder, err)
+		}
+		target, err := rr.target(commits)
+		if err != nil {
+			return nil, fmt.Errorf("rollback target of %q: %w", folder, err)
+		}
+		if target == "" {
+			log.Printf("Rolling back %s to an empty folder (before the first commit)\n", folder)
+		} else {
+			log.Printf("Rolling back %s to %s\n", folder, target)
+		}
+		files, err := rr.Repo.restoreFolder(target, folder)
+		if err != nil {
+			return nil, fmt.Errorf("restore %q: %w", folder, err)
+		}
+		restored = append(restored, files...)
+	}
+	sort.Strings(restored)
+	return restored, nil
+}
+
+// target returns the revision to restore a deploy folder to given the
+// commits of the step (newest first). Each rollback of the previous
+// deployment undid the next deployment, so repeated rollbacks go back one
+// more deployment every time. The revision is empty if the deployment to
+// undo is the first commit of the repository (there was nothing before it).
+func (rr RollbackRenderer) target(commits []stepCommit) (string, error) {
+	var undone int
+	for _, c := range commits {
+		if c.isRollback() {
+			if c.has(trailerRollback, rollbackPrevious) {
+				undone++
+			}
+			continue
+		}
+		if undone > 0 && rr.ToSourceCommit == "" && rr.ToBuildNumber == "" {
+			undone--
+			continue
+		}
+		switch {
+		case rr.ToSourceCommit != "":
+			for _, sha := range c.trailers[trailerSourceCommit] {
+				// Short SHAs are accepted as well.
+				if strings.HasPrefix(sha, rr.ToSourceCommit) {
+					return c.sha, nil
+				}
+			}
+		case rr.ToBuildNumber != "":
+			if c.has(trailerBuildNumber, rr.ToBuildNumber) {
+				return c.sha, nil
+			}
+		default:
+			// State before the most recent deployment.
+			if c.root {
+				return "", nil
+			}
+			return c.sha + "^", nil
+		}
+	}
+	switch {
+	case rr.ToSourceCommit != "":
+		return "", fmt.Errorf("no deployment of source commit %q", rr.ToSourceCommit)
+	case rr.ToBuildNumber != "":
+		return "", fmt.Errorf("no deployment of build %q", rr.ToBuildNumber)
+	}
+	return "", fmt.Errorf("no deployment to roll back")
+}
@@ -0,0 +1,160 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTrailers(t *testing.T) {
+	trailers := CommitTrailers([]string{"apps/prod/"}, BuildInfo{
+		SourceCommit: "abc123",
+		BuildNumber:  "12",
+	})
+	assert.Equal(t, "Update prod\n\n"+
+		"Gitops-Deploy-Folder: apps/prod\n"+
+		"Gitops-Source-Commit: abc123\n"+
+		"Gitops-Build-Number: 12",
+		withTrailers("Update prod\n", trailers))
+	assert.Equal(t, "Update prod", withTrailers("Update prod", nil), "without trailers")
+}
+
+func TestParseStepCommits(t *testing.T) {
+	log := "sha3\x1fsha2\x1fGitops-Deploy-Folder: staging\n\x1e\n" +
+		"sha2\x1fsha1\x1fGitops-Deploy-Folder: prod\nGitops-Rollback: previous deployment\n\x1e\n" +
+		"sha1\x1f\x1fGitops-Deploy-Folder: staging\nGitops-Deploy-Folder: prod\nGitops-Build-Number: 1\n\x1e\n" +
+		"sha0\x1f\x1f\x1e\n"
+	assert.Equal(t, []stepCommit{
+		{sha: "sha2", trailers: map[string][]string{
+			"Gitops-Deploy-Folder": {"prod"},
+			"Gitops-Rollback":      {"previous deployment"},
+		}},
+		{sha: "sha1", root: true, trailers: map[string][]string{
+			"Gitops-Deploy-Folder": {"staging", "prod"},
+			"Gitops-Build-Number":  {"1"},
+		}},
+	}, parseStepCommits(log, "prod"))
+}
+
+var rollbackCases = map[string]struct {
+	toSourceCommit string
+	toBuildNumber  string
+	wantContent    string
+	wantFiles      []string
+	wantErr        bool
+}{
+	"deployment before the rolled back one": {
+		wantContent: "tag: 1\n",
+		wantFiles:   []string{"prod/old.yaml", "prod/values.yaml"},
+	},
+	"deployment of a build": {
+		toBuildNumber: "1",
+		wantContent:   "tag: 1\n",
+		wantFiles:     []string{"prod/old.yaml", "prod/values.yaml"},
+	},
+	"deployment of a short source commit": {
+		toSourceCommit: "bbb",
+		wantContent:    "tag: 2\n",
+		wantFiles:      []string{"prod/values.yaml"},
+	},
+	"unknown build (error)": {
+		toBuildNumber: "42",
+		wantErr:       true,
+	},
+}
+
+func TestRollbackRenderer(t *testing.T) {
+	for name, tc := range rollbackCases {
+		t.Run(name, func(t *testing.T) {
+			repo, close := localClone(t)
+			defer close()
+
+			// Three deployments of the step, the last one is rolled back already.
+			prodPath := path.Join(repo.LocalPath(), "prod")
+			require.NoError(t, os.MkdirAll(path.Join(prodPath, historyFolder), 0700))
+			deploy := func(content string, build BuildInfo, extra ...Trailer) {
+				write(t, path.Join(prodPath, "values.yaml"), content)
+				write(t, path.Join(prodPath, historyFolder, historyFile), build.BuildNumber+"\n")
+				trailers := append(CommitTrailers([]string{"prod"}, build), extra...)
+				require.NoError(t, repo.gitCommitAndPush(withTrailers("deploy", trailers)), "deploy")
+			}
+			write(t, path.Join(prodPath, "old.yaml"), "removed later\n")
+			deploy("tag: 1\n", BuildInfo{SourceCommit: "aaa111", BuildNumber: "1"})
+			require.NoError(t, os.Remove(path.Join(prodPath, "old.yaml")))
+			deploy("tag: 2\n", BuildInfo{SourceCommit: "bbb222", BuildNumber: "2"})
+			deploy("tag: 3\n", BuildInfo{SourceCommit: "ccc333", BuildNumber: "3"})
+			deploy("tag: 3-rollback\n", BuildInfo{BuildNumber: "4"}, RollbackTrailer("", ""))
+
+			rr := RollbackRenderer{
+				Repo:           repo,
+				Folders:        []string{"prod"},
+				ToSourceCommit: tc.toSourceCommit,
+				ToBuildNumber:  tc.toBuildNumber,
+			}
+			gotFiles, err := rr.renderAllFiles()
+			if tc.wantErr {
+				require.Error(t, err, "renderAllFiles")
+				return
+			}
+			require.NoError(t, err, "renderAllFiles")
+			assert.Equal(t, tc.wantFiles, gotFiles, "restored files")
+
+			got, err := ioutil.ReadFile(path.Join(prodPath, "values.yaml"))
+			require.NoError(t, err, "read values.yaml")
+			assert.Equal(t, tc.wantContent, string(got), "restored values.yaml")
+			_, err = os.Stat(path.Join(prodPath, "old.yaml"))
+			assert.Equal(t, len(tc.wantFiles) == 2, err == nil, "restored old.yaml")
+
+			// History isn't restored.
+			history, err := ioutil.ReadFile(path.Join(prodPath, historyFolder, historyFile))
+			require.NoError(t, err, "read history")
+			assert.Equal(t, "4\n", string(history), "history")
+		})
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	commit := func(sha string, trailers ...Trailer) stepCommit {
+		c := stepCommit{sha: sha, trailers: map[string][]string{}}
+		for _, tr := range trailers {
+			c.trailers[tr.Key] = append(c.trailers[tr.Key], tr.Value)
+		}
+		return c
+	}
+	build := func(number string) Trailer {
+		return Trailer{trailerBuildNumber, number}
+	}
+	// Newest first: deployments 3 and 4 were undone by rollbacks of the
+	// previous deployment, explicit rollbacks aren't counted.
+	commits := []stepCommit{
+		commit("r3", RollbackTrailer("", "")),
+		commit("d4", build("4")),
+		commit("r2", RollbackTrailer("", "2")),
+		commit("r1", RollbackTrailer("", "")),
+		commit("d3", build("3")),
+		commit("d2", build("2")),
+		commit("d1", build("1")),
+	}
+
+	got, err := RollbackRenderer{}.target(commits)
+	require.NoError(t, err, "target")
+	assert.Equal(t, "d2^", got, "previous deployment")
+
+	_, err = RollbackRenderer{}.target(commits[:1])
+	require.Error(t, err, "nothing left to roll back")
+
+	got, err = RollbackRenderer{ToBuildNumber: "3"}.target(commits)
+	require.NoError(t, err, "target of build")
+	assert.Equal(t, "d3", got, "deployment of build 3")
+
+	// There is nothing before the first commit of the repository.
+	first := commit("d1", build("1"))
+	first.root = true
+	got, err = RollbackRenderer{}.target([]stepCommit{first})
+	require.NoError(t, err, "target of first commit")
+	assert.Equal(t, "", got, "empty folder")
+}
@@ -0,0 +1,269 @@
+package gitops
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// quantityDefinition is the definition of resource quantities (e.g. `500m`
+// or `1`), which are either strings or numbers.
+const quantityDefinition = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+
+// jsonSchema is a (structural) JSON schema of Kubernetes OpenAPI definitions
+// and CustomResourceDefinitions. Only keywords used by Kubernetes are
+// supported. Objects with properties are closed: unknown fields are invalid
+// (like `kubectl apply --validate=strict`), unless additional properties or
+// unknown fields are allowed explicitly.
+type jsonSchema struct {
+	Ref                   string                 `json:"$ref"`
+	Type                  schemaTypes            `json:"type"`
+	Format                string                 `json:"format"`
+	Properties            map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties  *additionalProperties  `json:"additionalProperties"`
+	Required              []string               `json:"required"`
+	Items                 *jsonSchema            `json:"items"`
+	Enum                  []interface{}          `json:"enum"`
+	AllOf                 []*jsonSchema          `json:"allOf"`
+	AnyOf                 []*jsonSchema          `json:"anyOf"`
+	OneOf                 []*jsonSchema          `json:"oneOf"`
+	IntOrString           bool                   `json:"x-kubernetes-int-or-string"`
+	PreserveUnknownFields bool                   `json:"x-kubernetes-preserve-unknown-fields"`
+	// GroupVersionKinds are the kinds of top-level definitions.
+	GroupVersionKinds []groupVersionKind `json:"x-kubernetes-group-version-kind"`
+}
+
+// groupVersionKind identifies the schema of a manifest.
+type groupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// apiVersion returns the apiVersion of manifests of the kind.
+func (gvk groupVersionKind) apiVersion() string {
+	if gvk.Group == "" {
+		return gvk.Version
+	}
+	return gvk.Group + "/" + gvk.Version
+}
+
+// schemaTypes are the allowed types of a value (a single type or a list).
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("type must be a string or a list of strings: %w", err)
+	}
+	*t = list
+	return nil
+}
+
+// additionalProperties are either allowed (or not) or have a schema.
+type additionalProperties struct {
+	allowed bool
+	schema  *jsonSchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(b, &a.schema)
+}
+
+// fieldError is an invalid field of a YAML document.
+type fieldError struct {
+	line    int
+	path    string
+	message string
+}
+
+// schemaValidator validates YAML documents against schemas which may refer
+// to definitions (by `#/definitions/<name>` or `#/components/schemas/<name>`).
+type schemaValidator struct {
+	definitions map[string]*jsonSchema
+	errs        []fieldError
+}
+
+// validate validates a node against a schema and collects errors of all
+// invalid fields. The path is the path of the node in error messages.
+func (v *schemaValidator) validate(s *jsonSchema, node *yaml.Node, path string) {
+	if s == nil {
+		return
+	}
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if s.Ref != "" {
+		name := s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		if name == quantityDefinition {
+			v.validateScalar(node, path, "quantity", "!!str", "!!int", "!!float")
+			return
+		}
+		// Unknown references (e.g. of partial definitions) allow anything.
+		v.validate(v.definitions[name], node, path)
+		return
+	}
+	for _, sub := range s.AllOf {
+		v.validate(sub, node, path)
+	}
+	if len(s.AnyOf) > 0 && !v.matchesAny(s.AnyOf, node, path) ||
+		len(s.OneOf) > 0 && !v.matchesAny(s.OneOf, node, path) {
+		v.fail(node, path, "doesn't match any of the allowed schemas")
+		return
+	}
+	// Null is the zero value of all fields.
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" {
+		return
+	}
+	if s.IntOrString || s.Format == "int-or-string" {
+		v.validateScalar(node, path, "integer or string", "!!int", "!!str")
+		return
+	}
+	if len(s.Type) > 0 && !s.Type.match(node) {
+		v.fail(node, path, fmt.Sprintf("expected %s, got %s", strings.Join(s.Type, " or "), nodeType(node)))
+		return
+	}
+	if len(s.Enum) > 0 && node.Kind == yaml.ScalarNode && !inEnum(s.Enum, node.Value) {
+		var allowed []string
+		for _, e := range s.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+		}
+		v.fail(node, path, fmt.Sprintf("value %q isn't one of %s", node.Value, strings.Join(allowed, ", ")))
+		return
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		v.validateMapping(s, node, path)
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// validateMapping validates the fields of a mapping.
+func (v *schemaValidator) validateMapping(s *jsonSchema, node *yaml.Node, path string) {
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		fieldPath := joinFieldPath(path, key.Value)
+		if seen[key.Value] {
+			v.fail(key, fieldPath, "duplicate field")
+			continue
+		}
+		seen[key.Value] = true
+		if prop, ok := s.Properties[key.Value]; ok {
+			v.validate(prop, value, fieldPath)
+			continue
+		}
+		switch {
+		case s.AdditionalProperties != nil && s.AdditionalProperties.schema != nil:
+			v.validate(s.AdditionalProperties.schema, value, fieldPath)
+		case s.AdditionalProperties != nil && !s.AdditionalProperties.allowed,
+			s.AdditionalProperties == nil && len(s.Properties) > 0 && !s.PreserveUnknownFields:
+			v.fail(key, fieldPath, "unknown field")
+		}
+	}
+	for _, name := range s.Required {
+		if !seen[name] {
+			v.fail(node, path, fmt.Sprintf("missing required field %q", name))
+		}
+	}
+}
+
+// validateScalar validates that a node is a scalar of one of the given tags.
+func (v *schemaValidator) validateScalar(node *yaml.Node, path, want string, tags ...string) {
+	if node.Kind == yaml.ScalarNode {
+		tag := node.ShortTag()
+		if tag == "!!null" {
+			return
+		}
+		for _, t := range tags {
+			if tag == t {
+				return
+			}
+		}
+	}
+	v.fail(node, path, fmt.Sprintf("expected %s, got %s", want, nodeType(node)))
+}
+
+// matchesAny tells whether a node is valid against any of the schemas.
+func (v *schemaValidator) matchesAny(schemas []*jsonSchema, node *yaml.Node, path string) bool {
+	for _, s := range schemas {
+		sub := schemaValidator{definitions: v.definitions}
+		sub.validate(s, node, path)
+		if len(sub.errs) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fail records an invalid field.
+func (v *schemaValidator) fail(node *yaml.Node, path, message string) {
+	v.errs = append(v.errs, fieldError{line: node.Line, path: path, message: message})
+}
+
+// match tells whether a node is of any of the types. Timestamps are strings
+// (as JSON has no timestamps) and integers are numbers as well.
+func (t schemaTypes) match(node *yaml.Node) bool {
+	for _, want := range t {
+		switch got := nodeType(node); {
+		case got == want,
+			want == "number" && got == "integer",
+			want == "string" && node.ShortTag() == "!!timestamp":
+			return true
+		}
+	}
+	return false
+}
+
+// nodeType returns the JSON schema type of a node.
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+// inEnum tells whether a scalar value is one of the enum values.
+func inEnum(enum []interface{}, value string) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// joinFieldPath appends a field name to the path of a field.
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
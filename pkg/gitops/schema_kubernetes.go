@@ -0,0 +1,918 @@
+package gitops
+
+// servedAPI is a kind of a built-in API version and the minor version of
+// Kubernetes it was removed in (0 means it isn't removed).
+type servedAPI struct {
+	apiVersion string
+	kind       string
+	removedIn  int
+}
+
+// servedAPIs are the kinds of built-in API versions. Manifests of other kinds
+// of these API versions and of removed ones are invalid, other API versions
+// are validated only if they have a schema.
+var servedAPIs = []servedAPI{
+	{apiVersion: "v1", kind: "Binding"},
+	{apiVersion: "v1", kind: "ComponentStatus"},
+	{apiVersion: "v1", kind: "ConfigMap"},
+	{apiVersion: "v1", kind: "Endpoints"},
+	{apiVersion: "v1", kind: "Event"},
+	{apiVersion: "v1", kind: "LimitRange"},
+	{apiVersion: "v1", kind: "List"},
+	{apiVersion: "v1", kind: "Namespace"},
+	{apiVersion: "v1", kind: "Node"},
+	{apiVersion: "v1", kind: "PersistentVolume"},
+	{apiVersion: "v1", kind: "PersistentVolumeClaim"},
+	{apiVersion: "v1", kind: "Pod"},
+	{apiVersion: "v1", kind: "PodTemplate"},
+	{apiVersion: "v1", kind: "ReplicationController"},
+	{apiVersion: "v1", kind: "ResourceQuota"},
+	{apiVersion: "v1", kind: "Secret"},
+	{apiVersion: "v1", kind: "Service"},
+	{apiVersion: "v1", kind: "ServiceAccount"},
+	{apiVersion: "apps/v1", kind: "ControllerRevision"},
+	{apiVersion: "apps/v1", kind: "DaemonSet"},
+	{apiVersion: "apps/v1", kind: "Deployment"},
+	{apiVersion: "apps/v1", kind: "ReplicaSet"},
+	{apiVersion: "apps/v1", kind: "StatefulSet"},
+	{apiVersion: "apps/v1beta1", kind: "ControllerRevision", removedIn: 16},
+	{apiVersion: "apps/v1beta1", kind: "Deployment", removedIn: 16},
+	{apiVersion: "apps/v1beta1", kind: "StatefulSet", removedIn: 16},
+	{apiVersion: "apps/v1beta2", kind: "ControllerRevision", removedIn: 16},
+	{apiVersion: "apps/v1beta2", kind: "DaemonSet", removedIn: 16},
+	{apiVersion: "apps/v1beta2", kind: "Deployment", removedIn: 16},
+	{apiVersion: "apps/v1beta2", kind: "ReplicaSet", removedIn: 16},
+	{apiVersion: "apps/v1beta2", kind: "StatefulSet", removedIn: 16},
+	{apiVersion: "extensions/v1beta1", kind: "DaemonSet", removedIn: 16},
+	{apiVersion: "extensions/v1beta1", kind: "Deployment", removedIn: 16},
+	{apiVersion: "extensions/v1beta1", kind: "Ingress", removedIn: 22},
+	{apiVersion: "extensions/v1beta1", kind: "NetworkPolicy", removedIn: 16},
+	{apiVersion: "extensions/v1beta1", kind: "PodSecurityPolicy", removedIn: 16},
+	{apiVersion: "extensions/v1beta1", kind: "ReplicaSet", removedIn: 16},
+	{apiVersion: "batch/v1", kind: "CronJob"},
+	{apiVersion: "batch/v1", kind: "Job"},
+	{apiVersion: "batch/v1beta1", kind: "CronJob", removedIn: 25},
+	{apiVersion: "autoscaling/v1", kind: "HorizontalPodAutoscaler"},
+	{apiVersion: "autoscaling/v2", kind: "HorizontalPodAutoscaler"},
+	{apiVersion: "autoscaling/v2beta1", kind: "HorizontalPodAutoscaler", removedIn: 25},
+	{apiVersion: "autoscaling/v2beta2", kind: "HorizontalPodAutoscaler", removedIn: 26},
+	{apiVersion: "policy/v1", kind: "PodDisruptionBudget"},
+	{apiVersion: "policy/v1beta1", kind: "PodDisruptionBudget", removedIn: 25},
+	{apiVersion: "policy/v1beta1", kind: "PodSecurityPolicy", removedIn: 25},
+	{apiVersion: "networking.k8s.io/v1", kind: "IPAddress"},
+	{apiVersion: "networking.k8s.io/v1", kind: "Ingress"},
+	{apiVersion: "networking.k8s.io/v1", kind: "IngressClass"},
+	{apiVersion: "networking.k8s.io/v1", kind: "NetworkPolicy"},
+	{apiVersion: "networking.k8s.io/v1", kind: "ServiceCIDR"},
+	{apiVersion: "networking.k8s.io/v1beta1", kind: "Ingress", removedIn: 22},
+	{apiVersion: "networking.k8s.io/v1beta1", kind: "IngressClass", removedIn: 22},
+	{apiVersion: "rbac.authorization.k8s.io/v1", kind: "ClusterRole"},
+	{apiVersion: "rbac.authorization.k8s.io/v1", kind: "ClusterRoleBinding"},
+	{apiVersion: "rbac.authorization.k8s.io/v1", kind: "Role"},
+	{apiVersion: "rbac.authorization.k8s.io/v1", kind: "RoleBinding"},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", kind: "ClusterRole", removedIn: 22},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", kind: "ClusterRoleBinding", removedIn: 22},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", kind: "Role", removedIn: 22},
+	{apiVersion: "rbac.authorization.k8s.io/v1beta1", kind: "RoleBinding", removedIn: 22},
+}
+
+// kubernetesDefinitions are the bundled OpenAPI (v2) definitions of the most
+// common built-in kinds in the format of the `swagger.json` of Kubernetes.
+// They aren't version specific (fields of the definitions are the ones of
+// Kubernetes 1.34), so validation is a structural sanity check of manifests
+// rather than a check against a cluster version. Rarely edited parts (e.g. affinities, security contexts and
+// volume sources) are objects of any fields. Kinds of servedAPIs without a
+// definition (e.g. Endpoints or IngressClass) aren't validated.
+const kubernetesDefinitions = `{
+  "definitions": {
+    "io.k8s.apimachinery.pkg.api.resource.Quantity": {"type": "string"},
+    "io.k8s.apimachinery.pkg.util.intstr.IntOrString": {"type": "string", "format": "int-or-string"},
+    "io.k8s.apimachinery.pkg.apis.meta.v1.Time": {"type": "string", "format": "date-time"},
+    "io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+      "type": "object",
+      "properties": {
+        "annotations": {"type": "object", "additionalProperties": {"type": "string"}},
+        "creationTimestamp": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.Time"},
+        "deletionGracePeriodSeconds": {"type": "integer"},
+        "deletionTimestamp": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.Time"},
+        "finalizers": {"type": "array", "items": {"type": "string"}},
+        "generateName": {"type": "string"},
+        "generation": {"type": "integer"},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}},
+        "managedFields": {"type": "array", "items": {"type": "object"}},
+        "name": {"type": "string"},
+        "namespace": {"type": "string"},
+        "ownerReferences": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.OwnerReference"}},
+        "resourceVersion": {"type": "string"},
+        "selfLink": {"type": "string"},
+        "uid": {"type": "string"}
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.OwnerReference": {
+      "type": "object",
+      "required": ["apiVersion", "kind", "name", "uid"],
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "blockOwnerDeletion": {"type": "boolean"},
+        "controller": {"type": "boolean"},
+        "kind": {"type": "string"},
+        "name": {"type": "string"},
+        "uid": {"type": "string"}
+      }
+    },
+    "io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector": {
+      "type": "object",
+      "properties": {
+        "matchExpressions": {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "required": ["key", "operator"],
+            "properties": {
+              "key": {"type": "string"},
+              "operator": {"type": "string"},
+              "values": {"type": "array", "items": {"type": "string"}}
+            }
+          }
+        },
+        "matchLabels": {"type": "object", "additionalProperties": {"type": "string"}}
+      }
+    },
+    "io.k8s.api.core.v1.LocalObjectReference": {
+      "type": "object",
+      "properties": {"name": {"type": "string"}}
+    },
+    "io.k8s.api.core.v1.ResourceRequirements": {
+      "type": "object",
+      "properties": {
+        "claims": {"type": "array", "items": {"type": "object"}},
+        "limits": {"type": "object", "additionalProperties": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.api.resource.Quantity"}},
+        "requests": {"type": "object", "additionalProperties": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.api.resource.Quantity"}}
+      }
+    },
+    "io.k8s.api.core.v1.EnvVar": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "value": {"type": "string"},
+        "valueFrom": {"type": "object"}
+      }
+    },
+    "io.k8s.api.core.v1.EnvFromSource": {
+      "type": "object",
+      "properties": {
+        "configMapRef": {"type": "object", "properties": {"name": {"type": "string"}, "optional": {"type": "boolean"}}},
+        "prefix": {"type": "string"},
+        "secretRef": {"type": "object", "properties": {"name": {"type": "string"}, "optional": {"type": "boolean"}}}
+      }
+    },
+    "io.k8s.api.core.v1.ContainerPort": {
+      "type": "object",
+      "required": ["containerPort"],
+      "properties": {
+        "containerPort": {"type": "integer"},
+        "hostIP": {"type": "string"},
+        "hostPort": {"type": "integer"},
+        "name": {"type": "string"},
+        "protocol": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.Probe": {
+      "type": "object",
+      "properties": {
+        "exec": {"type": "object", "properties": {"command": {"type": "array", "items": {"type": "string"}}}},
+        "failureThreshold": {"type": "integer"},
+        "grpc": {"type": "object", "required": ["port"], "properties": {"port": {"type": "integer"}, "service": {"type": "string"}}},
+        "httpGet": {
+          "type": "object",
+          "required": ["port"],
+          "properties": {
+            "host": {"type": "string"},
+            "httpHeaders": {
+              "type": "array",
+              "items": {"type": "object", "required": ["name", "value"], "properties": {"name": {"type": "string"}, "value": {"type": "string"}}}
+            },
+            "path": {"type": "string"},
+            "port": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"},
+            "scheme": {"type": "string"}
+          }
+        },
+        "initialDelaySeconds": {"type": "integer"},
+        "periodSeconds": {"type": "integer"},
+        "successThreshold": {"type": "integer"},
+        "tcpSocket": {
+          "type": "object",
+          "required": ["port"],
+          "properties": {"host": {"type": "string"}, "port": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"}}
+        },
+        "terminationGracePeriodSeconds": {"type": "integer"},
+        "timeoutSeconds": {"type": "integer"}
+      }
+    },
+    "io.k8s.api.core.v1.VolumeMount": {
+      "type": "object",
+      "required": ["mountPath", "name"],
+      "properties": {
+        "mountPath": {"type": "string"},
+        "mountPropagation": {"type": "string"},
+        "name": {"type": "string"},
+        "readOnly": {"type": "boolean"},
+        "recursiveReadOnly": {"type": "string"},
+        "subPath": {"type": "string"},
+        "subPathExpr": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.Container": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "args": {"type": "array", "items": {"type": "string"}},
+        "command": {"type": "array", "items": {"type": "string"}},
+        "env": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.EnvVar"}},
+        "envFrom": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.EnvFromSource"}},
+        "image": {"type": "string"},
+        "imagePullPolicy": {"type": "string", "enum": ["Always", "IfNotPresent", "Never"]},
+        "lifecycle": {"type": "object"},
+        "livenessProbe": {"$ref": "#/definitions/io.k8s.api.core.v1.Probe"},
+        "name": {"type": "string"},
+        "ports": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.ContainerPort"}},
+        "readinessProbe": {"$ref": "#/definitions/io.k8s.api.core.v1.Probe"},
+        "resizePolicy": {"type": "array", "items": {"type": "object"}},
+        "resources": {"$ref": "#/definitions/io.k8s.api.core.v1.ResourceRequirements"},
+        "restartPolicy": {"type": "string"},
+        "securityContext": {"type": "object"},
+        "startupProbe": {"$ref": "#/definitions/io.k8s.api.core.v1.Probe"},
+        "stdin": {"type": "boolean"},
+        "stdinOnce": {"type": "boolean"},
+        "terminationMessagePath": {"type": "string"},
+        "terminationMessagePolicy": {"type": "string"},
+        "tty": {"type": "boolean"},
+        "volumeDevices": {
+          "type": "array",
+          "items": {"type": "object", "required": ["devicePath", "name"], "properties": {"devicePath": {"type": "string"}, "name": {"type": "string"}}}
+        },
+        "volumeMounts": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.VolumeMount"}},
+        "workingDir": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.Volume": {
+      "type": "object",
+      "required": ["name"],
+      "properties": {
+        "awsElasticBlockStore": {"type": "object"},
+        "azureDisk": {"type": "object"},
+        "azureFile": {"type": "object"},
+        "cephfs": {"type": "object"},
+        "cinder": {"type": "object"},
+        "configMap": {"type": "object"},
+        "csi": {"type": "object"},
+        "downwardAPI": {"type": "object"},
+        "emptyDir": {"type": "object"},
+        "ephemeral": {"type": "object"},
+        "fc": {"type": "object"},
+        "flexVolume": {"type": "object"},
+        "flocker": {"type": "object"},
+        "gcePersistentDisk": {"type": "object"},
+        "gitRepo": {"type": "object"},
+        "glusterfs": {"type": "object"},
+        "hostPath": {"type": "object"},
+        "image": {"type": "object"},
+        "iscsi": {"type": "object"},
+        "name": {"type": "string"},
+        "nfs": {"type": "object"},
+        "persistentVolumeClaim": {"type": "object"},
+        "photonPersistentDisk": {"type": "object"},
+        "portworxVolume": {"type": "object"},
+        "projected": {"type": "object"},
+        "quobyte": {"type": "object"},
+        "rbd": {"type": "object"},
+        "scaleIO": {"type": "object"},
+        "secret": {"type": "object"},
+        "storageos": {"type": "object"},
+        "vsphereVolume": {"type": "object"}
+      }
+    },
+    "io.k8s.api.core.v1.Toleration": {
+      "type": "object",
+      "properties": {
+        "effect": {"type": "string"},
+        "key": {"type": "string"},
+        "operator": {"type": "string"},
+        "tolerationSeconds": {"type": "integer"},
+        "value": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.PodSpec": {
+      "type": "object",
+      "required": ["containers"],
+      "properties": {
+        "activeDeadlineSeconds": {"type": "integer"},
+        "affinity": {"type": "object"},
+        "automountServiceAccountToken": {"type": "boolean"},
+        "containers": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Container"}},
+        "dnsConfig": {"type": "object"},
+        "dnsPolicy": {"type": "string"},
+        "enableServiceLinks": {"type": "boolean"},
+        "ephemeralContainers": {"type": "array", "items": {"type": "object"}},
+        "hostAliases": {
+          "type": "array",
+          "items": {"type": "object", "required": ["ip"], "properties": {"hostnames": {"type": "array", "items": {"type": "string"}}, "ip": {"type": "string"}}}
+        },
+        "hostIPC": {"type": "boolean"},
+        "hostNetwork": {"type": "boolean"},
+        "hostPID": {"type": "boolean"},
+        "hostUsers": {"type": "boolean"},
+        "hostname": {"type": "string"},
+        "imagePullSecrets": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.LocalObjectReference"}},
+        "initContainers": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Container"}},
+        "nodeName": {"type": "string"},
+        "nodeSelector": {"type": "object", "additionalProperties": {"type": "string"}},
+        "os": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}},
+        "overhead": {"type": "object", "additionalProperties": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.api.resource.Quantity"}},
+        "preemptionPolicy": {"type": "string"},
+        "priority": {"type": "integer"},
+        "priorityClassName": {"type": "string"},
+        "readinessGates": {
+          "type": "array",
+          "items": {"type": "object", "required": ["conditionType"], "properties": {"conditionType": {"type": "string"}}}
+        },
+        "resourceClaims": {"type": "array", "items": {"type": "object"}},
+        "resources": {"$ref": "#/definitions/io.k8s.api.core.v1.ResourceRequirements"},
+        "restartPolicy": {"type": "string", "enum": ["Always", "OnFailure", "Never"]},
+        "runtimeClassName": {"type": "string"},
+        "schedulerName": {"type": "string"},
+        "schedulingGates": {
+          "type": "array",
+          "items": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}
+        },
+        "securityContext": {"type": "object"},
+        "serviceAccount": {"type": "string"},
+        "serviceAccountName": {"type": "string"},
+        "setHostnameAsFQDN": {"type": "boolean"},
+        "shareProcessNamespace": {"type": "boolean"},
+        "subdomain": {"type": "string"},
+        "terminationGracePeriodSeconds": {"type": "integer"},
+        "tolerations": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Toleration"}},
+        "topologySpreadConstraints": {"type": "array", "items": {"type": "object"}},
+        "volumes": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.Volume"}}
+      }
+    },
+    "io.k8s.api.core.v1.PodTemplateSpec": {
+      "type": "object",
+      "properties": {
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"}
+      }
+    },
+    "io.k8s.api.core.v1.PersistentVolumeClaimSpec": {
+      "type": "object",
+      "properties": {
+        "accessModes": {"type": "array", "items": {"type": "string"}},
+        "dataSource": {"type": "object"},
+        "dataSourceRef": {"type": "object"},
+        "resources": {"$ref": "#/definitions/io.k8s.api.core.v1.ResourceRequirements"},
+        "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+        "storageClassName": {"type": "string"},
+        "volumeAttributesClassName": {"type": "string"},
+        "volumeMode": {"type": "string"},
+        "volumeName": {"type": "string"}
+      }
+    },
+    "io.k8s.api.core.v1.PersistentVolumeClaim": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PersistentVolumeClaimSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "PersistentVolumeClaim", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.Pod": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.PodSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Pod", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.ConfigMap": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "binaryData": {"type": "object", "additionalProperties": {"type": "string"}},
+        "data": {"type": "object", "additionalProperties": {"type": "string"}},
+        "immutable": {"type": "boolean"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.Secret": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "data": {"type": "object", "additionalProperties": {"type": "string"}},
+        "immutable": {"type": "boolean"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "stringData": {"type": "object", "additionalProperties": {"type": "string"}},
+        "type": {"type": "string"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Secret", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.ServiceAccount": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "automountServiceAccountToken": {"type": "boolean"},
+        "imagePullSecrets": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.LocalObjectReference"}},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "secrets": {"type": "array", "items": {"type": "object"}}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "ServiceAccount", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.Namespace": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"type": "object", "properties": {"finalizers": {"type": "array", "items": {"type": "string"}}}},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Namespace", "version": "v1"}]
+    },
+    "io.k8s.api.core.v1.ServicePort": {
+      "type": "object",
+      "required": ["port"],
+      "properties": {
+        "appProtocol": {"type": "string"},
+        "name": {"type": "string"},
+        "nodePort": {"type": "integer"},
+        "port": {"type": "integer"},
+        "protocol": {"type": "string"},
+        "targetPort": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"}
+      }
+    },
+    "io.k8s.api.core.v1.ServiceSpec": {
+      "type": "object",
+      "properties": {
+        "allocateLoadBalancerNodePorts": {"type": "boolean"},
+        "clusterIP": {"type": "string"},
+        "clusterIPs": {"type": "array", "items": {"type": "string"}},
+        "externalIPs": {"type": "array", "items": {"type": "string"}},
+        "externalName": {"type": "string"},
+        "externalTrafficPolicy": {"type": "string"},
+        "healthCheckNodePort": {"type": "integer"},
+        "internalTrafficPolicy": {"type": "string"},
+        "ipFamilies": {"type": "array", "items": {"type": "string"}},
+        "ipFamilyPolicy": {"type": "string"},
+        "loadBalancerClass": {"type": "string"},
+        "loadBalancerIP": {"type": "string"},
+        "loadBalancerSourceRanges": {"type": "array", "items": {"type": "string"}},
+        "ports": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.ServicePort"}},
+        "publishNotReadyAddresses": {"type": "boolean"},
+        "selector": {"type": "object", "additionalProperties": {"type": "string"}},
+        "sessionAffinity": {"type": "string"},
+        "sessionAffinityConfig": {"type": "object"},
+        "trafficDistribution": {"type": "string"},
+        "type": {"type": "string", "enum": ["ClusterIP", "ExternalName", "LoadBalancer", "NodePort"]}
+      }
+    },
+    "io.k8s.api.core.v1.Service": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.core.v1.ServiceSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "", "kind": "Service", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DeploymentSpec": {
+      "type": "object",
+      "required": ["selector", "template"],
+      "properties": {
+        "minReadySeconds": {"type": "integer"},
+        "paused": {"type": "boolean"},
+        "progressDeadlineSeconds": {"type": "integer"},
+        "replicas": {"type": "integer"},
+        "revisionHistoryLimit": {"type": "integer"},
+        "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+        "strategy": {
+          "type": "object",
+          "properties": {
+            "rollingUpdate": {
+              "type": "object",
+              "properties": {
+                "maxSurge": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"},
+                "maxUnavailable": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"}
+              }
+            },
+            "type": {"type": "string", "enum": ["Recreate", "RollingUpdate"]}
+          }
+        },
+        "template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"}
+      }
+    },
+    "io.k8s.api.apps.v1.Deployment": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.StatefulSetSpec": {
+      "type": "object",
+      "required": ["selector", "template"],
+      "properties": {
+        "minReadySeconds": {"type": "integer"},
+        "ordinals": {"type": "object", "properties": {"start": {"type": "integer"}}},
+        "persistentVolumeClaimRetentionPolicy": {
+          "type": "object",
+          "properties": {"whenDeleted": {"type": "string"}, "whenScaled": {"type": "string"}}
+        },
+        "podManagementPolicy": {"type": "string", "enum": ["OrderedReady", "Parallel"]},
+        "replicas": {"type": "integer"},
+        "revisionHistoryLimit": {"type": "integer"},
+        "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+        "serviceName": {"type": "string"},
+        "template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"},
+        "updateStrategy": {
+          "type": "object",
+          "properties": {
+            "rollingUpdate": {
+              "type": "object",
+              "properties": {
+                "maxUnavailable": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"},
+                "partition": {"type": "integer"}
+              }
+            },
+            "type": {"type": "string", "enum": ["OnDelete", "RollingUpdate"]}
+          }
+        },
+        "volumeClaimTemplates": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.core.v1.PersistentVolumeClaim"}}
+      }
+    },
+    "io.k8s.api.apps.v1.StatefulSet": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.StatefulSetSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "StatefulSet", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DaemonSetSpec": {
+      "type": "object",
+      "required": ["selector", "template"],
+      "properties": {
+        "minReadySeconds": {"type": "integer"},
+        "revisionHistoryLimit": {"type": "integer"},
+        "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+        "template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"},
+        "updateStrategy": {
+          "type": "object",
+          "properties": {
+            "rollingUpdate": {
+              "type": "object",
+              "properties": {
+                "maxSurge": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"},
+                "maxUnavailable": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"}
+              }
+            },
+            "type": {"type": "string", "enum": ["OnDelete", "RollingUpdate"]}
+          }
+        }
+      }
+    },
+    "io.k8s.api.apps.v1.DaemonSet": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DaemonSetSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "DaemonSet", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.ReplicaSetSpec": {
+      "type": "object",
+      "required": ["selector"],
+      "properties": {
+        "minReadySeconds": {"type": "integer"},
+        "replicas": {"type": "integer"},
+        "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+        "template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"}
+      }
+    },
+    "io.k8s.api.apps.v1.ReplicaSet": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.ReplicaSetSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "ReplicaSet", "version": "v1"}]
+    },
+    "io.k8s.api.batch.v1.JobSpec": {
+      "type": "object",
+      "required": ["template"],
+      "properties": {
+        "activeDeadlineSeconds": {"type": "integer"},
+        "backoffLimit": {"type": "integer"},
+        "backoffLimitPerIndex": {"type": "integer"},
+        "completionMode": {"type": "string"},
+        "completions": {"type": "integer"},
+        "managedBy": {"type": "string"},
+        "manualSelector": {"type": "boolean"},
+        "maxFailedIndexes": {"type": "integer"},
+        "parallelism": {"type": "integer"},
+        "podFailurePolicy": {"type": "object"},
+        "podReplacementPolicy": {"type": "string"},
+        "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+        "successPolicy": {"type": "object"},
+        "suspend": {"type": "boolean"},
+        "template": {"$ref": "#/definitions/io.k8s.api.core.v1.PodTemplateSpec"},
+        "ttlSecondsAfterFinished": {"type": "integer"}
+      }
+    },
+    "io.k8s.api.batch.v1.Job": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {"$ref": "#/definitions/io.k8s.api.batch.v1.JobSpec"},
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "batch", "kind": "Job", "version": "v1"}]
+    },
+    "io.k8s.api.batch.v1.CronJob": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {
+          "type": "object",
+          "required": ["jobTemplate", "schedule"],
+          "properties": {
+            "concurrencyPolicy": {"type": "string", "enum": ["Allow", "Forbid", "Replace"]},
+            "failedJobsHistoryLimit": {"type": "integer"},
+            "jobTemplate": {
+              "type": "object",
+              "properties": {
+                "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+                "spec": {"$ref": "#/definitions/io.k8s.api.batch.v1.JobSpec"}
+              }
+            },
+            "schedule": {"type": "string"},
+            "startingDeadlineSeconds": {"type": "integer"},
+            "successfulJobsHistoryLimit": {"type": "integer"},
+            "suspend": {"type": "boolean"},
+            "timeZone": {"type": "string"}
+          }
+        },
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "batch", "kind": "CronJob", "version": "v1"}]
+    },
+    "io.k8s.api.networking.v1.IngressBackend": {
+      "type": "object",
+      "properties": {
+        "resource": {"type": "object"},
+        "service": {
+          "type": "object",
+          "required": ["name"],
+          "properties": {
+            "name": {"type": "string"},
+            "port": {"type": "object", "properties": {"name": {"type": "string"}, "number": {"type": "integer"}}}
+          }
+        }
+      }
+    },
+    "io.k8s.api.networking.v1.Ingress": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {
+          "type": "object",
+          "properties": {
+            "defaultBackend": {"$ref": "#/definitions/io.k8s.api.networking.v1.IngressBackend"},
+            "ingressClassName": {"type": "string"},
+            "rules": {
+              "type": "array",
+              "items": {
+                "type": "object",
+                "properties": {
+                  "host": {"type": "string"},
+                  "http": {
+                    "type": "object",
+                    "required": ["paths"],
+                    "properties": {
+                      "paths": {
+                        "type": "array",
+                        "items": {
+                          "type": "object",
+                          "required": ["backend", "pathType"],
+                          "properties": {
+                            "backend": {"$ref": "#/definitions/io.k8s.api.networking.v1.IngressBackend"},
+                            "path": {"type": "string"},
+                            "pathType": {"type": "string", "enum": ["Exact", "ImplementationSpecific", "Prefix"]}
+                          }
+                        }
+                      }
+                    }
+                  }
+                }
+              }
+            },
+            "tls": {
+              "type": "array",
+              "items": {
+                "type": "object",
+                "properties": {"hosts": {"type": "array", "items": {"type": "string"}}, "secretName": {"type": "string"}}
+              }
+            }
+          }
+        },
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "networking.k8s.io", "kind": "Ingress", "version": "v1"}]
+    },
+    "io.k8s.api.networking.v1.NetworkPolicy": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {
+          "type": "object",
+          "properties": {
+            "egress": {"type": "array", "items": {"type": "object"}},
+            "ingress": {"type": "array", "items": {"type": "object"}},
+            "podSelector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+            "policyTypes": {"type": "array", "items": {"type": "string", "enum": ["Egress", "Ingress"]}}
+          }
+        }
+      },
+      "x-kubernetes-group-version-kind": [{"group": "networking.k8s.io", "kind": "NetworkPolicy", "version": "v1"}]
+    },
+    "io.k8s.api.policy.v1.PodDisruptionBudget": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {
+          "type": "object",
+          "properties": {
+            "maxUnavailable": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"},
+            "minAvailable": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"},
+            "selector": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.LabelSelector"},
+            "unhealthyPodEvictionPolicy": {"type": "string"}
+          }
+        },
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "policy", "kind": "PodDisruptionBudget", "version": "v1"}]
+    },
+    "io.k8s.api.autoscaling.v1.CrossVersionObjectReference": {
+      "type": "object",
+      "required": ["kind", "name"],
+      "properties": {"apiVersion": {"type": "string"}, "kind": {"type": "string"}, "name": {"type": "string"}}
+    },
+    "io.k8s.api.autoscaling.v1.HorizontalPodAutoscaler": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {
+          "type": "object",
+          "required": ["maxReplicas", "scaleTargetRef"],
+          "properties": {
+            "maxReplicas": {"type": "integer"},
+            "minReplicas": {"type": "integer"},
+            "scaleTargetRef": {"$ref": "#/definitions/io.k8s.api.autoscaling.v1.CrossVersionObjectReference"},
+            "targetCPUUtilizationPercentage": {"type": "integer"}
+          }
+        },
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "autoscaling", "kind": "HorizontalPodAutoscaler", "version": "v1"}]
+    },
+    "io.k8s.api.autoscaling.v2.HorizontalPodAutoscaler": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "spec": {
+          "type": "object",
+          "required": ["maxReplicas", "scaleTargetRef"],
+          "properties": {
+            "behavior": {"type": "object"},
+            "maxReplicas": {"type": "integer"},
+            "metrics": {"type": "array", "items": {"type": "object"}},
+            "minReplicas": {"type": "integer"},
+            "scaleTargetRef": {"$ref": "#/definitions/io.k8s.api.autoscaling.v1.CrossVersionObjectReference"}
+          }
+        },
+        "status": {"type": "object"}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "autoscaling", "kind": "HorizontalPodAutoscaler", "version": "v2"}]
+    },
+    "io.k8s.api.rbac.v1.PolicyRule": {
+      "type": "object",
+      "required": ["verbs"],
+      "properties": {
+        "apiGroups": {"type": "array", "items": {"type": "string"}},
+        "nonResourceURLs": {"type": "array", "items": {"type": "string"}},
+        "resourceNames": {"type": "array", "items": {"type": "string"}},
+        "resources": {"type": "array", "items": {"type": "string"}},
+        "verbs": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "io.k8s.api.rbac.v1.RoleRef": {
+      "type": "object",
+      "required": ["apiGroup", "kind", "name"],
+      "properties": {"apiGroup": {"type": "string"}, "kind": {"type": "string"}, "name": {"type": "string"}}
+    },
+    "io.k8s.api.rbac.v1.Subject": {
+      "type": "object",
+      "required": ["kind", "name"],
+      "properties": {
+        "apiGroup": {"type": "string"},
+        "kind": {"type": "string"},
+        "name": {"type": "string"},
+        "namespace": {"type": "string"}
+      }
+    },
+    "io.k8s.api.rbac.v1.Role": {
+      "type": "object",
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "rules": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.rbac.v1.PolicyRule"}}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "rbac.authorization.k8s.io", "kind": "Role", "version": "v1"}]
+    },
+    "io.k8s.api.rbac.v1.ClusterRole": {
+      "type": "object",
+      "properties": {
+        "aggregationRule": {"type": "object"},
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "rules": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.rbac.v1.PolicyRule"}}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "rbac.authorization.k8s.io", "kind": "ClusterRole", "version": "v1"}]
+    },
+    "io.k8s.api.rbac.v1.RoleBinding": {
+      "type": "object",
+      "required": ["roleRef"],
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "roleRef": {"$ref": "#/definitions/io.k8s.api.rbac.v1.RoleRef"},
+        "subjects": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.rbac.v1.Subject"}}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "rbac.authorization.k8s.io", "kind": "RoleBinding", "version": "v1"}]
+    },
+    "io.k8s.api.rbac.v1.ClusterRoleBinding": {
+      "type": "object",
+      "required": ["roleRef"],
+      "properties": {
+        "apiVersion": {"type": "string"},
+        "kind": {"type": "string"},
+        "metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+        "roleRef": {"$ref": "#/definitions/io.k8s.api.rbac.v1.RoleRef"},
+        "subjects": {"type": "array", "items": {"$ref": "#/definitions/io.k8s.api.rbac.v1.Subject"}}
+      },
+      "x-kubernetes-group-version-kind": [{"group": "rbac.authorization.k8s.io", "kind": "ClusterRoleBinding", "version": "v1"}]
+    }
+  }
+}`
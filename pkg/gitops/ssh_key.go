@@ -59,7 +59,7 @@ func NewSSHKey(ctx context.Context, gh githuber) (*sshKey, error) {
 	}
 	keyID, err := gh.AddKey(ctx, ssh.MarshalAuthorizedKey(publicKey))
 	if err != nil {
-		return nil, fmt.Errorf("add github key: %w", err)
+		return nil, classify(errorClassGithub, fmt.Errorf("add github key: %w", err))
 	}
 
 	return &sshKey{
@@ -2,68 +2,446 @@ package gitops
 
 import (
 	"fmt"
-	"html/template"
 	"io/ioutil"
+	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
 )
 
 //go:generate moq -out templates_moq_test.go . renderAllFileser
 type renderAllFileser interface {
-	renderAllFiles() error
+	// renderAllFiles returns slash separated paths of all rendered files
+	// relative to the destination root.
+	renderAllFiles() ([]string, error)
 }
 
 // templatesRenderer implements the renderAllFileser interface.
 var _ sshKeyer = (*sshKey)(nil)
 
+// partialsPattern matches files of the templates folder which hold shared
+// partials (named templates) instead of being rendered on their own.
+const partialsPattern = "_*.tpl"
+
 // TemplatesRenderer renders a folder of templates to a local repository.
 type TemplatesRenderer struct {
 	// Source folder of templates.
 	SourceFolder string
-	// Variables to substitute into the templates.
-	Vars map[string]string
-	// Destination repository for rendered files.
-	DestinationRepo repositorier
-	// Destination folder inside the repository for rendered files.
+	// Optional folder of shared partials (all files are parsed as partials).
+	PartialsFolder string
+	// Values files of variables to substitute into the templates.
+	// They are deep merged in order (later files win).
+	ValuesFiles []string
+	// VarsFile is a file of variables merged after all values files.
+	VarsFile string
+	// Variables to substitute into the templates (they win over values files).
+	Vars map[string]interface{}
+	// EnvironmentValuesFiles are values files of an environment merged
+	// after the inline variables (later files win).
+	EnvironmentValuesFiles []string
+	// EnvironmentVars are inline variables of an environment (merged last).
+	EnvironmentVars map[string]interface{}
+	// Debug logs which layer supplied each variable.
+	Debug bool
+	// SuffixedTemplatesOnly renders only files with a `.tmpl` or `.gotmpl`
+	// suffix, all other files are copied verbatim.
+	SuffixedTemplatesOnly bool
+	// VerbatimPatterns are globs of files which are copied verbatim.
+	VerbatimPatterns []string
+	// Delimiters are custom template delimiters of matching files.
+	Delimiters []DelimitersRule
+	// HTMLEscape HTML-escapes string values substituted into the templates
+	// (like the html/template based rendering of earlier versions did).
+	HTMLEscape bool
+	// Destination root folder for rendered files
+	// (e.g. the local clone of the deploy repository).
+	DestinationRoot string
+	// Destination folder inside the root folder for rendered files.
 	DestinationFolder string
+	// LockFile writes a render lock file to the destination folder
+	// (see renderLock), so the output can be replayed later.
+	LockFile bool
+	// SourceCommit is the commit of the templates recorded in the lock file.
+	SourceCommit string
+	// KustomizeResources regenerates the resources of the kustomization
+	// of the destination folder from the rendered manifests.
+	KustomizeResources bool
+}
+
+// partial is the source of a shared partial template file.
+type partial struct {
+	path    string
+	content string
 }
 
-func (tr TemplatesRenderer) renderAllFiles() error {
-	// Get all template file names from the source folder.
-	files, err := ioutil.ReadDir(tr.SourceFolder)
+func (tr TemplatesRenderer) renderAllFiles() ([]string, error) {
+	// Get all template and partial files from the source folder.
+	files, partialPaths, err := tr.sourceFiles()
+	if err != nil {
+		return nil, fmt.Errorf("source files in %q: %w", tr.SourceFolder, err)
+	}
+
+	// Read shared partials (every rendered file can use them).
+	partials, err := readPartials(partialPaths)
+	if err != nil {
+		return nil, fmt.Errorf("read partials: %w", err)
+	}
+
+	// Merge all layers of variables.
+	vars, err := tr.values()
 	if err != nil {
-		return fmt.Errorf("read files in %q: %w", tr.SourceFolder, err)
+		return nil, fmt.Errorf("values: %w", err)
+	}
+
+	// Render destination paths (file and folder names can be templates)
+	// before rendering any of the files.
+	destinations, err := tr.destinationPaths(files, vars)
+	if err != nil {
+		return nil, fmt.Errorf("destination paths: %w", err)
 	}
 
 	// Render templates one-by-one to the destinaton folder
 	// (substituting variables given).
+	fileVars := vars
+	if tr.HTMLEscape {
+		fileVars = htmlEscapeValues(vars).(map[string]interface{})
+	}
+	var rendered []string
 	for _, file := range files {
-		if err := tr.renderFile(file.Name()); err != nil {
-			return fmt.Errorf("render file %q: %w", file.Name(), err)
+		if err := tr.renderFile(file, destinations[file], partials, fileVars); err != nil {
+			return nil, fmt.Errorf("render file %q: %w", file, err)
 		}
+		rendered = append(rendered, filepath.ToSlash(
+			filepath.Join(tr.DestinationFolder, destinations[file])))
 	}
-	return nil
+
+	// Rendered manifests are the resources of the kustomization.
+	if tr.KustomizeResources {
+		kustomization, err := writeKustomizationResources(tr.DestinationRoot, tr.DestinationFolder, rendered)
+		if err != nil {
+			return nil, fmt.Errorf("kustomization resources: %w", err)
+		}
+		if !containsString(rendered, kustomization) {
+			rendered = append(rendered, kustomization)
+		}
+	}
+
+	// Record everything the output was rendered from.
+	if tr.LockFile {
+		if err := tr.writeLock(files, partialPaths, vars); err != nil {
+			return nil, fmt.Errorf("write lock file: %w", err)
+		}
+	}
+	return rendered, nil
+}
+
+// sourceFiles returns slash separated paths of all templates relative to the
+// source folder (walking subfolders as well) and paths of all partials.
+func (tr TemplatesRenderer) sourceFiles() ([]string, []string, error) {
+	var files, partials []string
+	err := filepath.Walk(tr.SourceFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// Partials folder inside the source folder isn't rendered.
+			if tr.PartialsFolder != "" && sameFile(path, tr.PartialsFolder) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(tr.SourceFolder, path)
+		if err != nil {
+			return err
+		}
+		file := filepath.ToSlash(rel)
+		if tr.isPartial(file) {
+			partials = append(partials, path)
+			return nil
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// All files of the partials folder are partials.
+	if tr.PartialsFolder != "" {
+		folderFiles, err := ioutil.ReadDir(tr.PartialsFolder)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read files in %q: %w", tr.PartialsFolder, err)
+		}
+		for _, file := range folderFiles {
+			if !file.IsDir() {
+				partials = append(partials, filepath.Join(tr.PartialsFolder, file.Name()))
+			}
+		}
+	}
+	return files, partials, nil
+}
+
+// isPartial tells whether a file (slash separated path relative to the source
+// folder) is a partial, which shouldn't be rendered on its own. Only files at
+// the top level of the source folder can be partials (e.g. `_helpers.tpl` of a
+// vendored Helm chart in a subfolder isn't one), unless they are verbatim.
+func (tr TemplatesRenderer) isPartial(file string) bool {
+	if strings.Contains(file, "/") {
+		return false
+	}
+	for _, pattern := range tr.VerbatimPatterns {
+		if matchPath(pattern, file) {
+			return false
+		}
+	}
+	match, _ := path.Match(partialsPattern, file)
+	return match
+}
+
+func readPartials(paths []string) ([]partial, error) {
+	var partials []partial
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read partial %q: %w", path, err)
+		}
+		partials = append(partials, partial{path: path, content: string(b)})
+	}
+	return partials, nil
+}
+
+// destinationPaths returns the rendered destination path (relative to the
+// destination folder) of each template file. Rendered paths can't escape the
+// destination folder and two templates can't be rendered to the same path.
+func (tr TemplatesRenderer) destinationPaths(files []string, vars map[string]interface{}) (map[string]string, error) {
+	destinations := map[string]string{}
+	sources := map[string]string{}
+	for _, file := range files {
+		dest, err := renderPath(trimTemplateSuffix(file), vars)
+		if err != nil {
+			return nil, fmt.Errorf("render path %q: %w", file, err)
+		}
+		if source, ok := sources[dest]; ok {
+			return nil, fmt.Errorf("both %q and %q are rendered to %q", source, file, dest)
+		}
+		sources[dest] = file
+		destinations[file] = dest
+	}
+	return destinations, nil
+}
+
+// renderPath renders a slash separated path template.
+// The result must be a relative path, which doesn't escape it's root.
+func renderPath(path string, vars map[string]interface{}) (string, error) {
+	t, err := newTemplate(path).Option("missingkey=error").Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("parse: %w", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("execute: %w", err)
+	}
+	rendered := b.String()
+	if strings.HasSuffix(rendered, "/") {
+		return "", fmt.Errorf("rendered path %q has an empty file name", rendered)
+	}
+	clean := filepath.Clean(filepath.FromSlash(rendered))
+	if filepath.IsAbs(clean) || clean == "." || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("rendered path %q escapes the destination folder", rendered)
+	}
+	return clean, nil
 }
 
-func (tr TemplatesRenderer) renderFile(fileName string) error {
-	// Parse template.
-	sourceFilePath := filepath.Join(tr.SourceFolder, fileName)
-	t, err := template.ParseFiles(sourceFilePath)
+// values returns variables deep merged from all values files and inline vars.
+func (tr TemplatesRenderer) values() (map[string]interface{}, error) {
+	vars, origins, err := tr.mergedValues()
+	if err != nil {
+		return nil, err
+	}
+	if tr.Debug {
+		logOrigins(origins)
+	}
+	return vars, nil
+}
+
+// mergedValues returns variables deep merged from all values files, the vars
+// file, inline vars and the values files and vars of the environment, and the
+// layer which supplied each variable.
+func (tr TemplatesRenderer) mergedValues() (map[string]interface{}, map[string]string, error) {
+	files := tr.ValuesFiles
+	if tr.VarsFile != "" {
+		files = append(append([]string{}, files...), tr.VarsFile)
+	}
+	layers, err := readValuesLayers(files)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, valuesLayer{name: "inline vars", values: tr.Vars})
+
+	envLayers, err := readValuesLayers(tr.EnvironmentValuesFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, envLayers...)
+	if tr.EnvironmentVars != nil {
+		layers = append(layers, valuesLayer{name: "environment vars", values: tr.EnvironmentVars})
+	}
+
+	vars, origins := mergeValues(layers)
+	return vars, origins, nil
+}
+
+// readValuesLayers returns a layer of variables of each values file.
+func readValuesLayers(files []string) ([]valuesLayer, error) {
+	var layers []valuesLayer
+	for _, path := range files {
+		values, err := readVarsFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read values file: %w", err)
+		}
+		layers = append(layers, valuesLayer{name: path, values: values})
+	}
+	return layers, nil
+}
+
+// logOrigins logs which layer supplied each variable (sorted by key).
+func logOrigins(origins map[string]string) {
+	keys := make([]string, 0, len(origins))
+	for k := range origins {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		log.Printf("Variable %s is supplied by %s\n", k, origins[k])
+	}
+}
+
+func (tr TemplatesRenderer) renderFile(file, dest string, partials []partial, vars map[string]interface{}) error {
+	sourceFilePath := filepath.Join(tr.SourceFolder, filepath.FromSlash(file))
+	content, err := ioutil.ReadFile(sourceFilePath)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
+
+	// Create parent folders of the destination file.
+	destinationFilePath := filepath.Join(
+		tr.DestinationRoot, tr.DestinationFolder, dest)
+	if err := os.MkdirAll(filepath.Dir(destinationFilePath), 0755); err != nil {
+		return fmt.Errorf("create destination folder: %w", err)
+	}
+
+	// Non-template files are copied as-is.
+	rule := tr.renderRule(file, content)
+	if rule.verbatim {
+		if err := ioutil.WriteFile(destinationFilePath, content, 0644); err != nil {
+			return fmt.Errorf("copy file: %w", err)
+		}
+		return nil
+	}
+
+	// Parse template (together with all shared partials).
+	t, err := parseTemplate(filepath.Base(file), string(content), rule, partials)
 	if err != nil {
 		return fmt.Errorf("parse template %q: %w", sourceFilePath, err)
 	}
 
 	// Create a file for the rendered template.
-	destinationFilePath := filepath.Join(
-		tr.DestinationRepo.localPath(), tr.DestinationFolder, fileName)
 	f, err := os.Create(destinationFilePath)
 	if err != nil {
 		return fmt.Errorf("create destionation file: %w", err)
 	}
+	defer f.Close()
 
 	// Render the template to the previously created file.
-	if err := t.Option("missingkey=error").Execute(f, tr.Vars); err != nil {
+	if err := t.Option("missingkey=error").Execute(f, vars); err != nil {
 		return fmt.Errorf("execute template %q: %w", sourceFilePath, err)
 	}
 	return nil
 }
+
+// parseTemplate parses a template and all partials into one template set.
+// Partials are always parsed with the default delimiters.
+func parseTemplate(name, content string, rule renderRule, partials []partial) (*template.Template, error) {
+	t := newTemplate(name).Delims(rule.left, rule.right)
+	if _, err := t.Parse(content); err != nil {
+		return nil, err
+	}
+	for _, p := range partials {
+		if _, err := t.New(p.path).Delims("", "").Parse(p.content); err != nil {
+			return nil, fmt.Errorf("parse partial %q: %w", p.path, err)
+		}
+	}
+	return t, nil
+}
+
+// newTemplate returns a new template with all custom template functions.
+// Templates are text templates, rendered values aren't HTML-escaped (unlike
+// with html/template which was used before partials were supported) unless
+// the values are escaped beforehand (see htmlEscapeValues).
+func newTemplate(name string) *template.Template {
+	t := template.New(name)
+	return t.Funcs(template.FuncMap{
+		// include executes a named template and returns the result
+		// as a string (so it can be piped to other functions).
+		"include": func(name string, data interface{}) (string, error) {
+			var b strings.Builder
+			if err := t.ExecuteTemplate(&b, name, data); err != nil {
+				return "", err
+			}
+			return b.String(), nil
+		},
+		"indent":  indent,
+		"nindent": nindent,
+	})
+}
+
+// htmlEscapeValues returns a copy of the values with all strings
+// HTML-escaped (other values are kept as-is).
+func htmlEscapeValues(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		escaped := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			escaped[k] = htmlEscapeValues(value)
+		}
+		return escaped
+	case []interface{}:
+		escaped := make([]interface{}, len(v))
+		for i, value := range v {
+			escaped[i] = htmlEscapeValues(value)
+		}
+		return escaped
+	case string:
+		return template.HTMLEscapeString(v)
+	default:
+		return v
+	}
+}
+
+// indent prefixes every line of s with the given number of spaces.
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// nindent is the same as indent, but it starts with a new line.
+func nindent(spaces int, s string) string {
+	return "\n" + indent(spaces, s)
+}
+
+// sameFile tells whether two paths point to the same existing file.
+func sameFile(a, b string) bool {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
@@ -17,7 +17,7 @@ var _ renderAllFileser = &renderAllFileserMock{}
 //
 //         // make and configure a mocked renderAllFileser
 //         mockedrenderAllFileser := &renderAllFileserMock{
-//             renderAllFilesFunc: func() error {
+//             renderAllFilesFunc: func() ([]string, error) {
 // 	               panic("mock out the renderAllFiles method")
 //             },
 //         }
@@ -28,7 +28,7 @@ var _ renderAllFileser = &renderAllFileserMock{}
 //     }
 type renderAllFileserMock struct {
 	// renderAllFilesFunc mocks the renderAllFiles method.
-	renderAllFilesFunc func() error
+	renderAllFilesFunc func() ([]string, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -40,7 +40,7 @@ type renderAllFileserMock struct {
 }
 
 // renderAllFiles calls renderAllFilesFunc.
-func (mock *renderAllFileserMock) renderAllFiles() error {
+func (mock *renderAllFileserMock) renderAllFiles() ([]string, error) {
 	if mock.renderAllFilesFunc == nil {
 		panic("renderAllFileserMock.renderAllFilesFunc: method is nil but renderAllFileser.renderAllFiles was just called")
 	}
@@ -1,9 +1,11 @@
 package gitops
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,6 +30,29 @@ dependencies:
 - name: api-service
   version: "0.1.1"
   repository: "https://bitrise-io.github.io/k8s-recipes/"
+`
+	templateNestedValuesYAML = `---
+image: "{{ .image.repository }}:{{ .image.tag }}"
+hosts:
+{{- range .hosts }}
+- {{ . }}
+{{- end }}
+`
+	templateHelpersTPL = `{{ define "name" }}{{ .app }}-{{ .team }}{{ end }}
+{{ define "labels" -}}
+app: {{ .app }}
+team: {{ .team }}
+{{- end }}`
+	templateDeploymentYAML = `---
+metadata:
+  name: {{ template "name" . }}
+  labels:
+    {{- include "labels" . | nindent 4 }}
+spec:
+  template:
+    metadata:
+      labels:
+{{ include "labels" . | indent 8 }}
 `
 )
 
@@ -54,31 +79,88 @@ dependencies:
 - name: api-service
   version: "0.1.1"
   repository: "https://bitrise-io.github.io/k8s-recipes/"
+`
+	renderedNestedValuesYAML = `---
+image: "registry.local:5000/app:v1.2.3"
+hosts:
+- a.example.com
+- b.example.com
+`
+	renderedDeploymentYAML = `---
+metadata:
+  name: my-app-my-team
+  labels:
+    app: my-app
+    team: my-team
+spec:
+  template:
+    metadata:
+      labels:
+        app: my-app
+        team: my-team
 `
 )
 
 var renderAllFilesCases = map[string]struct {
 	templates map[string]string
-	vars      map[string]string
+	partials  map[string]string
+	values    []string
+	renderer  TemplatesRenderer
+	vars      map[string]interface{}
 	folder    string
 	wantFiles map[string]string
 	wantErr   bool
 }{
+	"resources of the kustomization are regenerated": {
+		templates: map[string]string{
+			"kustomization.yaml": "# shop\nresources:\n- ../base # shared\n- old.yaml\n",
+			"service.yaml":       "apiVersion: v1\nkind: Service\n",
+			"deployment.yaml":    "apiVersion: apps/v1\nkind: Deployment\n",
+			"notes.yaml":         "owner: shop\n",
+		},
+		renderer: TemplatesRenderer{KustomizeResources: true},
+		folder:   "kustomized",
+		wantFiles: map[string]string{
+			"kustomization.yaml": "# shop\nresources:\n- ../base # shared\n- deployment.yaml\n- service.yaml\n",
+			"service.yaml":       "apiVersion: v1\nkind: Service\n",
+			"deployment.yaml":    "apiVersion: apps/v1\nkind: Deployment\n",
+			"notes.yaml":         "owner: shop\n",
+		},
+	},
 	"only values.yaml is rendered": {
 		templates: map[string]string{"values.yaml": templateValuesYAML},
-		vars:      map[string]string{"repository": "myrepo", "tag": "mytag"},
+		vars:      map[string]interface{}{"repository": "myrepo", "tag": "mytag"},
 		folder:    "folder-with-values-yaml",
 		wantFiles: map[string]string{"values.yaml": myRenderedValuesYAML},
 	},
 	"values.yaml is rendered with other input": {
 		templates: map[string]string{"values.yaml": templateValuesYAML},
-		vars:      map[string]string{"repository": "foo", "tag": "bar"},
+		vars:      map[string]interface{}{"repository": "foo", "tag": "bar"},
 		folder:    "another-folder-with-values-yaml",
 		wantFiles: map[string]string{"values.yaml": otherRenderedValuesYAML},
 	},
+	"rendered values aren't HTML-escaped": {
+		templates: map[string]string{"values.yaml": "args: {{ .args }}\n"},
+		vars:      map[string]interface{}{"args": `'--host="a&b"' <x>`},
+		folder:    "folder-with-unescaped-values",
+		wantFiles: map[string]string{"values.yaml": "args: '--host=\"a&b\"' <x>\n"},
+	},
+	"rendered values are HTML-escaped if enabled": {
+		templates: map[string]string{
+			"_helpers.tpl": `{{ define "args" }}args: {{ .args }}{{ end }}`,
+			"values.yaml":  "{{ .name }}:\n{{ include \"args\" . | indent 2 }}\n",
+		},
+		renderer: TemplatesRenderer{HTMLEscape: true},
+		vars: map[string]interface{}{
+			"name": "<api>",
+			"args": []interface{}{`--host="a&b"`, 8080},
+		},
+		folder:    "folder-with-escaped-values",
+		wantFiles: map[string]string{"values.yaml": "&lt;api&gt;:\n  args: [--host=&#34;a&amp;b&#34; 8080]\n"},
+	},
 	"only Chart.yaml is rendered": {
 		templates: map[string]string{"Chart.yaml": templateChartYAML},
-		vars:      map[string]string{"appVersion": "2.4.5"},
+		vars:      map[string]interface{}{"appVersion": "2.4.5"},
 		folder:    "folder-with-chart-yaml",
 		wantFiles: map[string]string{"Chart.yaml": renderedChartYAML},
 	},
@@ -87,7 +169,7 @@ var renderAllFilesCases = map[string]struct {
 			"values.yaml": templateValuesYAML,
 			"Chart.yaml":  templateChartYAML,
 		},
-		vars: map[string]string{
+		vars: map[string]interface{}{
 			"repository": "myrepo",
 			"tag":        "mytag",
 			"appVersion": "2.4.5",
@@ -100,13 +182,193 @@ var renderAllFilesCases = map[string]struct {
 	},
 	"an unused template variable is present (it's ignored)": {
 		templates: map[string]string{"Chart.yaml": templateChartYAML},
-		vars:      map[string]string{"appVersion": "2.4.5", "un": "used"},
+		vars:      map[string]interface{}{"appVersion": "2.4.5", "un": "used"},
 		folder:    "folder-with-unused-variables",
 		wantFiles: map[string]string{"Chart.yaml": renderedChartYAML},
 	},
+	"partials next to templates are shared, but not rendered": {
+		templates: map[string]string{
+			"_helpers.tpl":    templateHelpersTPL,
+			"deployment.yaml": templateDeploymentYAML,
+		},
+		vars:      map[string]interface{}{"app": "my-app", "team": "my-team"},
+		folder:    "folder-with-partials",
+		wantFiles: map[string]string{"deployment.yaml": renderedDeploymentYAML},
+	},
+	"partials in a separate folder are shared": {
+		templates: map[string]string{"deployment.yaml": templateDeploymentYAML},
+		partials:  map[string]string{"labels.yaml": templateHelpersTPL},
+		vars:      map[string]interface{}{"app": "my-app", "team": "my-team"},
+		folder:    "folder-with-partials-folder",
+		wantFiles: map[string]string{"deployment.yaml": renderedDeploymentYAML},
+	},
+	"a partial uses a missing template variable (error)": {
+		templates: map[string]string{
+			"_helpers.tpl":    templateHelpersTPL,
+			"deployment.yaml": templateDeploymentYAML,
+		},
+		vars:    map[string]interface{}{"app": "my-app"},
+		folder:  "wont-use-this-folder",
+		wantErr: true,
+	},
+	"nested variables and lists are rendered": {
+		templates: map[string]string{"values.yaml": templateNestedValuesYAML},
+		vars: map[string]interface{}{
+			"image": map[string]interface{}{
+				"repository": "registry.local:5000/app",
+				"tag":        "v1.2.3",
+			},
+			"hosts": []interface{}{"a.example.com", "b.example.com"},
+		},
+		folder:    "folder-with-nested-variables",
+		wantFiles: map[string]string{"values.yaml": renderedNestedValuesYAML},
+	},
+	"values files are deep merged (later wins), inline vars win over them": {
+		templates: map[string]string{"values.yaml": templateNestedValuesYAML},
+		values: []string{
+			"image:\n  repository: registry.local:5000/app\n  tag: common\nhosts: [c.example.com]\n",
+			"image:\n  tag: prod\nhosts: [a.example.com, b.example.com]\n",
+		},
+		vars: map[string]interface{}{
+			"image": map[string]interface{}{"tag": "v1.2.3"},
+		},
+		folder:    "folder-with-values-files",
+		wantFiles: map[string]string{"values.yaml": renderedNestedValuesYAML},
+	},
+	"file and folder names are rendered": {
+		templates: map[string]string{
+			"{{ .app }}-values.yaml":         templateValuesYAML,
+			"apps/{{ .env }}/Chart.yaml":     templateChartYAML,
+			"_helpers.tpl":                   templateHelpersTPL,
+			"static/{{ .env }}-{{ .app }}.x": "static",
+		},
+		vars: map[string]interface{}{
+			"app":        "api",
+			"env":        "prod",
+			"repository": "myrepo",
+			"tag":        "mytag",
+			"appVersion": "2.4.5",
+		},
+		folder: "folder-with-rendered-paths",
+		wantFiles: map[string]string{
+			"api-values.yaml":      myRenderedValuesYAML,
+			"apps/prod/Chart.yaml": renderedChartYAML,
+			"static/prod-api.x":    "static",
+		},
+	},
+	"rendered paths collide (error)": {
+		templates: map[string]string{
+			"{{ .a }}.yaml": "a",
+			"{{ .b }}.yaml": "b",
+		},
+		vars:    map[string]interface{}{"a": "same", "b": "same"},
+		folder:  "wont-use-this-folder",
+		wantErr: true,
+	},
+	"rendered path escapes the destination folder (error)": {
+		templates: map[string]string{"{{ .dir }}/values.yaml": "escaped"},
+		vars:      map[string]interface{}{"dir": "../.."},
+		folder:    "wont-use-this-folder",
+		wantErr:   true,
+	},
+	"rendered path has an empty file name (error)": {
+		templates: map[string]string{"apps/{{ .name }}": "empty"},
+		vars:      map[string]interface{}{"name": ""},
+		folder:    "wont-use-this-folder",
+		wantErr:   true,
+	},
+	"a template variable of a path is missing (error)": {
+		templates: map[string]string{"{{ .app }}.yaml": "app"},
+		vars:      map[string]interface{}{},
+		folder:    "wont-use-this-folder",
+		wantErr:   true,
+	},
+	"suffixed templates are rendered, other files are copied verbatim": {
+		templates: map[string]string{
+			"Chart.yaml.tmpl":                  templateChartYAML,
+			"values.yaml.gotmpl":               templateValuesYAML,
+			"charts/templates/deployment.yaml": "image: {{ .Values.image }}\n",
+		},
+		renderer: TemplatesRenderer{SuffixedTemplatesOnly: true},
+		vars: map[string]interface{}{
+			"repository": "myrepo",
+			"tag":        "mytag",
+			"appVersion": "2.4.5",
+		},
+		folder: "folder-with-suffixed-templates",
+		wantFiles: map[string]string{
+			"Chart.yaml":                       renderedChartYAML,
+			"values.yaml":                      myRenderedValuesYAML,
+			"charts/templates/deployment.yaml": "image: {{ .Values.image }}\n",
+		},
+	},
+	"verbatim patterns and binary files are copied verbatim": {
+		templates: map[string]string{
+			"Chart.yaml":          templateChartYAML,
+			"dashboards/api.json": `{"legend": "{{ instance }}"}`,
+			"logo.png":            "\x89PNG\x00{{ .broken",
+		},
+		renderer: TemplatesRenderer{VerbatimPatterns: []string{"*.json"}},
+		vars:     map[string]interface{}{"appVersion": "2.4.5"},
+		folder:   "folder-with-verbatim-files",
+		wantFiles: map[string]string{
+			"Chart.yaml":          renderedChartYAML,
+			"dashboards/api.json": `{"legend": "{{ instance }}"}`,
+			"logo.png":            "\x89PNG\x00{{ .broken",
+		},
+	},
+	"custom delimiters are used for matching files": {
+		templates: map[string]string{
+			"_helpers.tpl":        templateHelpersTPL,
+			"dashboards/api.json": `{"legend": "{{ instance }}", "title": "[[ template "name" . ]]"}`,
+		},
+		renderer: TemplatesRenderer{Delimiters: []DelimitersRule{
+			{Pattern: "*.json", Left: "[[", Right: "]]"},
+		}},
+		vars:   map[string]interface{}{"app": "api", "team": "core"},
+		folder: "folder-with-custom-delimiters",
+		wantFiles: map[string]string{
+			"dashboards/api.json": `{"legend": "{{ instance }}", "title": "api-core"}`,
+		},
+	},
+	"partials of nested charts aren't shared partials": {
+		templates: map[string]string{
+			"_helpers.tpl":                      templateHelpersTPL,
+			"values.yaml":                       `name: {{ template "name" . }}` + "\n",
+			"charts/api/templates/_helpers.tpl": `{{ define "api.name" }}{{ default .Chart.Name .Values.name }}{{ end }}`,
+		},
+		renderer: TemplatesRenderer{VerbatimPatterns: []string{"charts/*/templates/*"}},
+		vars:     map[string]interface{}{"app": "api", "team": "core"},
+		folder:   "folder-with-nested-chart",
+		wantFiles: map[string]string{
+			"values.yaml":                       "name: api-core\n",
+			"charts/api/templates/_helpers.tpl": `{{ define "api.name" }}{{ default .Chart.Name .Values.name }}{{ end }}`,
+		},
+	},
+	"verbatim partials aren't shared partials": {
+		templates: map[string]string{
+			"_helpers.tpl": `{{ define "name" }}{{ default "x" .name }}{{ end }}`,
+			"values.yaml":  "name: {{ .app }}\n",
+		},
+		renderer: TemplatesRenderer{VerbatimPatterns: []string{"*.tpl"}},
+		vars:     map[string]interface{}{"app": "api"},
+		folder:   "folder-with-verbatim-partial",
+		wantFiles: map[string]string{
+			"_helpers.tpl": `{{ define "name" }}{{ default "x" .name }}{{ end }}`,
+			"values.yaml":  "name: api\n",
+		},
+	},
+	"suffixed files collide with other files (error)": {
+		templates: map[string]string{
+			"values.yaml":      "a",
+			"values.yaml.tmpl": "b",
+		},
+		folder:  "wont-use-this-folder",
+		wantErr: true,
+	},
 	"a template variable is missing (error)": {
 		templates: map[string]string{"Chart.yaml": templateChartYAML},
-		vars:      map[string]string{"appVersionTypo": "2.4.5"},
+		vars:      map[string]interface{}{"appVersionTypo": "2.4.5"},
 		folder:    "wont-use-this-folder",
 		wantErr:   true,
 	},
@@ -131,24 +393,48 @@ func TestRenderAllFiles(t *testing.T) {
 			// Copy desired templates to the previously created temp directory.
 			for fileName, content := range tc.templates {
 				filePath := path.Join(templatesDir, fileName)
-				err := ioutil.WriteFile(filePath, []byte(content), 0600)
+				err := os.MkdirAll(path.Dir(filePath), 0700)
+				require.NoError(t, err, "create folder of template %q", fileName)
+				err = ioutil.WriteFile(filePath, []byte(content), 0600)
 				require.NoError(t, err, "write template %q", fileName)
 			}
 
-			// Run TemplatesRenderer.renderAllFiles.
-			tr := TemplatesRenderer{
-				SourceFolder: templatesDir,
-				Vars:         tc.vars,
-				DestinationRepo: &repositorierMock{
-					localPathFunc: func() string {
-						return renderRepo
-					},
-				},
-				DestinationFolder: tc.folder,
+			// Copy desired partials to a separate temp directory (if any).
+			var partialsDir string
+			if tc.partials != nil {
+				partialsDir, err = ioutil.TempDir("", "")
+				require.NoError(t, err, "new temp partials dir")
+				defer os.RemoveAll(partialsDir)
+			}
+			for fileName, content := range tc.partials {
+				filePath := path.Join(partialsDir, fileName)
+				err := ioutil.WriteFile(filePath, []byte(content), 0600)
+				require.NoError(t, err, "write partial %q", fileName)
+			}
+
+			// Write desired values files to a separate temp directory.
+			valuesDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err, "new temp values dir")
+			defer os.RemoveAll(valuesDir)
+			var valuesFiles []string
+			for i, content := range tc.values {
+				filePath := path.Join(valuesDir, fmt.Sprintf("values-%d.yaml", i))
+				err := ioutil.WriteFile(filePath, []byte(content), 0600)
+				require.NoError(t, err, "write values file %d", i)
+				valuesFiles = append(valuesFiles, filePath)
 			}
 
+			// Run TemplatesRenderer.renderAllFiles.
+			tr := tc.renderer
+			tr.SourceFolder = templatesDir
+			tr.PartialsFolder = partialsDir
+			tr.ValuesFiles = valuesFiles
+			tr.Vars = tc.vars
+			tr.DestinationRoot = renderRepo
+			tr.DestinationFolder = tc.folder
+
 			// Assert for error.
-			gotErr := tr.renderAllFiles()
+			gotRendered, gotErr := tr.renderAllFiles()
 			if tc.wantErr {
 				require.Error(t, gotErr, "templatesRenderer.renderAllFiles")
 				return
@@ -162,14 +448,25 @@ func TestRenderAllFiles(t *testing.T) {
 			}
 
 			var gotFileNames []string
-			gotFileInfos, err := ioutil.ReadDir(renderDir)
-			require.NoError(t, gotErr, "read files of render dir")
-			for _, v := range gotFileInfos {
-				gotFileNames = append(gotFileNames, v.Name())
-			}
+			err = filepath.Walk(renderDir, func(p string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(renderDir, p)
+				gotFileNames = append(gotFileNames, filepath.ToSlash(rel))
+				return err
+			})
+			require.NoError(t, err, "walk files of render dir")
 
 			require.ElementsMatch(t, wantFileNames, gotFileNames, "file names")
 
+			// Assert for returned paths of rendered files (relative to the root).
+			var wantRendered []string
+			for _, name := range wantFileNames {
+				wantRendered = append(wantRendered, path.Join(tc.folder, name))
+			}
+			assert.ElementsMatch(t, wantRendered, gotRendered, "rendered paths")
+
 			// Assert for contents of rendered files.
 			for fileName, want := range tc.wantFiles {
 				filePath := path.Join(renderDir, fileName)
@@ -3,7 +3,9 @@ package gitops
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"strings"
 )
 
 // UpdateFilesParams are parameters for UpdateFiles function.
@@ -14,6 +16,9 @@ type UpdateFilesParams struct {
 	ExportEnv envExporter
 	// Renderer renders templates to a given repository.
 	Renderer renderAllFileser
+	// Validator validates rendered manifests before anything
+	// is committed (optional).
+	Validator manifestValidator
 
 	// PullRequest won't push to the branch. It will open a PR only instead.
 	PullRequest bool
@@ -23,27 +28,135 @@ type UpdateFilesParams struct {
 	PullRequestBody string
 	// CommitMessage is the created commit's message.
 	CommitMessage string
+	// Trailers are appended to the commit message (e.g. to find commits
+	// of a deploy folder when rolling back).
+	Trailers []Trailer
+	// Environments rendered in this run. A summary of changes
+	// per environment is appended to the pull request body.
+	Environments []Environment
+
+	// DryRun only prints the diff of the would-be change. It doesn't commit,
+	// push or open a pull request.
+	DryRun bool
+	// DiffPath is the file the diff is written to in dry-run mode (optional).
+	DiffPath string
+	// SummaryPath is the JSON file the outputs are written to (optional).
+	SummaryPath string
+
+	// ChartVersion bumps the version of charts of changed
+	// deploy folders (optional).
+	ChartVersion chartVersioner
+
+	// History appends the change to the history ledger
+	// in the same commit (optional).
+	History appendHistoryer
+
+	// ArgoSync syncs ArgoCD applications to the pushed commit and waits
+	// until they are healthy (optional). Applications aren't synced in
+	// PR-only mode.
+	ArgoSync argoSyncer
+
+	// Report is the report of the run (optional). Operations of the
+	// repository are recorded if it's wrapped by the report as well
+	// (see Report.Repository).
+	Report *Report
 }
 
 // UpdateFiles updates files in a GitOps repository.
 // It either pushes changes to the given branch directly
 // or opens a pull request for manual approval.
-// URL of the pull request is exported to the
-// PR_URL environment variable in the latter case.
+// In dry-run mode it only prints the diff of the changes.
+// Whether anything changed, the changed files, the commit SHA, the branch,
+// the pull request and the chart versions (if any) are exported as
+// environment variables and written to a JSON summary file on all
+// successful paths.
+// Rendered files and the outcome of the run are added to the report.
 func UpdateFiles(ctx context.Context, p UpdateFilesParams) error {
+	report := p.Report
+	if report == nil {
+		report = NewReport("", nil, p.ExportEnv)
+	}
+	return updateFiles(ctx, p, report.run)
+}
+
+func updateFiles(ctx context.Context, p UpdateFilesParams, report *runReport) error {
 	// Render all templates to the local clone of the repository.
-	if err := p.Renderer.renderAllFiles(); err != nil {
-		return fmt.Errorf("render all files: %w", err)
+	rendered, err := p.Renderer.renderAllFiles()
+	if err != nil {
+		return classify(errorClassRender, fmt.Errorf("render all files: %w", err))
+	}
+	if p.Validator != nil {
+		if err := p.Validator.validateManifests(p.Repo.LocalPath(), rendered); err != nil {
+			return classify(errorClassValidation, fmt.Errorf("validate manifests: %w", err))
+		}
+	}
+	// Chart versions are bumped before anything else looks at the changes.
+	var chartVersions []chartVersion
+	if p.ChartVersion != nil {
+		if chartVersions, err = p.ChartVersion.bumpChartVersions(p.Repo); err != nil {
+			return classify(errorClassRender, fmt.Errorf("bump chart versions: %w", err))
+		}
+	}
+	if err := report.addRenderedFiles(p.Repo.LocalPath(), rendered); err != nil {
+		return classify(errorClassFilesystem, fmt.Errorf("hash rendered files: %w", err))
 	}
 
-	// If rendering the templates didn't cause any changes, we are done here.
-	clean, err := p.Repo.workingDirectoryClean()
+	changes, err := p.Repo.changes()
 	if err != nil {
-		return fmt.Errorf("checking if working directory is clean: %w", err)
+		return fmt.Errorf("changes of working directory: %w", err)
+	}
+	// Changes of the step's metadata only (e.g. a new source commit in the
+	// lock file) don't change the deployment.
+	if metadataOnly(changes) {
+		changes = nil
 	}
-	if clean {
+	// Changes other than rendered templates (e.g. a promotion) are described
+	// in the commit and the pull request.
+	trailers := p.Trailers
+	var description changeDescription
+	if describer, ok := p.Renderer.(changeDescriber); ok && len(changes) > 0 && !p.DryRun {
+		if description, err = describer.describeChange(); err != nil {
+			return fmt.Errorf("describe change: %w", err)
+		}
+		trailers = append(append([]Trailer{}, trailers...), description.trailers...)
+	}
+	// Changes are recorded in the history ledger (if there are any).
+	if p.History != nil && len(changes) > 0 && !p.DryRun {
+		if err := p.History.appendHistory(p.Repo.LocalPath(), report.RenderedFiles, changes); err != nil {
+			return classify(errorClassFilesystem, fmt.Errorf("append history: %w", err))
+		}
+		if changes, err = p.Repo.changes(); err != nil {
+			return fmt.Errorf("changes of working directory: %w", err)
+		}
+	}
+	var out outputs
+	out.setChanges(changes)
+	out.ChartVersions = chartVersions
+
+	// In dry-run mode we are done after showing the diff.
+	if p.DryRun {
+		if err := dryRun(p); err != nil {
+			return err
+		}
+		out.DryRun = true
+		report.Outcome = outcomeDryRun
+		return exportOutputs(p, out, report)
+	}
+
+	// If rendering the templates didn't cause any changes, we are done here.
+	if !out.HasChanges {
 		log.Println("Deployment configuration didn't change, nothing to push.")
-		return nil
+		report.Outcome = outcomeNoOp
+		return exportOutputs(p, out, report)
+	}
+
+	// Summarize changes per environment (before they are committed).
+	prBody := p.PullRequestBody
+	if p.PullRequest && len(p.Environments) > 0 {
+		prBody = appendParagraph(prBody, environmentsSummary(p.Environments, changes))
+	}
+	if p.PullRequest && description.summary != "" {
+		prBody = appendParagraph(prBody, description.summary)
 	}
 
 	if p.PullRequest {
@@ -54,23 +167,108 @@ func UpdateFiles(ctx context.Context, p UpdateFilesParams) error {
 	}
 	// Commit all local changes to the current branch
 	// and push them to the remote repository.
-	if err := p.Repo.gitCommitAndPush(p.CommitMessage); err != nil {
+	if err := p.Repo.gitCommitAndPush(withTrailers(p.CommitMessage, trailers)); err != nil {
 		return fmt.Errorf("git push: %w", err)
 	}
-	// If we aren't running in PR mode, we are done here
-	// (changes were pushed directly to the given branch).
+	// If we aren't running in PR mode, we are done here after syncing
+	// ArgoCD applications (changes were pushed directly to the given branch).
 	if !p.PullRequest {
-		return nil
+		report.Outcome = outcomePushed
+		if err := exportOutputs(p, out, report); err != nil {
+			return err
+		}
+		return syncApplications(ctx, p, report.Outputs.CommitSHA)
 	}
 
 	// Open Github pull request.
-	prURL, err := p.Repo.openPullRequest(ctx, p.PullRequestTitle, p.PullRequestBody)
+	pr, err := p.Repo.openPullRequest(ctx, p.PullRequestTitle, prBody)
 	if err != nil {
 		return fmt.Errorf("open pull request: %w", err)
 	}
-	// Export it's URL as an environment variable (following steps can use it).
-	if err := p.ExportEnv("PR_URL", prURL); err != nil {
-		return fmt.Errorf("export PR_URL env var: %w", err)
+	out.PullRequestURL = pr.url
+	out.PullRequestNumber = pr.number
+	report.Outcome = outcomePullRequest
+	return exportOutputs(p, out, report)
+}
+
+// exportOutputs completes outputs with the current branch and commit of the
+// local clone and exports them (following steps can use them).
+// They are added to the run report as well.
+func exportOutputs(p UpdateFilesParams, out outputs, report *runReport) error {
+	branch, err := p.Repo.currentBranch()
+	if err != nil {
+		return fmt.Errorf("current branch: %w", err)
+	}
+	sha, err := p.Repo.headCommit()
+	if err != nil {
+		return fmt.Errorf("head commit: %w", err)
+	}
+	out.Branch, out.CommitSHA = branch, sha
+	report.Outputs = &out
+	if err := out.export(p.ExportEnv, p.SummaryPath); err != nil {
+		return classify(errorClassExport, fmt.Errorf("export outputs: %w", err))
+	}
+	return nil
+}
+
+// syncApplications syncs ArgoCD applications to the pushed commit
+// (if they are synced by the step).
+func syncApplications(ctx context.Context, p UpdateFilesParams, sha string) error {
+	if p.ArgoSync == nil {
+		return nil
+	}
+	log.Printf("Syncing ArgoCD applications to %s.\n", sha)
+	if err := p.ArgoSync.syncApplications(ctx, sha); err != nil {
+		return classify(errorClassArgoCD, fmt.Errorf("sync argocd applications: %w", err))
+	}
+	return nil
+}
+
+// dryRun prints the diff of the working directory against the branch tip
+// and writes it to a file.
+func dryRun(p UpdateFilesParams) error {
+	diff, err := p.Repo.diff()
+	if err != nil {
+		return fmt.Errorf("diff working directory: %w", err)
+	}
+	if diff != "" {
+		log.Printf("Dry-run, changes aren't pushed:\n%s", diff)
+	} else {
+		log.Println("Dry-run, deployment configuration didn't change.")
+	}
+
+	if p.DiffPath != "" {
+		if err := ioutil.WriteFile(p.DiffPath, []byte(diff), 0644); err != nil {
+			return classify(errorClassFilesystem, fmt.Errorf("write diff file: %w", err))
+		}
 	}
 	return nil
 }
+
+// metadataOnly tells whether all changes are files of history folders.
+func metadataOnly(changes []fileChange) bool {
+	for _, c := range changes {
+		if !isMetadata(c.path) {
+			return false
+		}
+	}
+	return true
+}
+
+// isMetadata tells whether a slash separated path is inside a history folder.
+func isMetadata(path string) bool {
+	for _, name := range strings.Split(path, "/") {
+		if name == historyFolder {
+			return true
+		}
+	}
+	return false
+}
+
+// appendParagraph appends a paragraph to a (possibly empty) markdown text.
+func appendParagraph(text, paragraph string) string {
+	if text == "" {
+		return paragraph
+	}
+	return text + "\n\n" + paragraph
+}
@@ -2,6 +2,11 @@ package gitops
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,12 +20,53 @@ var updateFilesCases = map[string]struct {
 	pullRequestBody  string
 	pullRequestURL   string
 	commitMessage    string
+	environments     []Environment
+	changes          []fileChange
+	wantPRBody       string
+	wantEnvVars      map[string]string
 }{
 	"no changes to commit": {
 		wdClean: true,
+		wantEnvVars: map[string]string{
+			"GITOPS_HAS_CHANGES":    "false",
+			"GITOPS_COMMIT_SHA":     "0123abc",
+			"GITOPS_BRANCH":         "main",
+			"GITOPS_CHANGED_FILES":  "",
+			"GITOPS_ADDED_FILES":    "",
+			"GITOPS_MODIFIED_FILES": "",
+			"GITOPS_DELETED_FILES":  "",
+		},
+	},
+	"only the lock file changed": {
+		wdClean: true,
+		changes: []fileChange{{path: "sample/.gitops/render.lock.yaml", status: fileModified}},
+		wantEnvVars: map[string]string{
+			"GITOPS_HAS_CHANGES":    "false",
+			"GITOPS_COMMIT_SHA":     "0123abc",
+			"GITOPS_BRANCH":         "main",
+			"GITOPS_CHANGED_FILES":  "",
+			"GITOPS_ADDED_FILES":    "",
+			"GITOPS_MODIFIED_FILES": "",
+			"GITOPS_DELETED_FILES":  "",
+		},
 	},
 	"pushing directly to a branch": {
 		commitMessage: "pushing directly to a branch",
+		changes: []fileChange{
+			{path: "new.yaml", status: fileAdded},
+			{path: "values.yaml", status: fileModified},
+			{path: "old.yaml", status: fileDeleted},
+			{path: "other.yaml", status: fileAdded},
+		},
+		wantEnvVars: map[string]string{
+			"GITOPS_HAS_CHANGES":    "true",
+			"GITOPS_COMMIT_SHA":     "0123abc",
+			"GITOPS_BRANCH":         "main",
+			"GITOPS_CHANGED_FILES":  "new.yaml\nvalues.yaml\nold.yaml\nother.yaml",
+			"GITOPS_ADDED_FILES":    "new.yaml\nother.yaml",
+			"GITOPS_MODIFIED_FILES": "values.yaml",
+			"GITOPS_DELETED_FILES":  "old.yaml",
+		},
 	},
 	"opening a pull request": {
 		pullRequest:      true,
@@ -28,6 +74,40 @@ var updateFilesCases = map[string]struct {
 		pullRequestBody:  "my pr body",
 		pullRequestURL:   "https://github.com/foo/bar/pr/1",
 		commitMessage:    "commit to another branch for a pr",
+		changes:          []fileChange{{path: "values.yaml", status: fileModified}},
+		wantPRBody:       "my pr body",
+		wantEnvVars: map[string]string{
+			"GITOPS_HAS_CHANGES":    "true",
+			"GITOPS_COMMIT_SHA":     "0123abc",
+			"GITOPS_BRANCH":         "pr-branch",
+			"GITOPS_CHANGED_FILES":  "values.yaml",
+			"GITOPS_ADDED_FILES":    "",
+			"GITOPS_MODIFIED_FILES": "values.yaml",
+			"GITOPS_DELETED_FILES":  "",
+			"PR_URL":                "https://github.com/foo/bar/pr/1",
+			"GITOPS_PR_NUMBER":      "7",
+		},
+	},
+	"opening a pull request with summary of environments": {
+		pullRequest:      true,
+		pullRequestTitle: "my title",
+		pullRequestBody:  "my pr body",
+		pullRequestURL:   "https://github.com/foo/bar/pr/2",
+		commitMessage:    "commit environments",
+		environments:     []Environment{{Name: "prod", DeployPath: "prod"}},
+		changes:          []fileChange{{path: "prod/values.yaml", status: fileModified}},
+		wantPRBody:       "my pr body\n\n### prod (`prod`)\n- modified `prod/values.yaml`\n",
+		wantEnvVars: map[string]string{
+			"GITOPS_HAS_CHANGES":    "true",
+			"GITOPS_COMMIT_SHA":     "0123abc",
+			"GITOPS_BRANCH":         "pr-branch",
+			"GITOPS_CHANGED_FILES":  "prod/values.yaml",
+			"GITOPS_ADDED_FILES":    "",
+			"GITOPS_MODIFIED_FILES": "prod/values.yaml",
+			"GITOPS_DELETED_FILES":  "",
+			"PR_URL":                "https://github.com/foo/bar/pr/2",
+			"GITOPS_PR_NUMBER":      "7",
+		},
 	},
 }
 
@@ -39,36 +119,47 @@ func TestUpdateFiles(t *testing.T) {
 			var gotCommitMessage string
 			var gotPRTitle, gotPRBody string
 			repo := &repositorierMock{
-				workingDirectoryCleanFunc: func() (bool, error) {
-					return tc.wdClean, nil
+				LocalPathFunc: func() string {
+					return ""
+				},
+				changesFunc: func() ([]fileChange, error) {
+					return tc.changes, nil
 				},
 				gitCheckoutNewBranchFunc: func() error {
 					gotNewBranch = true
 					return nil
 				},
+				currentBranchFunc: func() (string, error) {
+					if gotNewBranch {
+						return "pr-branch", nil
+					}
+					return "main", nil
+				},
+				headCommitFunc: func() (string, error) {
+					return "0123abc", nil
+				},
 				gitCommitAndPushFunc: func(message string) error {
 					gotCommitMessage = message
 					return nil
 				},
-				openPullRequestFunc: func(_ context.Context, title string, body string) (string, error) {
+				openPullRequestFunc: func(_ context.Context, title string, body string) (pullRequest, error) {
 					gotPRTitle = title
 					gotPRBody = body
-					return tc.pullRequestURL, nil
+					return pullRequest{url: tc.pullRequestURL, number: 7}, nil
 				},
 			}
 			// Mock of env exporter function.
-			var gotEnvVarName, gotEnvVarValue string
+			gotEnvVars := map[string]string{}
 			exportEnv := func(name, value string) error {
-				gotEnvVarName = name
-				gotEnvVarValue = value
+				gotEnvVars[name] = value
 				return nil
 			}
 			// Mock of templates renderer.
 			var gotFilesRendered bool
 			renderer := &renderAllFileserMock{
-				renderAllFilesFunc: func() error {
+				renderAllFilesFunc: func() ([]string, error) {
 					gotFilesRendered = true
-					return nil
+					return nil, nil
 				},
 			}
 
@@ -82,10 +173,12 @@ func TestUpdateFiles(t *testing.T) {
 				PullRequestTitle: tc.pullRequestTitle,
 				PullRequestBody:  tc.pullRequestBody,
 				CommitMessage:    tc.commitMessage,
+				Environments:     tc.environments,
 			})
 			require.NoError(t, err, "UpdateFiles")
 
 			assert.True(t, gotFilesRendered, "all files are rendered")
+			assert.Equal(t, tc.wantEnvVars, gotEnvVars, "exported env vars")
 			if tc.wdClean {
 				assert.Empty(t, gotCommitMessage, "didn't commit any changes")
 				return
@@ -96,10 +189,400 @@ func TestUpdateFiles(t *testing.T) {
 				return
 			}
 			assert.True(t, gotNewBranch, "created a new branch")
-			assert.Equal(t, "PR_URL", gotEnvVarName, "PR_URL env var name")
-			assert.Equal(t, tc.pullRequestURL, gotEnvVarValue, "PR_URL value")
 			assert.Equal(t, tc.pullRequestTitle, gotPRTitle, "pr title")
-			assert.Equal(t, tc.pullRequestBody, gotPRBody, "pr body")
+			assert.Equal(t, tc.wantPRBody, gotPRBody, "pr body")
 		})
 	}
 }
+
+var updateFilesDryRunCases = map[string]struct {
+	diff           string
+	changes        []fileChange
+	wantHasChanges string
+	wantChanged    string
+}{
+	"dry-run without changes": {
+		diff:           "",
+		wantHasChanges: "false",
+	},
+	"dry-run with changes": {
+		diff:           "diff --git a/values.yaml b/values.yaml\n-tag: a\n+tag: b\n",
+		changes:        []fileChange{{path: "values.yaml", status: fileModified}},
+		wantHasChanges: "true",
+		wantChanged:    "values.yaml",
+	},
+}
+
+func TestUpdateFilesDryRun(t *testing.T) {
+	for name, tc := range updateFilesDryRunCases {
+		t.Run(name, func(t *testing.T) {
+			diffDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err, "new temp diff dir")
+			defer os.RemoveAll(diffDir)
+			diffPath := path.Join(diffDir, "gitops.diff")
+			summaryPath := path.Join(diffDir, "summary.json")
+
+			// Mock of local repository (it mustn't commit or push anything).
+			repo := &repositorierMock{
+				LocalPathFunc: func() string {
+					return ""
+				},
+				diffFunc: func() (string, error) {
+					return tc.diff, nil
+				},
+				changesFunc: func() ([]fileChange, error) {
+					return tc.changes, nil
+				},
+				currentBranchFunc: func() (string, error) {
+					return "main", nil
+				},
+				headCommitFunc: func() (string, error) {
+					return "0123abc", nil
+				},
+			}
+			// Mock of env exporter function.
+			gotEnvVars := map[string]string{}
+			exportEnv := func(name, value string) error {
+				gotEnvVars[name] = value
+				return nil
+			}
+			// Mock of templates renderer.
+			renderer := &renderAllFileserMock{
+				renderAllFilesFunc: func() ([]string, error) {
+					return nil, nil
+				},
+			}
+
+			err = UpdateFiles(context.Background(), UpdateFilesParams{
+				Repo:          repo,
+				ExportEnv:     exportEnv,
+				Renderer:      renderer,
+				PullRequest:   true,
+				CommitMessage: "won't commit",
+				DryRun:        true,
+				DiffPath:      diffPath,
+				SummaryPath:   summaryPath,
+			})
+			require.NoError(t, err, "UpdateFiles")
+
+			assert.Len(t, renderer.renderAllFilesCalls(), 1, "all files are rendered")
+			assert.Equal(t, map[string]string{
+				"GITOPS_HAS_CHANGES":    tc.wantHasChanges,
+				"GITOPS_COMMIT_SHA":     "0123abc",
+				"GITOPS_BRANCH":         "main",
+				"GITOPS_CHANGED_FILES":  tc.wantChanged,
+				"GITOPS_ADDED_FILES":    "",
+				"GITOPS_MODIFIED_FILES": tc.wantChanged,
+				"GITOPS_DELETED_FILES":  "",
+				"GITOPS_SUMMARY_PATH":   summaryPath,
+			}, gotEnvVars, "exported env vars")
+			gotDiff, err := ioutil.ReadFile(diffPath)
+			require.NoError(t, err, "read diff file")
+			assert.Equal(t, tc.diff, string(gotDiff), "diff file")
+
+			var gotSummary outputs
+			b, err := ioutil.ReadFile(summaryPath)
+			require.NoError(t, err, "read summary file")
+			require.NoError(t, json.Unmarshal(b, &gotSummary), "unmarshal summary")
+			assert.True(t, gotSummary.DryRun, "summary dry-run")
+			assert.Equal(t, tc.wantHasChanges == "true", gotSummary.HasChanges, "summary has changes")
+			assert.Equal(t, "0123abc", gotSummary.CommitSHA, "summary commit sha")
+		})
+	}
+}
+
+func TestUpdateFilesHistory(t *testing.T) {
+	for name, withChanges := range map[string]bool{
+		"history is appended to changes":         true,
+		"history isn't appended without changes": false,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ledger := fileChange{path: "sample/.gitops/history.jsonl", status: fileModified}
+			var changes []fileChange
+			if withChanges {
+				changes = []fileChange{{path: "sample/values.yaml", status: fileModified}}
+			}
+			repo := &repositorierMock{
+				LocalPathFunc: func() string {
+					return ""
+				},
+				changesFunc: func() ([]fileChange, error) {
+					return changes, nil
+				},
+				gitCommitAndPushFunc: func(string) error {
+					return nil
+				},
+				currentBranchFunc: func() (string, error) {
+					return "main", nil
+				},
+				headCommitFunc: func() (string, error) {
+					return "0123abc", nil
+				},
+			}
+			history := &appendHistoryerMock{
+				appendHistoryFunc: func(string, []renderedFile, []fileChange) error {
+					changes = append(changes, ledger)
+					return nil
+				},
+			}
+			gotEnvVars := map[string]string{}
+			err := UpdateFiles(context.Background(), UpdateFilesParams{
+				Repo: repo,
+				ExportEnv: func(name, value string) error {
+					gotEnvVars[name] = value
+					return nil
+				},
+				Renderer: &renderAllFileserMock{
+					renderAllFilesFunc: func() ([]string, error) {
+						return nil, nil
+					},
+				},
+				History: history,
+			})
+			require.NoError(t, err, "UpdateFiles")
+
+			if !withChanges {
+				assert.Empty(t, history.appendHistoryCalls(), "history isn't appended")
+				assert.Empty(t, repo.gitCommitAndPushCalls(), "nothing is pushed")
+				return
+			}
+			assert.Len(t, history.appendHistoryCalls(), 1, "history is appended")
+			assert.Equal(t, "sample/values.yaml\nsample/.gitops/history.jsonl",
+				gotEnvVars["GITOPS_CHANGED_FILES"], "ledger is committed with the changes")
+		})
+	}
+}
+
+func TestUpdateFilesDescribedChange(t *testing.T) {
+	var gotCommitMessage, gotPRBody string
+	repo := &repositorierMock{
+		LocalPathFunc: func() string {
+			return ""
+		},
+		changesFunc: func() ([]fileChange, error) {
+			return []fileChange{{path: "prod/values.yaml", status: fileModified}}, nil
+		},
+		gitCheckoutNewBranchFunc: func() error {
+			return nil
+		},
+		gitCommitAndPushFunc: func(message string) error {
+			gotCommitMessage = message
+			return nil
+		},
+		currentBranchFunc: func() (string, error) {
+			return "pr-branch", nil
+		},
+		headCommitFunc: func() (string, error) {
+			return "0123abc", nil
+		},
+		openPullRequestFunc: func(_ context.Context, _ string, body string) (pullRequest, error) {
+			gotPRBody = body
+			return pullRequest{url: "https://github.com/foo/bar/pull/3", number: 3}, nil
+		},
+	}
+	// Mock of a renderer which describes it's changes.
+	renderer := struct {
+		*renderAllFileserMock
+		*changeDescriberMock
+	}{
+		&renderAllFileserMock{
+			renderAllFilesFunc: func() ([]string, error) {
+				return nil, nil
+			},
+		},
+		&changeDescriberMock{
+			describeChangeFunc: func() (changeDescription, error) {
+				return changeDescription{
+					summary:  "### Promotion",
+					trailers: []Trailer{{"Gitops-Promoted-From", "staging"}},
+				}, nil
+			},
+		},
+	}
+
+	err := UpdateFiles(context.Background(), UpdateFilesParams{
+		Repo: repo,
+		ExportEnv: func(string, string) error {
+			return nil
+		},
+		Renderer:        renderer,
+		PullRequest:     true,
+		PullRequestBody: "my pr body",
+		CommitMessage:   "promote",
+		Trailers:        []Trailer{{"Gitops-Deploy-Folder", "prod"}},
+	})
+	require.NoError(t, err, "UpdateFiles")
+	assert.Equal(t, "promote\n\nGitops-Deploy-Folder: prod\nGitops-Promoted-From: staging",
+		gotCommitMessage, "commit message with trailers")
+	assert.Equal(t, "my pr body\n\n### Promotion", gotPRBody, "pr body with summary")
+}
+
+func TestUpdateFilesChartVersion(t *testing.T) {
+	var renderedBeforeBump bool
+	renderer := &renderAllFileserMock{
+		renderAllFilesFunc: func() ([]string, error) {
+			renderedBeforeBump = true
+			return nil, nil
+		},
+	}
+	chart := &chartVersionerMock{
+		bumpChartVersionsFunc: func(repositorier) ([]chartVersion, error) {
+			require.True(t, renderedBeforeBump, "templates are rendered before the bump")
+			return []chartVersion{{Folder: "prod", Version: "0.1.6"}}, nil
+		},
+	}
+	repo := &repositorierMock{
+		LocalPathFunc: func() string {
+			return ""
+		},
+		changesFunc: func() ([]fileChange, error) {
+			return []fileChange{{path: "prod/Chart.yaml", status: fileModified}}, nil
+		},
+		gitCommitAndPushFunc: func(string) error {
+			return nil
+		},
+		currentBranchFunc: func() (string, error) {
+			return "main", nil
+		},
+		headCommitFunc: func() (string, error) {
+			return "0123abc", nil
+		},
+	}
+	gotEnvVars := map[string]string{}
+	err := UpdateFiles(context.Background(), UpdateFilesParams{
+		Repo: repo,
+		ExportEnv: func(name, value string) error {
+			gotEnvVars[name] = value
+			return nil
+		},
+		Renderer:     renderer,
+		ChartVersion: chart,
+	})
+	require.NoError(t, err, "UpdateFiles")
+	assert.Len(t, chart.bumpChartVersionsCalls(), 1, "chart versions are bumped")
+	assert.Equal(t, "0.1.6", gotEnvVars["GITOPS_CHART_VERSION"], "exported chart version")
+}
+
+func TestUpdateFilesArgoSync(t *testing.T) {
+	for name, tc := range map[string]struct {
+		pullRequest bool
+		syncErr     error
+		wantSynced  bool
+		wantErr     bool
+	}{
+		"pushed commit is synced":       {wantSynced: true},
+		"pull request isn't synced":     {pullRequest: true},
+		"unhealthy application (error)": {syncErr: errors.New("degraded"), wantSynced: true, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			repo := &repositorierMock{
+				LocalPathFunc: func() string {
+					return ""
+				},
+				changesFunc: func() ([]fileChange, error) {
+					return []fileChange{{path: "prod/deployment.yaml", status: fileModified}}, nil
+				},
+				gitCheckoutNewBranchFunc: func() error {
+					return nil
+				},
+				gitCommitAndPushFunc: func(string) error {
+					return nil
+				},
+				openPullRequestFunc: func(context.Context, string, string) (pullRequest, error) {
+					return pullRequest{url: "https://github.com/acme/deploy/pull/1", number: 1}, nil
+				},
+				currentBranchFunc: func() (string, error) {
+					return "main", nil
+				},
+				headCommitFunc: func() (string, error) {
+					return "0123abc", nil
+				},
+			}
+			argo := &argoSyncerMock{
+				syncApplicationsFunc: func(context.Context, string) error {
+					return tc.syncErr
+				},
+			}
+			dir, err := ioutil.TempDir("", "")
+			require.NoError(t, err, "new temp dir")
+			defer os.RemoveAll(dir)
+			reportPath := path.Join(dir, "report.json")
+			exportEnv := func(string, string) error {
+				return nil
+			}
+			report := NewReport(reportPath, nil, exportEnv)
+			err = report.Finish(UpdateFiles(context.Background(), UpdateFilesParams{
+				Repo:      repo,
+				ExportEnv: exportEnv,
+				Renderer: &renderAllFileserMock{
+					renderAllFilesFunc: func() ([]string, error) {
+						return nil, nil
+					},
+				},
+				PullRequest: tc.pullRequest,
+				ArgoSync:    argo,
+				Report:      report,
+			}))
+			if tc.wantErr {
+				require.Error(t, err, "UpdateFiles")
+				b, err := ioutil.ReadFile(reportPath)
+				require.NoError(t, err, "read report")
+				var got runReport
+				require.NoError(t, json.Unmarshal(b, &got), "unmarshal report")
+				assert.Equal(t, errorClassArgoCD, got.ErrorClass, "error class")
+			} else {
+				require.NoError(t, err, "UpdateFiles")
+			}
+			if !tc.wantSynced {
+				assert.Empty(t, argo.syncApplicationsCalls(), "applications aren't synced")
+				return
+			}
+			require.Len(t, argo.syncApplicationsCalls(), 1, "applications are synced")
+			assert.Equal(t, "0123abc", argo.syncApplicationsCalls()[0].Revision, "synced revision")
+		})
+	}
+}
+
+func TestUpdateFilesValidation(t *testing.T) {
+	repo := &repositorierMock{
+		LocalPathFunc: func() string {
+			return "/deploy"
+		},
+	}
+	validator := &manifestValidatorMock{
+		validateManifestsFunc: func(string, []string) error {
+			return manifestErrors{{file: "prod/deployment.yaml", fieldError: fieldError{line: 6, path: "spec.replicas", message: "expected integer, got string"}}}
+		},
+	}
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp dir")
+	defer os.RemoveAll(dir)
+	reportPath := path.Join(dir, "report.json")
+	exportEnv := func(string, string) error {
+		return nil
+	}
+	report := NewReport(reportPath, nil, exportEnv)
+	err = report.Finish(UpdateFiles(context.Background(), UpdateFilesParams{
+		Repo:      repo,
+		ExportEnv: exportEnv,
+		Renderer: &renderAllFileserMock{
+			renderAllFilesFunc: func() ([]string, error) {
+				return []string{"prod/deployment.yaml"}, nil
+			},
+		},
+		Validator: validator,
+		Report:    report,
+	}))
+	require.Error(t, err, "UpdateFiles")
+	assert.Contains(t, err.Error(), "prod/deployment.yaml:6: spec.replicas: expected integer, got string")
+	require.Len(t, validator.validateManifestsCalls(), 1, "manifests are validated")
+	assert.Equal(t, "/deploy", validator.validateManifestsCalls()[0].Root, "root of manifests")
+	assert.Equal(t, []string{"prod/deployment.yaml"}, validator.validateManifestsCalls()[0].Files, "rendered files")
+	assert.Empty(t, repo.gitCommitAndPushCalls(), "nothing is committed")
+
+	b, err := ioutil.ReadFile(reportPath)
+	require.NoError(t, err, "read report")
+	var got runReport
+	require.NoError(t, json.Unmarshal(b, &got), "unmarshal report")
+	assert.Equal(t, errorClassValidation, got.ErrorClass, "error class")
+}
@@ -0,0 +1,350 @@
+package gitops
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate moq -out validate_moq_test.go . manifestValidator
+type manifestValidator interface {
+	// validateManifests validates the Kubernetes manifests of rendered files
+	// (slash separated paths relative to a root folder).
+	validateManifests(root string, files []string) error
+}
+
+// ManifestValidator is an offline structural sanity check of Kubernetes
+// manifests against the bundled schemas (which aren't version specific, see
+// kubernetesDefinitions), removed built-in API versions and schemas of custom
+// resources. Fields of manifests of kinds without schemas aren't validated.
+type ManifestValidator struct {
+	// definitions are schemas by their names.
+	definitions map[string]*jsonSchema
+	// kinds are names of the definitions of kinds (empty if they don't
+	// have a schema).
+	kinds map[groupVersionKind]string
+	// custom are the kinds with schemas of the folder.
+	custom map[groupVersionKind]bool
+}
+
+// ManifestValidator implements the manifestValidator interface.
+var _ manifestValidator = (*ManifestValidator)(nil)
+
+// NewManifestValidator returns a validator of manifests. Schemas of custom
+// resources are loaded from a folder (optional): CustomResourceDefinitions
+// from its YAML files and OpenAPI definitions (e.g. the `swagger.json` of a
+// cluster) from its JSON files. They override the bundled schemas of the
+// same kinds.
+func NewManifestValidator(schemasFolder string) (*ManifestValidator, error) {
+	mv, err := newManifestValidator(schemasFolder)
+	if err != nil {
+		return nil, classify(errorClassValidation, err)
+	}
+	return mv, nil
+}
+
+func newManifestValidator(schemasFolder string) (*ManifestValidator, error) {
+	mv := &ManifestValidator{
+		definitions: map[string]*jsonSchema{},
+		kinds:       map[groupVersionKind]string{},
+		custom:      map[groupVersionKind]bool{},
+	}
+	if err := mv.addDefinitions([]byte(kubernetesDefinitions), false); err != nil {
+		return nil, fmt.Errorf("bundled schemas: %w", err)
+	}
+	if schemasFolder == "" {
+		return mv, nil
+	}
+	if _, err := os.Stat(schemasFolder); err != nil {
+		return nil, fmt.Errorf("schemas folder: %w", err)
+	}
+	files, err := folderFiles(schemasFolder)
+	if err != nil {
+		return nil, fmt.Errorf("read schemas folder: %w", err)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch path.Ext(name) {
+		case ".json":
+			err = mv.addDefinitions(files[name], true)
+		case ".yaml", ".yml":
+			err = mv.addCustomResourceDefinitions(files[name])
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("schema file %s: %w", name, err)
+		}
+	}
+	return mv, nil
+}
+
+// addDefinitions adds the definitions of an OpenAPI v2 (`definitions`) or v3
+// (`components.schemas`) document.
+func (mv *ManifestValidator) addDefinitions(content []byte, custom bool) error {
+	var doc struct {
+		Definitions map[string]*jsonSchema `json:"definitions"`
+		Components  struct {
+			Schemas map[string]*jsonSchema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return fmt.Errorf("parse openapi document: %w", err)
+	}
+	if len(doc.Definitions) == 0 && len(doc.Components.Schemas) == 0 {
+		return fmt.Errorf("openapi document has no definitions")
+	}
+	for _, definitions := range []map[string]*jsonSchema{doc.Definitions, doc.Components.Schemas} {
+		for name, s := range definitions {
+			mv.definitions[name] = s
+			for _, gvk := range s.GroupVersionKinds {
+				mv.kinds[gvk] = name
+				mv.custom[gvk] = custom
+			}
+		}
+	}
+	return nil
+}
+
+// customResourceDefinition is the part of a CustomResourceDefinition
+// which has the schemas of custom resources.
+type customResourceDefinition struct {
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Versions []struct {
+			Name   string `json:"name"`
+			Schema *struct {
+				OpenAPIV3Schema *jsonSchema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+// addCustomResourceDefinitions adds the schemas of all versions of the
+// CustomResourceDefinitions of a YAML file (other documents are ignored).
+func (mv *ManifestValidator) addCustomResourceDefinitions(content []byte) error {
+	docs, err := parseYAMLDocuments(content)
+	if err != nil {
+		return fmt.Errorf("parse yaml: %w", err)
+	}
+	for _, doc := range docs {
+		apiVersion, kind := manifestKind(doc)
+		if kind != "CustomResourceDefinition" || !strings.HasPrefix(apiVersion, "apiextensions.k8s.io/") {
+			continue
+		}
+		// Schemas are JSON, so they are converted before they are parsed.
+		var v interface{}
+		if err := doc.Decode(&v); err != nil {
+			return fmt.Errorf("decode custom resource definition: %w", err)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("convert custom resource definition: %w", err)
+		}
+		var crd customResourceDefinition
+		if err := json.Unmarshal(b, &crd); err != nil {
+			return fmt.Errorf("parse custom resource definition: %w", err)
+		}
+		for _, version := range crd.Spec.Versions {
+			gvk := groupVersionKind{Group: crd.Spec.Group, Version: version.Name, Kind: crd.Spec.Names.Kind}
+			mv.kinds[gvk], mv.custom[gvk] = "", true
+			if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			name := fmt.Sprintf("%s/%s.%s", gvk.Group, gvk.Version, gvk.Kind)
+			mv.definitions[name] = customResourceSchema(version.Schema.OpenAPIV3Schema)
+			mv.kinds[gvk] = name
+		}
+	}
+	return nil
+}
+
+// customResourceSchema returns the schema of custom resources. Their
+// apiVersion, kind and metadata fields are implicit in CRDs.
+func customResourceSchema(s *jsonSchema) *jsonSchema {
+	if len(s.Properties) == 0 {
+		return s
+	}
+	implicit := map[string]*jsonSchema{
+		"apiVersion": {Type: schemaTypes{"string"}},
+		"kind":       {Type: schemaTypes{"string"}},
+		"metadata":   {Ref: "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+	}
+	for name, prop := range implicit {
+		if _, ok := s.Properties[name]; !ok {
+			s.Properties[name] = prop
+		}
+	}
+	return s
+}
+
+// validateManifests validates all documents of rendered YAML files which are
+// Kubernetes manifests (they have apiVersion and kind). Templates of Helm
+// charts and the step's metadata aren't validated.
+func (mv *ManifestValidator) validateManifests(root string, files []string) error {
+	var errs manifestErrors
+	skipped := map[string]bool{}
+	for _, file := range files {
+		ext := path.Ext(file)
+		if ext != ".yaml" && ext != ".yml" || isMetadata(file) || isChartTemplate(root, file) {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			return fmt.Errorf("read %s: %w", file, err)
+		}
+		docs, err := parseYAMLDocuments(content)
+		if err != nil {
+			errs = append(errs, manifestError{file: file, fieldError: fieldError{message: err.Error()}})
+			continue
+		}
+		for _, doc := range docs {
+			for _, e := range mv.validateDocument(doc, skipped) {
+				errs = append(errs, manifestError{file: file, fieldError: e})
+			}
+		}
+	}
+	if len(skipped) > 0 {
+		kinds := make([]string, 0, len(skipped))
+		for kind := range skipped {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		log.Printf("Manifests without schema aren't validated: %s\n", strings.Join(kinds, ", "))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateDocument validates a manifest and records its kind if it doesn't
+// have a schema.
+func (mv *ManifestValidator) validateDocument(doc *yaml.Node, skipped map[string]bool) []fieldError {
+	apiVersion, kind := manifestKind(doc)
+	if apiVersion == "" || kind == "" {
+		return nil
+	}
+	root := doc.Content[0]
+	gvk := groupVersionKind{Version: apiVersion, Kind: kind}
+	if i := strings.Index(apiVersion, "/"); i >= 0 {
+		gvk.Group, gvk.Version = apiVersion[:i], apiVersion[i+1:]
+	}
+	if !mv.custom[gvk] {
+		if err := mv.checkServed(root, apiVersion, kind); err != nil {
+			return []fieldError{*err}
+		}
+	}
+	name, ok := mv.kinds[gvk]
+	if !ok {
+		skipped[apiVersion+" "+kind] = true
+	}
+	if name == "" {
+		return nil
+	}
+	v := schemaValidator{definitions: mv.definitions}
+	v.validate(mv.definitions[name], root, "")
+	return v.errs
+}
+
+// checkServed checks whether a built-in kind is known and isn't removed.
+// Kinds of other API versions aren't checked.
+func (mv *ManifestValidator) checkServed(root *yaml.Node, apiVersion, kind string) *fieldError {
+	knownVersion := false
+	for _, api := range servedAPIs {
+		if api.apiVersion != apiVersion {
+			continue
+		}
+		knownVersion = true
+		if api.kind != kind {
+			continue
+		}
+		if api.removedIn > 0 {
+			node := findYAMLNode(root, []string{"apiVersion"})
+			return &fieldError{line: node.Line, path: "apiVersion",
+				message: fmt.Sprintf("%s %s was removed in Kubernetes 1.%d", apiVersion, kind, api.removedIn)}
+		}
+		return nil
+	}
+	if knownVersion {
+		node := findYAMLNode(root, []string{"kind"})
+		return &fieldError{line: node.Line, path: "kind", message: fmt.Sprintf("unknown kind %q of %s", kind, apiVersion)}
+	}
+	return nil
+}
+
+// manifestKind returns the apiVersion and kind of a manifest
+// (empty if the document isn't a manifest).
+func manifestKind(doc *yaml.Node) (string, string) {
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return "", ""
+	}
+	var apiVersion, kind string
+	if node := findYAMLNode(doc.Content[0], []string{"apiVersion"}); node != nil && node.Kind == yaml.ScalarNode {
+		apiVersion = node.Value
+	}
+	if node := findYAMLNode(doc.Content[0], []string{"kind"}); node != nil && node.Kind == yaml.ScalarNode {
+		kind = node.Value
+	}
+	return apiVersion, kind
+}
+
+// isChartTemplate tells whether a file (slash separated path relative to the
+// root) is in the templates folder of a Helm chart.
+func isChartTemplate(root, file string) bool {
+	names := strings.Split(path.Dir(file), "/")
+	for i, name := range names {
+		if name != "templates" {
+			continue
+		}
+		chart := filepath.Join(root, filepath.FromSlash(path.Join(names[:i]...)), "Chart.yaml")
+		if _, err := os.Stat(chart); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestError is an invalid field of a manifest (or an invalid file).
+type manifestError struct {
+	file string
+	fieldError
+}
+
+// String returns the error in `<file>:<line>: <field path>: <message>` format.
+func (e manifestError) String() string {
+	s := e.file
+	if e.line > 0 {
+		s += fmt.Sprintf(":%d", e.line)
+	}
+	if e.path != "" {
+		s += ": " + e.path
+	}
+	return s + ": " + e.message
+}
+
+// manifestErrors are the errors of all invalid manifests.
+type manifestErrors []manifestError
+
+func (errs manifestErrors) Error() string {
+	lines := []string{fmt.Sprintf("%d errors in manifests:", len(errs))}
+	for _, e := range errs {
+		lines = append(lines, "  "+e.String())
+	}
+	return strings.Join(lines, "\n")
+}
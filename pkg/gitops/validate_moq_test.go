@@ -0,0 +1,79 @@
+// Code generated by moq; DO NOT EDIT.
+// github.com/matryer/moq
+
+package gitops
+
+import (
+	"sync"
+)
+
+// Ensure, that manifestValidatorMock does implement manifestValidator.
+// If this is not the case, regenerate this file with moq.
+var _ manifestValidator = &manifestValidatorMock{}
+
+// manifestValidatorMock is a mock implementation of manifestValidator.
+//
+//     func TestSomethingThatUsesmanifestValidator(t *testing.T) {
+//
+//         // make and configure a mocked manifestValidator
+//         mockedmanifestValidator := &manifestValidatorMock{
+//             validateManifestsFunc: func(root string, files []string) error {
+// 	               panic("mock out the validateManifests method")
+//             },
+//         }
+//
+//         // use mockedmanifestValidator in code that requires manifestValidator
+//         // and then make assertions.
+//
+//     }
+type manifestValidatorMock struct {
+	// validateManifestsFunc mocks the validateManifests method.
+	validateManifestsFunc func(root string, files []string) error
+
+	// calls tracks calls to the methods.
+	calls struct {
+		// validateManifests holds details about calls to the validateManifests method.
+		validateManifests []struct {
+			// Root is the root argument value.
+			Root string
+			// Files is the files argument value.
+			Files []string
+		}
+	}
+	lockvalidateManifests sync.RWMutex
+}
+
+// validateManifests calls validateManifestsFunc.
+func (mock *manifestValidatorMock) validateManifests(root string, files []string) error {
+	if mock.validateManifestsFunc == nil {
+		panic("manifestValidatorMock.validateManifestsFunc: method is nil but manifestValidator.validateManifests was just called")
+	}
+	callInfo := struct {
+		Root  string
+		Files []string
+	}{
+		Root:  root,
+		Files: files,
+	}
+	mock.lockvalidateManifests.Lock()
+	mock.calls.validateManifests = append(mock.calls.validateManifests, callInfo)
+	mock.lockvalidateManifests.Unlock()
+	return mock.validateManifestsFunc(root, files)
+}
+
+// validateManifestsCalls gets all the calls that were made to validateManifests.
+// Check the length with:
+//     len(mockedmanifestValidator.validateManifestsCalls())
+func (mock *manifestValidatorMock) validateManifestsCalls() []struct {
+	Root  string
+	Files []string
+} {
+	var calls []struct {
+		Root  string
+		Files []string
+	}
+	mock.lockvalidateManifests.RLock()
+	calls = mock.calls.validateManifests
+	mock.lockvalidateManifests.RUnlock()
+	return calls
+}
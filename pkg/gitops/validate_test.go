@@ -0,0 +1,267 @@
+package gitops
+
+import (
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  labels:
+    app: api
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: api
+  strategy:
+    rollingUpdate:
+      maxSurge: 25%
+      maxUnavailable: 0
+  template:
+    metadata:
+      creationTimestamp: null
+      labels:
+        app: api
+    spec:
+      containers:
+      - name: api
+        image: acme/api:v1.2.3
+        ports:
+        - containerPort: 8080
+        env:
+        - name: LOG_LEVEL
+          value: debug
+        resources:
+          requests:
+            cpu: 100m
+            memory: 128Mi
+          limits:
+            cpu: 1
+        readinessProbe:
+          httpGet:
+            path: /healthz
+            port: http
+`
+
+const widgetCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.acme.dev
+spec:
+  group: acme.dev
+  names:
+    kind: Widget
+  versions:
+  - name: v1
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            required: [size]
+            properties:
+              size:
+                type: integer
+              color:
+                type: string
+                enum: [red, blue]
+              labels:
+                type: object
+                x-kubernetes-preserve-unknown-fields: true
+`
+
+var validateManifestsCases = map[string]struct {
+	files   map[string]string
+	wantErr []string
+}{
+	"valid manifests": {
+		files: map[string]string{
+			"prod/deployment.yaml": validDeployment,
+			"prod/service.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  selector:
+    app: api
+  ports:
+  - port: 80
+    targetPort: http
+---
+apiVersion: acme.dev/v1
+kind: Widget
+metadata:
+  name: api
+spec:
+  size: 3
+  color: blue
+  labels:
+    any: thing
+---
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+spec:
+  unknown: fields
+`,
+			"prod/values.yaml":               "replicas: 2\n",
+			"prod/Chart.yaml":                "apiVersion: v2\nname: api\nversion: 0.1.0\n",
+			"prod/templates/deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\nspec:\n  replicas: {{ .Values.replicas }}\n",
+			"prod/.gitops/history.yaml":      "entries: []\n",
+			"prod/README.md":                 "# API\n",
+		},
+	},
+	"invalid fields (error)": {
+		files: map[string]string{
+			"prod/deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  replicas: "2"
+  selector:
+    matchLabels:
+      app: api
+  template:
+    spec:
+      containers:
+      - name: api
+        image: acme/api:v1.2.3
+        imagePullPolcy: Always
+        ports:
+        - containerPort: 8080
+          protocol: TCP
+        env:
+        - name: PORT
+          value: 8080
+`,
+			"prod/widget.yaml": "apiVersion: acme.dev/v1\nkind: Widget\nspec:\n  color: green\n",
+		},
+		wantErr: []string{
+			"5 errors in manifests:",
+			"prod/deployment.yaml:6: spec.replicas: expected integer, got string",
+			"prod/deployment.yaml:15: spec.template.spec.containers[0].imagePullPolcy: unknown field",
+			"prod/deployment.yaml:21: spec.template.spec.containers[0].env[0].value: expected string, got integer",
+			"prod/widget.yaml:4: spec.color: value \"green\" isn't one of red, blue",
+			`prod/widget.yaml:4: spec: missing required field "size"`,
+		},
+	},
+	"missing required field (error)": {
+		files:   map[string]string{"prod/service.yaml": "apiVersion: v1\nkind: Service\nspec:\n  ports:\n  - name: http\n"},
+		wantErr: []string{`prod/service.yaml:5: spec.ports[0]: missing required field "port"`},
+	},
+	"removed api version (error)": {
+		files:   map[string]string{"prod/cronjob.yaml": "apiVersion: batch/v1beta1\nkind: CronJob\n"},
+		wantErr: []string{"prod/cronjob.yaml:1: apiVersion: batch/v1beta1 CronJob was removed in Kubernetes 1.25"},
+	},
+	"unknown kind (error)": {
+		files:   map[string]string{"prod/deployment.yaml": "apiVersion: apps/v1\nkind: Deploymnet\n"},
+		wantErr: []string{`prod/deployment.yaml:2: kind: unknown kind "Deploymnet" of apps/v1`},
+	},
+	"invalid yaml (error)": {
+		files:   map[string]string{"prod/deployment.yaml": "apiVersion: apps/v1\nkind: [Deployment\n"},
+		wantErr: []string{"prod/deployment.yaml: yaml: line 1: did not find expected"},
+	},
+}
+
+func TestValidateManifests(t *testing.T) {
+	schemas := templatesDir(t, map[string]string{
+		"crds/widgets.yaml": widgetCRD,
+		"README.md":         "CRDs of the cluster.\n",
+	})
+	defer os.RemoveAll(schemas)
+
+	for name, tc := range validateManifestsCases {
+		t.Run(name, func(t *testing.T) {
+			root := templatesDir(t, tc.files)
+			defer os.RemoveAll(root)
+			mv, err := NewManifestValidator(schemas)
+			require.NoError(t, err, "NewManifestValidator")
+
+			var files []string
+			for file := range tc.files {
+				files = append(files, file)
+			}
+			sort.Strings(files)
+			err = mv.validateManifests(root, files)
+			if len(tc.wantErr) > 0 {
+				require.Error(t, err, "validateManifests")
+				for _, want := range tc.wantErr {
+					assert.Contains(t, err.Error(), want)
+				}
+				return
+			}
+			require.NoError(t, err, "validateManifests")
+		})
+	}
+}
+
+func TestNewManifestValidator(t *testing.T) {
+	dir := templatesDir(t, map[string]string{
+		"swagger.json": `{"definitions": {"io.acme.v1.Gadget": {
+  "type": "object",
+  "properties": {"apiVersion": {"type": "string"}, "kind": {"type": "string"}, "size": {"type": "integer"}},
+  "x-kubernetes-group-version-kind": [{"group": "acme.dev", "kind": "Gadget", "version": "v1"}]
+}}}`,
+	})
+	defer os.RemoveAll(dir)
+	mv, err := NewManifestValidator(dir)
+	require.NoError(t, err, "openapi definitions")
+	write(t, path.Join(dir, "gadget.yaml"), "apiVersion: acme.dev/v1\nkind: Gadget\nsize: large\n")
+	err = mv.validateManifests(dir, []string{"gadget.yaml"})
+	require.Error(t, err, "invalid gadget")
+	assert.Contains(t, err.Error(), "gadget.yaml:3: size: expected integer, got string")
+
+	write(t, path.Join(dir, "invalid.json"), `{"components": {}}`)
+	_, err = NewManifestValidator(dir)
+	require.Error(t, err, "json without definitions")
+
+	_, err = NewManifestValidator(path.Join(dir, "missing"))
+	require.Error(t, err, "missing schemas folder")
+}
+
+func TestBundledKinds(t *testing.T) {
+	mv, err := NewManifestValidator("")
+	require.NoError(t, err, "NewManifestValidator")
+
+	// Kinds with bundled schemas are listed by the docs of validate_manifests.
+	var got []string
+	for gvk, name := range mv.kinds {
+		if name != "" {
+			got = append(got, gvk.apiVersion()+" "+gvk.Kind)
+		}
+	}
+	sort.Strings(got)
+	assert.Equal(t, []string{
+		"apps/v1 DaemonSet",
+		"apps/v1 Deployment",
+		"apps/v1 ReplicaSet",
+		"apps/v1 StatefulSet",
+		"autoscaling/v1 HorizontalPodAutoscaler",
+		"autoscaling/v2 HorizontalPodAutoscaler",
+		"batch/v1 CronJob",
+		"batch/v1 Job",
+		"networking.k8s.io/v1 Ingress",
+		"networking.k8s.io/v1 NetworkPolicy",
+		"policy/v1 PodDisruptionBudget",
+		"rbac.authorization.k8s.io/v1 ClusterRole",
+		"rbac.authorization.k8s.io/v1 ClusterRoleBinding",
+		"rbac.authorization.k8s.io/v1 Role",
+		"rbac.authorization.k8s.io/v1 RoleBinding",
+		"v1 ConfigMap",
+		"v1 Namespace",
+		"v1 PersistentVolumeClaim",
+		"v1 Pod",
+		"v1 Secret",
+		"v1 Service",
+		"v1 ServiceAccount",
+	}, got)
+}
@@ -0,0 +1,105 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseVars returns variables deserialized from a given string.
+// It can be a YAML or JSON document (JSON is a subset of YAML) or the
+// legacy `map[key:value ...]` format of an unstructured input map.
+func parseVars(s string) (map[string]interface{}, error) {
+	if strings.TrimSpace(s) == "" {
+		return map[string]interface{}{}, nil
+	}
+	// Legacy format: flat map of strings.
+	if strings.HasPrefix(s, "map[") {
+		vars := map[string]interface{}{}
+		for k, v := range parseMap(s) {
+			vars[k] = v
+		}
+		return vars, nil
+	}
+
+	var vars map[string]interface{}
+	if err := yaml.Unmarshal([]byte(s), &vars); err != nil {
+		return nil, fmt.Errorf("unmarshal yaml: %w", err)
+	}
+	if vars == nil {
+		vars = map[string]interface{}{}
+	}
+	return vars, nil
+}
+
+// readVarsFile returns variables read from a YAML or JSON file.
+func readVarsFile(path string) (map[string]interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	vars, err := parseVars(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("parse %q: %w", path, err)
+	}
+	return vars, nil
+}
+
+// valuesLayer is a named source of variables.
+type valuesLayer struct {
+	name   string
+	values map[string]interface{}
+}
+
+// mergeValues deep merges layers of variables. Later layers win: maps are
+// merged key-by-key, all other values (including lists) are replaced and
+// null values delete the key (like Helm does).
+// It also returns the name of the layer which supplied each final key
+// (keys of nested maps are joined with dots).
+func mergeValues(layers []valuesLayer) (map[string]interface{}, map[string]string) {
+	merged := map[string]interface{}{}
+	origins := map[string]string{}
+	for _, l := range layers {
+		mergeInto(merged, l.values, "", l.name, origins)
+	}
+	return merged, origins
+}
+
+func mergeInto(dst, src map[string]interface{}, prefix, layer string, origins map[string]string) {
+	for k, v := range src {
+		keyPath := prefix + k
+		// Null values delete the key (and all keys below it).
+		if v == nil {
+			delete(dst, k)
+			deleteOrigins(origins, keyPath)
+			continue
+		}
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeInto(dstMap, srcMap, keyPath+".", layer, origins)
+			continue
+		}
+		// Value is replaced, so are it's origins.
+		deleteOrigins(origins, keyPath)
+		if srcIsMap {
+			dstMap = map[string]interface{}{}
+			mergeInto(dstMap, srcMap, keyPath+".", layer, origins)
+			dst[k] = dstMap
+			continue
+		}
+		dst[k] = v
+		origins[keyPath] = layer
+	}
+}
+
+// deleteOrigins deletes origins of a key and all keys below it.
+func deleteOrigins(origins map[string]string, keyPath string) {
+	for k := range origins {
+		if k == keyPath || strings.HasPrefix(k, keyPath+".") {
+			delete(origins, k)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// CheckVarsParams are parameters for CheckVars function.
+type CheckVarsParams struct {
+	// Templates to check variables of.
+	Templates TemplatesRenderer
+	// Environments to check variables of (if templates are rendered
+	// to multiple environments).
+	Environments []Environment
+	// FailOnUnused treats provided but unused variables as errors.
+	FailOnUnused bool
+}
+
+// CheckVars analyses all templates (without rendering them) and reports all
+// referenced, missing and provided but unused variables. It fails if any
+// variable is missing (or unused in strict mode).
+func CheckVars(p CheckVarsParams) error {
+	renderers := map[string]TemplatesRenderer{"": p.Templates}
+	if len(p.Environments) > 0 {
+		er := EnvironmentsRenderer{Templates: p.Templates, Environments: p.Environments}
+		renderers = map[string]TemplatesRenderer{}
+		for _, env := range p.Environments {
+			renderers[fmt.Sprintf("environment %q: ", env.Name)] = er.renderer(env)
+		}
+	}
+
+	prefixes := make([]string, 0, len(renderers))
+	for prefix := range renderers {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	var failures []string
+	for _, prefix := range prefixes {
+		tr := renderers[prefix]
+		report, err := tr.checkVars()
+		if err != nil {
+			return classify(errorClassRender, fmt.Errorf("%scheck vars: %w", prefix, err))
+		}
+		log.Printf("%sReferenced variables: %s\n", prefix, joinOrNone(report.referenced))
+		log.Printf("%sMissing variables: %s\n", prefix, joinOrNone(report.missing))
+		log.Printf("%sUnused variables: %s\n", prefix, joinOrNone(report.unused))
+		if len(report.missing) > 0 {
+			failures = append(failures, fmt.Sprintf("%smissing variables: %s",
+				prefix, strings.Join(report.missing, ", ")))
+		}
+		if p.FailOnUnused && len(report.unused) > 0 {
+			failures = append(failures, fmt.Sprintf("%sunused variables: %s",
+				prefix, strings.Join(report.unused, ", ")))
+		}
+	}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return classify(errorClassRender, fmt.Errorf("%s", strings.Join(failures, "; ")))
+	}
+	return nil
+}
+
+func joinOrNone(a []string) string {
+	if len(a) == 0 {
+		return "none"
+	}
+	return strings.Join(a, ", ")
+}
+
+// varsReport is the result of analysing variables of templates.
+// Variables are dot separated key paths (e.g. image.tag).
+type varsReport struct {
+	referenced []string
+	missing    []string
+	unused     []string
+}
+
+// checkVars analyses variables of all templates, path templates and the
+// partials they call.
+func (tr TemplatesRenderer) checkVars() (varsReport, error) {
+	files, partialPaths, err := tr.sourceFiles()
+	if err != nil {
+		return varsReport{}, fmt.Errorf("source files in %q: %w", tr.SourceFolder, err)
+	}
+	partials, err := readPartials(partialPaths)
+	if err != nil {
+		return varsReport{}, fmt.Errorf("read partials: %w", err)
+	}
+	vars, _, err := tr.mergedValues()
+	if err != nil {
+		return varsReport{}, fmt.Errorf("values: %w", err)
+	}
+
+	refs := map[string]bool{}
+	for _, file := range files {
+		// File and folder names can be templates as well.
+		if err := collectRefs(file, trimTemplateSuffix(file), renderRule{}, nil, refs); err != nil {
+			return varsReport{}, fmt.Errorf("path %q: %w", file, err)
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(tr.SourceFolder, filepath.FromSlash(file)))
+		if err != nil {
+			return varsReport{}, fmt.Errorf("read file %q: %w", file, err)
+		}
+		rule := tr.renderRule(file, content)
+		if rule.verbatim {
+			continue
+		}
+		// Partials are analysed where they are called (with the variables
+		// they are called with).
+		if err := collectRefs(file, string(content), rule, partials, refs); err != nil {
+			return varsReport{}, fmt.Errorf("template %q: %w", file, err)
+		}
+	}
+
+	var report varsReport
+	for ref := range refs {
+		report.referenced = append(report.referenced, ref)
+		if ref != "" && !hasKeyPath(vars, ref) {
+			report.missing = append(report.missing, ref)
+		}
+	}
+	for _, leaf := range leafKeyPaths(vars, "") {
+		if !isReferenced(leaf, refs) {
+			report.unused = append(report.unused, leaf)
+		}
+	}
+	// The whole root (referenced as `.`) isn't listed as a variable.
+	report.referenced = removeString(report.referenced, "")
+	sort.Strings(report.referenced)
+	sort.Strings(report.missing)
+	sort.Strings(report.unused)
+	return report, nil
+}
+
+// collectRefs collects key paths of root variables referenced by a template
+// and the named templates (defined in it or in partials) it calls. An empty
+// key path means the whole root.
+func collectRefs(name, content string, rule renderRule, partials []partial, refs map[string]bool) error {
+	t, err := parseTemplate(name, content, rule, partials)
+	if err != nil {
+		return err
+	}
+	c := refsCollector{templates: t, refs: refs, root: []string{}, walked: map[string]bool{}}
+	c.walk(t.Tree.Root, []string{})
+	return nil
+}
+
+// refsCollector collects referenced key paths of root variables.
+type refsCollector struct {
+	// templates are the named templates which can be called.
+	templates *template.Template
+	refs      map[string]bool
+	// root is the key path of $ (the data the template is called with).
+	root []string
+	// walked are the named templates already walked (by their name and
+	// the key path of the dot they are called with).
+	walked map[string]bool
+}
+
+// walk walks a parse tree and collects referenced key paths of root
+// variables. Dot is the key path of the current dot (nil if it's unknown,
+// e.g. inside a range).
+func (c refsCollector) walk(node parse.Node, dot []string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(child, dot)
+		}
+	case *parse.ActionNode:
+		c.walk(n.Pipe, dot)
+	case *parse.IfNode:
+		c.walk(n.Pipe, dot)
+		c.walk(n.List, dot)
+		c.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		c.walk(n.Pipe, dot)
+		c.walk(n.List, pipeKeyPath(n.Pipe, dot))
+		c.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		c.walk(n.Pipe, dot)
+		c.walk(n.List, nil)
+		c.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		if n.Pipe == nil {
+			return
+		}
+		if len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			c.walkCalled(n.Name, n.Pipe.Cmds[0].Args[0], dot)
+		}
+		if !isDot(n.Pipe) {
+			c.walk(n.Pipe, dot)
+		}
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			c.walk(cmd, dot)
+		}
+	case *parse.CommandNode:
+		// Index commands with constant keys reference a key path.
+		if keyPath := indexKeyPath(n, dot, c.root); keyPath != nil {
+			c.refs[joinKeyPath(keyPath...)] = true
+			return
+		}
+		if len(n.Args) == 3 && isIdentifier(n.Args[0], "include") {
+			if s, ok := n.Args[1].(*parse.StringNode); ok {
+				c.walkCalled(s.Text, n.Args[2], dot)
+			}
+			// The dot passed to included templates isn't a reference.
+			if _, ok := n.Args[2].(*parse.DotNode); ok {
+				return
+			}
+		}
+		for _, arg := range n.Args {
+			c.walk(arg, dot)
+		}
+	case *parse.DotNode:
+		if dot != nil {
+			c.refs[joinKeyPath(dot...)] = true
+		}
+	case *parse.FieldNode:
+		if dot != nil {
+			c.refs[joinKeyPath(append(append([]string{}, dot...), n.Ident...)...)] = true
+		}
+	case *parse.VariableNode:
+		// $ is the data the template is called with.
+		if len(n.Ident) > 1 && n.Ident[0] == "$" && c.root != nil {
+			c.refs[joinKeyPath(append(append([]string{}, c.root...), n.Ident[1:]...)...)] = true
+		}
+	case *parse.ChainNode:
+		c.walk(n.Node, dot)
+	}
+}
+
+// walkCalled walks a named template called (by template or include) with
+// an argument. Only templates called with dot or $ are walked, the dot of
+// templates called with anything else (e.g. `.image`) is unknown, so their
+// references can't be resolved.
+func (c refsCollector) walkCalled(name string, arg parse.Node, dot []string) {
+	var calledDot []string
+	switch n := arg.(type) {
+	case *parse.DotNode:
+		calledDot = dot
+	case *parse.VariableNode:
+		if len(n.Ident) == 1 && n.Ident[0] == "$" {
+			calledDot = c.root
+		}
+	}
+	if calledDot == nil {
+		return
+	}
+	key := name + "\x00" + strings.Join(calledDot, ".")
+	called := c.templates.Lookup(name)
+	if c.walked[key] || called == nil || called.Tree == nil {
+		return
+	}
+	c.walked[key] = true
+	sub := c
+	sub.root = calledDot
+	sub.walk(called.Tree.Root, calledDot)
+}
+
+// pipeKeyPath returns the key path of a pipeline which is a single field
+// (e.g. `.image`), otherwise nil.
+func pipeKeyPath(pipe *parse.PipeNode, dot []string) []string {
+	if dot == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	switch n := pipe.Cmds[0].Args[0].(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return append(append([]string{}, dot...), n.Ident...)
+	}
+	return nil
+}
+
+// indexKeyPath returns the key path of an index command with constant string
+// keys (e.g. `index . "api-service" "image"`), otherwise nil. Root is the
+// key path of $.
+func indexKeyPath(cmd *parse.CommandNode, dot, root []string) []string {
+	if len(cmd.Args) < 3 || !isIdentifier(cmd.Args[0], "index") {
+		return nil
+	}
+	keyPath := dot
+	switch n := cmd.Args[1].(type) {
+	case *parse.DotNode:
+	case *parse.FieldNode:
+		if keyPath != nil {
+			keyPath = append(append([]string{}, keyPath...), n.Ident...)
+		}
+	case *parse.VariableNode:
+		if len(n.Ident) == 0 || n.Ident[0] != "$" || root == nil {
+			return nil
+		}
+		keyPath = append(append([]string{}, root...), n.Ident[1:]...)
+	default:
+		return nil
+	}
+	if keyPath == nil {
+		return nil
+	}
+	keyPath = append([]string{}, keyPath...)
+	for _, arg := range cmd.Args[2:] {
+		s, ok := arg.(*parse.StringNode)
+		if !ok {
+			return nil
+		}
+		keyPath = append(keyPath, s.Text)
+	}
+	return keyPath
+}
+
+// isDot tells whether a pipeline is a single dot.
+func isDot(pipe *parse.PipeNode) bool {
+	if len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	_, ok := pipe.Cmds[0].Args[0].(*parse.DotNode)
+	return ok
+}
+
+func isIdentifier(node parse.Node, name string) bool {
+	ident, ok := node.(*parse.IdentifierNode)
+	return ok && ident.Ident == name
+}
+
+// hasKeyPath tells whether a dot separated key path (see parseKeyPath)
+// exists in variables. Key paths going below a non-map value (e.g. a list)
+// are accepted.
+func hasKeyPath(vars map[string]interface{}, keyPath string) bool {
+	keys, err := parseKeyPath(keyPath)
+	if err != nil {
+		return false
+	}
+	m := vars
+	for _, key := range keys {
+		v, ok := m[key]
+		if !ok {
+			return false
+		}
+		next, isMap := v.(map[string]interface{})
+		if !isMap {
+			return true
+		}
+		m = next
+	}
+	return true
+}
+
+// leafKeyPaths returns dot separated key paths (see joinKeyPath)
+// of all non-map values.
+func leafKeyPaths(vars map[string]interface{}, prefix string) []string {
+	var leaves []string
+	for k, v := range vars {
+		if m, ok := v.(map[string]interface{}); ok && len(m) > 0 {
+			leaves = append(leaves, leafKeyPaths(m, prefix+joinKeyPath(k)+".")...)
+			continue
+		}
+		leaves = append(leaves, prefix+joinKeyPath(k))
+	}
+	return leaves
+}
+
+// isReferenced tells whether a key path is referenced directly
+// or through one of it's parents or children.
+func isReferenced(keyPath string, refs map[string]bool) bool {
+	for ref := range refs {
+		if ref == "" || ref == keyPath ||
+			strings.HasPrefix(keyPath, ref+".") || strings.HasPrefix(ref, keyPath+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(a []string, s string) []string {
+	var result []string
+	for _, v := range a {
+		if v != s {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var checkVarsCases = map[string]struct {
+	templates map[string]string
+	renderer  TemplatesRenderer
+	vars      map[string]interface{}
+	want      varsReport
+}{
+	"all variables are provided and used": {
+		templates: map[string]string{
+			"values.yaml": templateValuesYAML,
+			"Chart.yaml":  templateChartYAML,
+		},
+		vars: map[string]interface{}{
+			"repository": "myrepo",
+			"tag":        "mytag",
+			"appVersion": "2.4.5",
+		},
+		want: varsReport{
+			referenced: []string{"appVersion", "repository", "tag"},
+		},
+	},
+	"all missing and unused variables are reported": {
+		templates: map[string]string{
+			"values.yaml": templateValuesYAML,
+			"Chart.yaml":  templateChartYAML,
+		},
+		vars: map[string]interface{}{
+			"repository":     "myrepo",
+			"appVersionTypo": "2.4.5",
+			"extra":          map[string]interface{}{"a": 1, "b": 2},
+		},
+		want: varsReport{
+			referenced: []string{"appVersion", "repository", "tag"},
+			missing:    []string{"appVersion", "tag"},
+			unused:     []string{"appVersionTypo", "extra.a", "extra.b"},
+		},
+	},
+	"nested variables, with, range, index and root variables": {
+		templates: map[string]string{
+			"values.yaml": `{{ .image.repository }}
+{{ with .resources }}{{ .cpu }}{{ end }}
+{{ range .hosts }}{{ .name }}{{ $.domain }}{{ end }}
+{{ index . "api-service" "port" }}`,
+		},
+		vars: map[string]interface{}{
+			"image": map[string]interface{}{
+				"repository": "myrepo",
+				"tag":        "unused",
+			},
+			"resources":   map[string]interface{}{"cpu": 1},
+			"hosts":       []interface{}{map[string]interface{}{"name": "a"}},
+			"api-service": map[string]interface{}{"port": 80},
+		},
+		want: varsReport{
+			referenced: []string{"api-service.port", "domain", "hosts", "image.repository", "resources", "resources.cpu"},
+			missing:    []string{"domain"},
+			unused:     []string{"image.tag"},
+		},
+	},
+	"partials, included templates and paths are analysed": {
+		templates: map[string]string{
+			"_helpers.tpl":           templateHelpersTPL,
+			"{{ .env }}/deploy.yaml": templateDeploymentYAML,
+		},
+		vars: map[string]interface{}{"app": "api", "env": "prod"},
+		want: varsReport{
+			referenced: []string{"app", "env", "team"},
+			missing:    []string{"team"},
+		},
+	},
+	"partials called with other variables are not analysed": {
+		templates: map[string]string{
+			"_image.tpl": `{{ define "img" }}{{ .repository }}:{{ .tag }}{{ end }}
+{{ define "root" }}{{ $.env }}{{ end }}`,
+			"values.yaml": `image: {{ template "img" .image }}
+{{ with .deploy }}{{ template "root" $ }}{{ include "img" . }}{{ end }}`,
+		},
+		vars: map[string]interface{}{
+			"image":  map[string]interface{}{"repository": "r", "tag": "t"},
+			"deploy": map[string]interface{}{"repository": "r"},
+			"env":    "prod",
+		},
+		want: varsReport{
+			referenced: []string{"deploy", "deploy.repository", "deploy.tag", "env", "image"},
+			missing:    []string{"deploy.tag"},
+		},
+	},
+	"verbatim files are not analysed, custom delimiters are used": {
+		templates: map[string]string{
+			"dashboard.json": `{"legend": "{{ instance }}", "title": "[[ .title ]]"}`,
+			"chart.yaml":     `{{ .Values.image }}`,
+		},
+		renderer: TemplatesRenderer{
+			VerbatimPatterns: []string{"chart.yaml"},
+			Delimiters:       []DelimitersRule{{Pattern: "*.json", Left: "[[", Right: "]]"}},
+		},
+		vars: map[string]interface{}{"title": "api"},
+		want: varsReport{
+			referenced: []string{"title"},
+		},
+	},
+	"whole root is used": {
+		templates: map[string]string{"values.json": `{{ printf "%v" . }}`},
+		vars:      map[string]interface{}{"a": 1},
+		want:      varsReport{},
+	},
+}
+
+func TestCheckVars(t *testing.T) {
+	for name, tc := range checkVarsCases {
+		t.Run(name, func(t *testing.T) {
+			templatesDir := templatesDir(t, tc.templates)
+			defer os.RemoveAll(templatesDir)
+
+			tr := tc.renderer
+			tr.SourceFolder = templatesDir
+			tr.Vars = tc.vars
+			got, err := tr.checkVars()
+			require.NoError(t, err, "checkVars")
+			assert.Equal(t, tc.want, got)
+
+			// Missing variables always fail, unused ones in strict mode only.
+			wantErr := len(tc.want.missing) > 0
+			err = CheckVars(CheckVarsParams{Templates: tr})
+			assert.Equal(t, wantErr, err != nil, "CheckVars error: %v", err)
+
+			wantErr = wantErr || len(tc.want.unused) > 0
+			err = CheckVars(CheckVarsParams{Templates: tr, FailOnUnused: true})
+			assert.Equal(t, wantErr, err != nil, "CheckVars with FailOnUnused error: %v", err)
+		})
+	}
+}
+
+func TestCheckVarsOfEnvironments(t *testing.T) {
+	templatesDir := templatesDir(t, map[string]string{"values.yaml": templateValuesYAML})
+	defer os.RemoveAll(templatesDir)
+
+	p := CheckVarsParams{
+		Templates: TemplatesRenderer{
+			SourceFolder: templatesDir,
+			Vars:         map[string]interface{}{"repository": "myrepo"},
+		},
+		Environments: []Environment{
+			{Name: "staging", DeployPath: "staging", Vars: map[string]interface{}{"tag": "a"}},
+			{Name: "prod", DeployPath: "prod"},
+		},
+	}
+	err := CheckVars(p)
+	require.Error(t, err, "CheckVars")
+	assert.Contains(t, err.Error(), `environment "prod": missing variables: tag`)
+	assert.NotContains(t, err.Error(), "staging")
+}
+
+// templatesDir writes templates to a new temporary directory.
+func templatesDir(t *testing.T, templates map[string]string) string {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err, "new temp templates dir")
+	for fileName, content := range templates {
+		filePath := path.Join(dir, fileName)
+		require.NoError(t, os.MkdirAll(path.Dir(filePath), 0700), "create folder of %q", fileName)
+		write(t, filePath, content)
+	}
+	return dir
+}
@@ -0,0 +1,143 @@
+package gitops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var parseVarsCases = map[string]struct {
+	s       string
+	want    map[string]interface{}
+	wantErr bool
+}{
+	"empty input": {
+		s:    "",
+		want: map[string]interface{}{},
+	},
+	"legacy map format": {
+		s: "map[repository:my repo tag:my-tag]",
+		want: map[string]interface{}{
+			"repository": "my repo",
+			"tag":        "my-tag",
+		},
+	},
+	"yaml with colons in values": {
+		s: "repository: registry.local:5000/app\ntag: my-tag\n",
+		want: map[string]interface{}{
+			"repository": "registry.local:5000/app",
+			"tag":        "my-tag",
+		},
+	},
+	"yaml with nested maps and lists": {
+		s: "image:\n  tag: v1\nhosts:\n- a.example.com\n- b.example.com\n",
+		want: map[string]interface{}{
+			"image": map[string]interface{}{"tag": "v1"},
+			"hosts": []interface{}{"a.example.com", "b.example.com"},
+		},
+	},
+	"json": {
+		s: `{"image": {"tag": "v1"}, "replicas": 3}`,
+		want: map[string]interface{}{
+			"image":    map[string]interface{}{"tag": "v1"},
+			"replicas": 3,
+		},
+	},
+	"not a map (error)": {
+		s:       "- a\n- b\n",
+		wantErr: true,
+	},
+}
+
+func TestParseVars(t *testing.T) {
+	for name, tc := range parseVarsCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := parseVars(tc.s)
+			if tc.wantErr {
+				require.Error(t, err, "parseVars")
+				return
+			}
+			require.NoError(t, err, "parseVars")
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+var mergeValuesCases = map[string]struct {
+	layers      []valuesLayer
+	want        map[string]interface{}
+	wantOrigins map[string]string
+}{
+	"later layers win and nested maps are merged": {
+		layers: []valuesLayer{
+			{name: "common", values: map[string]interface{}{
+				"image":    map[string]interface{}{"repository": "app", "tag": "v1"},
+				"replicas": 1,
+			}},
+			{name: "prod", values: map[string]interface{}{
+				"image":    map[string]interface{}{"tag": "v2"},
+				"replicas": 3,
+			}},
+		},
+		want: map[string]interface{}{
+			"image":    map[string]interface{}{"repository": "app", "tag": "v2"},
+			"replicas": 3,
+		},
+		wantOrigins: map[string]string{
+			"image.repository": "common",
+			"image.tag":        "prod",
+			"replicas":         "prod",
+		},
+	},
+	"lists are replaced": {
+		layers: []valuesLayer{
+			{name: "common", values: map[string]interface{}{
+				"hosts": []interface{}{"a", "b"},
+			}},
+			{name: "prod", values: map[string]interface{}{
+				"hosts": []interface{}{"c"},
+			}},
+		},
+		want: map[string]interface{}{
+			"hosts": []interface{}{"c"},
+		},
+		wantOrigins: map[string]string{"hosts": "prod"},
+	},
+	"null deletes a key": {
+		layers: []valuesLayer{
+			{name: "common", values: map[string]interface{}{
+				"image":    map[string]interface{}{"tag": "v1"},
+				"replicas": 1,
+			}},
+			{name: "prod", values: map[string]interface{}{
+				"image": nil,
+			}},
+		},
+		want:        map[string]interface{}{"replicas": 1},
+		wantOrigins: map[string]string{"replicas": "common"},
+	},
+	"a map replaces a scalar": {
+		layers: []valuesLayer{
+			{name: "common", values: map[string]interface{}{
+				"image": "app:v1",
+			}},
+			{name: "prod", values: map[string]interface{}{
+				"image": map[string]interface{}{"tag": "v2"},
+			}},
+		},
+		want: map[string]interface{}{
+			"image": map[string]interface{}{"tag": "v2"},
+		},
+		wantOrigins: map[string]string{"image.tag": "prod"},
+	},
+}
+
+func TestMergeValues(t *testing.T) {
+	for name, tc := range mergeValuesCases {
+		t.Run(name, func(t *testing.T) {
+			got, gotOrigins := mergeValues(tc.layers)
+			require.Equal(t, tc.want, got, "merged values")
+			require.Equal(t, tc.wantOrigins, gotOrigins, "origins")
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package gitops
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// VerifyParams are parameters for Verify function.
+type VerifyParams struct {
+	// Renderer renders templates to the rendered root folder and updates
+	// (or generates) other files like in gitops mode.
+	Renderer renderAllFileser
+	// RenderedRoot is the (temporary) root folder templates are rendered to.
+	// Files of the deploy repository outside of the deploy folders are
+	// copied to it before rendering.
+	RenderedRoot string
+	// RepoRoot is the root folder of the local clone of the deploy repository.
+	RepoRoot string
+	// Folders are the deploy folders (relative to the roots) to compare.
+	// Other files are compared only if the renderer changed them.
+	Folders []string
+	// FailOnDrift fails if the deploy repository drifted, otherwise it only warns.
+	FailOnDrift bool
+	// IgnoreChartVersion ignores the version of charts (Chart.yaml),
+	// which is bumped by the step instead of rendered.
+	IgnoreChartVersion bool
+	// ExportEnv is an environment variable exporter.
+	ExportEnv envExporter
+}
+
+// drift is a file of the deploy repository which differs from the rendered one.
+type drift struct {
+	// Slash separated path of the file relative to the root folders.
+	path string
+	// Kind of the drift.
+	kind driftKind
+}
+
+// driftKind is the kind of a drifted file.
+type driftKind string
+
+// Possible kinds of a drifted file.
+const (
+	// driftModified files have different content in the deploy repository.
+	driftModified driftKind = "modified"
+	// driftMissing files are rendered, but missing from the deploy repository.
+	driftMissing driftKind = "missing"
+	// driftExtra files are in the deploy repository, but aren't rendered.
+	driftExtra driftKind = "extra"
+)
+
+// Verify renders templates and compares them with the deploy folders of the
+// deploy repository. It reports every file which drifted from what CI would
+// render (e.g. because it was edited by hand). Whether a drift was detected
+// is exported to the GITOPS_DRIFT_DETECTED environment variable.
+func Verify(p VerifyParams) error {
+	// Files outside of the deploy folders are copied, so they are updated
+	// (e.g. ArgoCD applications) the same way as in the deploy repository.
+	if err := copyFiles(p.RepoRoot, p.RenderedRoot, p.Folders); err != nil {
+		return fmt.Errorf("copy deploy repository: %w", err)
+	}
+	files, err := p.Renderer.renderAllFiles()
+	if err != nil {
+		return fmt.Errorf("render all files: %w", err)
+	}
+
+	var drifts []drift
+	for _, file := range files {
+		if inAnyFolder(file, p.Folders) {
+			continue
+		}
+		d, ok, err := compareFile(p.RenderedRoot, p.RepoRoot, file)
+		if err != nil {
+			return fmt.Errorf("compare file %q: %w", file, err)
+		}
+		if ok {
+			drifts = append(drifts, d)
+		}
+	}
+	for _, folder := range p.Folders {
+		if p.IgnoreChartVersion {
+			if err := keepChartVersion(p.RenderedRoot, p.RepoRoot, folder); err != nil {
+				return fmt.Errorf("chart version of folder %q: %w", folder, err)
+			}
+		}
+		folderDrifts, err := compareFolders(
+			filepath.Join(p.RenderedRoot, folder), filepath.Join(p.RepoRoot, folder))
+		if err != nil {
+			return fmt.Errorf("compare folder %q: %w", folder, err)
+		}
+		for _, d := range folderDrifts {
+			d.path = filepath.ToSlash(filepath.Join(folder, d.path))
+			drifts = append(drifts, d)
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].path < drifts[j].path
+	})
+
+	driftDetected := len(drifts) > 0
+	if err := p.ExportEnv("GITOPS_DRIFT_DETECTED", strconv.FormatBool(driftDetected)); err != nil {
+		return fmt.Errorf("export GITOPS_DRIFT_DETECTED env var: %w", err)
+	}
+	if !driftDetected {
+		log.Println("Deploy repository matches the rendered templates.")
+		return nil
+	}
+
+	log.Println("Deploy repository drifted from the rendered templates:")
+	for _, d := range drifts {
+		log.Printf("- %s: %s\n", d.kind, d.path)
+	}
+	if p.FailOnDrift {
+		return fmt.Errorf("%d file(s) drifted from the rendered templates", len(drifts))
+	}
+	log.Println("warning: drift detected, but it's ignored.")
+	return nil
+}
+
+// RecordedRenderer renders the templates of deploy folders with the
+// variables and render settings recorded by their lock files in the deploy
+// repository (instead of the inputs), so only changes of the deploy folders
+// are drifts. Masked variables are taken from the inputs. Deploy folders
+// without a lock file are rendered with the inputs.
+type RecordedRenderer struct {
+	// Templates is the renderer of templates with the inputs.
+	Templates TemplatesRenderer
+	// Environments to render the templates to (optional).
+	Environments []Environment
+	// RepoRoot is the root folder of the local clone of the deploy repository.
+	RepoRoot string
+}
+
+// RecordedRenderer implements the renderAllFileser interface.
+var _ renderAllFileser = (*RecordedRenderer)(nil)
+
+func (rr RecordedRenderer) renderAllFiles() ([]string, error) {
+	renderers := []TemplatesRenderer{rr.Templates}
+	if len(rr.Environments) > 0 {
+		er := EnvironmentsRenderer{Templates: rr.Templates, Environments: rr.Environments}
+		renderers = nil
+		for _, env := range rr.Environments {
+			renderers = append(renderers, er.renderer(env))
+		}
+	}
+	var rendered []string
+	for _, tr := range renderers {
+		recorded, err := rr.recorded(tr)
+		if err != nil {
+			return nil, fmt.Errorf("deploy folder %q: %w", tr.DestinationFolder, err)
+		}
+		files, err := recorded.renderAllFiles()
+		if err != nil {
+			return nil, fmt.Errorf("deploy folder %q: %w", tr.DestinationFolder, err)
+		}
+		rendered = append(rendered, files...)
+	}
+	return rendered, nil
+}
+
+// recorded returns the renderer of a deploy folder with the variables and
+// render settings of its lock file (if it has one).
+func (rr RecordedRenderer) recorded(tr TemplatesRenderer) (TemplatesRenderer, error) {
+	lockPath := filepath.Join(rr.RepoRoot, tr.DestinationFolder, historyFolder, lockFile)
+	if _, err := os.Stat(lockPath); os.IsNotExist(err) {
+		log.Printf("Deploy folder %s has no lock file, it's rendered with the inputs.\n", tr.DestinationFolder)
+		return tr, nil
+	}
+	lock, err := readLock(lockPath)
+	if err != nil {
+		return TemplatesRenderer{}, fmt.Errorf("read lock: %w", err)
+	}
+	inputs, _, err := tr.mergedValues()
+	if err != nil {
+		return TemplatesRenderer{}, fmt.Errorf("values: %w", err)
+	}
+	vars, err := lockedVars(lock, inputs)
+	if err != nil {
+		return TemplatesRenderer{}, err
+	}
+	tr.ValuesFiles, tr.VarsFile, tr.Vars = nil, "", vars
+	tr.EnvironmentValuesFiles, tr.EnvironmentVars = nil, nil
+	tr.SuffixedTemplatesOnly = lock.SuffixedTemplatesOnly
+	tr.VerbatimPatterns = lock.VerbatimPatterns
+	tr.Delimiters = lock.Delimiters
+	tr.HTMLEscape = lock.HTMLEscape
+	return tr, nil
+}
+
+// copyFiles copies all files of a folder to another one, except files of
+// the skipped folders (relative to the folder), Git's own files and the
+// step's metadata.
+func copyFiles(from, to string, skipped []string) error {
+	files, err := folderFiles(from)
+	if err != nil {
+		return err
+	}
+	for file, content := range files {
+		if inAnyFolder(file, skipped) {
+			continue
+		}
+		filePath := filepath.Join(to, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("create folder of %q: %w", file, err)
+		}
+		if err := ioutil.WriteFile(filePath, content, 0644); err != nil {
+			return fmt.Errorf("write %q: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// compareFile compares a rendered file with the actual one, and returns
+// its drift (if it drifted).
+func compareFile(renderedRoot, actualRoot, file string) (drift, bool, error) {
+	rendered, renderedErr := ioutil.ReadFile(filepath.Join(renderedRoot, filepath.FromSlash(file)))
+	actual, actualErr := ioutil.ReadFile(filepath.Join(actualRoot, filepath.FromSlash(file)))
+	for _, err := range []error{renderedErr, actualErr} {
+		if err != nil && !os.IsNotExist(err) {
+			return drift{}, false, err
+		}
+	}
+	switch {
+	case os.IsNotExist(renderedErr) && os.IsNotExist(actualErr):
+		return drift{}, false, nil
+	case os.IsNotExist(renderedErr):
+		return drift{path: file, kind: driftExtra}, true, nil
+	case os.IsNotExist(actualErr):
+		return drift{path: file, kind: driftMissing}, true, nil
+	case !bytes.Equal(rendered, actual):
+		return drift{path: file, kind: driftModified}, true, nil
+	}
+	return drift{}, false, nil
+}
+
+// inAnyFolder tells whether a slash separated path is inside any of the
+// folders.
+func inAnyFolder(path string, folders []string) bool {
+	for _, folder := range folders {
+		if inFolder(path, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepChartVersion sets the version of a rendered chart to the version of
+// the actual one (if both of them exist).
+func keepChartVersion(renderedRoot, actualRoot, folder string) error {
+	file := filepath.ToSlash(filepath.Join(folder, chartFile))
+	rendered, err := ioutil.ReadFile(filepath.Join(renderedRoot, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	actual, err := ioutil.ReadFile(filepath.Join(actualRoot, file))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	version, err := lookupYAMLScalar(actual, []string{"version"})
+	if err != nil {
+		return fmt.Errorf("version of %s: %w", file, err)
+	}
+	return setChartVersion(renderedRoot, file, rendered, version)
+}
+
+// compareFolders compares files of a rendered folder with an actual folder
+// and returns all drifts sorted by path (relative to the folders).
+func compareFolders(rendered, actual string) ([]drift, error) {
+	renderedFiles, err := folderFiles(rendered)
+	if err != nil {
+		return nil, fmt.Errorf("files of rendered folder: %w", err)
+	}
+	actualFiles, err := folderFiles(actual)
+	if err != nil {
+		return nil, fmt.Errorf("files of actual folder: %w", err)
+	}
+
+	var drifts []drift
+	for path, want := range renderedFiles {
+		got, ok := actualFiles[path]
+		switch {
+		case !ok:
+			drifts = append(drifts, drift{path: path, kind: driftMissing})
+		case !bytes.Equal(want, got):
+			drifts = append(drifts, drift{path: path, kind: driftModified})
+		}
+	}
+	for path := range actualFiles {
+		if _, ok := renderedFiles[path]; !ok {
+			drifts = append(drifts, drift{path: path, kind: driftExtra})
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].path < drifts[j].path
+	})
+	return drifts, nil
+}
+
+// folderFiles returns contents of all files in a folder (walking subfolders as
+// well) by their slash separated path. A missing folder has no files.
+func folderFiles(folder string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	if _, err := os.Stat(folder); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// Git's own files and the step's metadata (e.g. the history
+			// ledger) aren't part of the rendered templates.
+			if info.Name() == ".git" || info.Name() == historyFolder {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(folder, path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,225 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var verifyCases = map[string]struct {
+	rendered           map[string]string
+	edited             []string
+	actual             map[string]string
+	failOnDrift        bool
+	ignoreChartVersion bool
+	wantDrift          bool
+	wantErr            bool
+}{
+	"deploy folder matches the rendered templates": {
+		rendered: map[string]string{"sample/values.yaml": "tag: a\n"},
+		actual: map[string]string{
+			"sample/values.yaml": "tag: a\n",
+			"other/values.yaml":  "not compared\n",
+		},
+		failOnDrift: true,
+	},
+	"history ledger isn't a drift": {
+		rendered: map[string]string{"sample/values.yaml": "tag: a\n"},
+		actual: map[string]string{
+			"sample/values.yaml":           "tag: a\n",
+			"sample/.gitops/history.jsonl": "{}\n",
+		},
+		failOnDrift: true,
+	},
+	"bumped chart version isn't a drift": {
+		rendered:           map[string]string{"sample/Chart.yaml": "version: 0.1.0\nappVersion: v2\n"},
+		actual:             map[string]string{"sample/Chart.yaml": "version: 0.1.7\nappVersion: v2\n"},
+		failOnDrift:        true,
+		ignoreChartVersion: true,
+	},
+	"chart version drifted": {
+		rendered:  map[string]string{"sample/Chart.yaml": "version: 0.1.0\nappVersion: v2\n"},
+		actual:    map[string]string{"sample/Chart.yaml": "version: 0.1.7\nappVersion: v2\n"},
+		wantDrift: true,
+	},
+	"deploy folder drifted (warning only)": {
+		rendered:  map[string]string{"sample/values.yaml": "tag: a\n"},
+		actual:    map[string]string{"sample/values.yaml": "tag: hand-edited\n"},
+		wantDrift: true,
+	},
+	"edited file outside of the deploy folder drifted (error)": {
+		rendered: map[string]string{
+			"sample/values.yaml": "tag: a\n",
+			"apps/sample.yaml":   "path: sample\n",
+		},
+		edited: []string{"sample/values.yaml", "apps/sample.yaml"},
+		actual: map[string]string{
+			"sample/values.yaml": "tag: a\n",
+			"apps/sample.yaml":   "path: hand-edited\n",
+		},
+		failOnDrift: true,
+		wantDrift:   true,
+		wantErr:     true,
+	},
+	"edited file outside of the deploy folder matches": {
+		rendered: map[string]string{
+			"sample/values.yaml": "tag: a\n",
+			"apps/sample.yaml":   "path: sample\n",
+		},
+		edited: []string{"sample/values.yaml", "apps/sample.yaml"},
+		actual: map[string]string{
+			"sample/values.yaml": "tag: a\n",
+			"apps/sample.yaml":   "path: sample\n",
+			"apps/other.yaml":    "path: other\n",
+		},
+		failOnDrift: true,
+	},
+	"deploy folder drifted (error)": {
+		rendered:    map[string]string{"sample/values.yaml": "tag: a\n"},
+		actual:      map[string]string{"sample/extra.yaml": "extra\n"},
+		failOnDrift: true,
+		wantDrift:   true,
+		wantErr:     true,
+	},
+}
+
+func TestVerify(t *testing.T) {
+	for name, tc := range verifyCases {
+		t.Run(name, func(t *testing.T) {
+			renderedRoot := templatesDir(t, nil)
+			defer os.RemoveAll(renderedRoot)
+			repoRoot := templatesDir(t, tc.actual)
+			defer os.RemoveAll(repoRoot)
+
+			// Mock of templates renderer.
+			renderer := &renderAllFileserMock{
+				renderAllFilesFunc: func() ([]string, error) {
+					for fileName, content := range tc.rendered {
+						filePath := path.Join(renderedRoot, fileName)
+						require.NoError(t, os.MkdirAll(path.Dir(filePath), 0700))
+						write(t, filePath, content)
+					}
+					return tc.edited, nil
+				},
+			}
+			// Mock of env exporter function.
+			gotEnvVars := map[string]string{}
+			exportEnv := func(name, value string) error {
+				gotEnvVars[name] = value
+				return nil
+			}
+
+			err := Verify(VerifyParams{
+				Renderer:           renderer,
+				RenderedRoot:       renderedRoot,
+				RepoRoot:           repoRoot,
+				Folders:            []string{"sample"},
+				FailOnDrift:        tc.failOnDrift,
+				ExportEnv:          exportEnv,
+				IgnoreChartVersion: tc.ignoreChartVersion,
+			})
+			if tc.wantErr {
+				require.Error(t, err, "Verify")
+			} else {
+				require.NoError(t, err, "Verify")
+			}
+			wantDrift := "false"
+			if tc.wantDrift {
+				wantDrift = "true"
+			}
+			assert.Equal(t, map[string]string{
+				"GITOPS_DRIFT_DETECTED": wantDrift,
+			}, gotEnvVars, "exported env vars")
+		})
+	}
+}
+
+func TestCompareFolders(t *testing.T) {
+	rendered := templatesDir(t, map[string]string{
+		"same.yaml":        "same",
+		"modified.yaml":    "rendered",
+		"missing.yaml":     "missing",
+		"sub/missing.yaml": "missing",
+	})
+	defer os.RemoveAll(rendered)
+	actual := templatesDir(t, map[string]string{
+		"same.yaml":     "same",
+		"modified.yaml": "hand-edited",
+		"sub/extra":     "extra",
+		".git/HEAD":     "not compared",
+	})
+	defer os.RemoveAll(actual)
+
+	got, err := compareFolders(rendered, actual)
+	require.NoError(t, err, "compareFolders")
+	assert.Equal(t, []drift{
+		{path: "missing.yaml", kind: driftMissing},
+		{path: "modified.yaml", kind: driftModified},
+		{path: "sub/extra", kind: driftExtra},
+		{path: "sub/missing.yaml", kind: driftMissing},
+	}, got)
+
+	// Missing actual folder means all files are missing.
+	got, err = compareFolders(rendered, path.Join(actual, "missing-folder"))
+	require.NoError(t, err, "compareFolders with missing folder")
+	assert.Len(t, got, 4)
+}
+
+func TestRecordedRenderer(t *testing.T) {
+	templates := templatesDir(t, map[string]string{
+		"values.yaml": templateValuesYAML,
+		"secret.yaml": "token: {{ .db_token }}\n",
+	})
+	defer os.RemoveAll(templates)
+	repoRoot := templatesDir(t, map[string]string{
+		"staging/.gitops/render.lock.yaml": `version: dev
+deploy_folder: staging
+templates: {}
+vars:
+  repository: myrepo
+  tag: mytag
+  db_token: REDACTED
+masked_vars:
+- db_token
+`,
+	})
+	defer os.RemoveAll(repoRoot)
+	renderedRoot := templatesDir(t, nil)
+	defer os.RemoveAll(renderedRoot)
+
+	rr := RecordedRenderer{
+		Templates: TemplatesRenderer{
+			SourceFolder:    templates,
+			Vars:            map[string]interface{}{"repository": "foo", "tag": "bar", "db_token": "s3cret"},
+			DestinationRoot: renderedRoot,
+		},
+		Environments: []Environment{
+			{Name: "staging", DeployPath: "staging"},
+			{Name: "prod", DeployPath: "prod"},
+		},
+		RepoRoot: repoRoot,
+	}
+	files, err := rr.renderAllFiles()
+	require.NoError(t, err, "renderAllFiles")
+	assert.ElementsMatch(t, []string{"staging/values.yaml", "staging/secret.yaml", "prod/values.yaml", "prod/secret.yaml"}, files)
+
+	// Recorded variables win over the inputs, masked ones are the inputs.
+	for file, want := range map[string]string{
+		"staging/values.yaml": myRenderedValuesYAML,
+		"staging/secret.yaml": "token: s3cret\n",
+		"prod/values.yaml":    otherRenderedValuesYAML,
+	} {
+		got, err := ioutil.ReadFile(path.Join(renderedRoot, file))
+		require.NoError(t, err, "read %s", file)
+		assert.Equal(t, want, string(got), file)
+	}
+
+	delete(rr.Templates.Vars, "db_token")
+	_, err = rr.renderAllFiles()
+	require.Error(t, err, "masked variable isn't given")
+	assert.Contains(t, err.Error(), "masked variables must be given in vars: db_token")
+}
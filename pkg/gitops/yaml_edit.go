@@ -0,0 +1,650 @@
+package gitops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAML files are edited in place: only the text of the edited values is
+// replaced, so comments, key order, anchors, formatting and all other
+// documents of the file are preserved (the diff is minimal).
+
+// parseKeyPath parses a dot separated key path. Numeric keys index lists,
+// dots of keys are escaped by a backslash (e.g. `app\.kubernetes\.io/name`).
+func parseKeyPath(s string) ([]string, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty key path")
+	}
+	var keyPath []string
+	var key strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '.':
+			key.WriteByte('.')
+			i++
+		case s[i] == '.':
+			keyPath = append(keyPath, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(s[i])
+		}
+	}
+	keyPath = append(keyPath, key.String())
+	for _, key := range keyPath {
+		if key == "" {
+			return nil, fmt.Errorf("key path %q has an empty key", s)
+		}
+	}
+	return keyPath, nil
+}
+
+// joinKeyPath returns a dot separated key path of keys with their dots
+// escaped (the reverse of parseKeyPath).
+func joinKeyPath(keys ...string) string {
+	escaped := make([]string, len(keys))
+	for i, key := range keys {
+		escaped[i] = strings.Replace(key, ".", `\.`, -1)
+	}
+	return strings.Join(escaped, ".")
+}
+
+// parseYAMLDocuments returns all documents of a (multi-document) YAML file.
+func parseYAMLDocuments(content []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+// findYAMLNode returns the node at a key path (following aliases)
+// or nil if there isn't any.
+func findYAMLNode(node *yaml.Node, keyPath []string) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return findYAMLNode(node.Content[0], keyPath)
+	}
+	if node.Kind == yaml.AliasNode {
+		return findYAMLNode(node.Alias, keyPath)
+	}
+	if len(keyPath) == 0 {
+		return node
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == keyPath[0] {
+				return findYAMLNode(node.Content[i+1], keyPath[1:])
+			}
+		}
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(keyPath[0])
+		if err == nil && i >= 0 && i < len(node.Content) {
+			return findYAMLNode(node.Content[i], keyPath[1:])
+		}
+	}
+	return nil
+}
+
+// lookupYAMLScalar returns the value of a scalar at a key path
+// in the first document which has it.
+func lookupYAMLScalar(content []byte, keyPath []string) (string, error) {
+	docs, err := parseYAMLDocuments(content)
+	if err != nil {
+		return "", fmt.Errorf("parse yaml: %w", err)
+	}
+	for _, doc := range docs {
+		node := findYAMLNode(doc, keyPath)
+		if node == nil {
+			continue
+		}
+		if node.Kind != yaml.ScalarNode {
+			return "", fmt.Errorf("%s isn't a scalar value", strings.Join(keyPath, "."))
+		}
+		return node.Value, nil
+	}
+	return "", fmt.Errorf("%s not found", strings.Join(keyPath, "."))
+}
+
+// setYAMLScalar sets the scalar at a key path in every document which has it
+// (values of anchors are edited, so all aliases follow them). The new value
+// keeps the type of the old one if it can, otherwise it's a string.
+func setYAMLScalar(content []byte, keyPath []string, value string) ([]byte, error) {
+	return setYAMLScalarOf(content, nil, keyPath, value)
+}
+
+// setYAMLScalarOf is the same as setYAMLScalar, but it sets the scalar of
+// a given document only (if the index isn't nil).
+func setYAMLScalarOf(content []byte, document *int, keyPath []string, value string) ([]byte, error) {
+	docs, err := parseYAMLDocuments(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	if document != nil {
+		if *document >= len(docs) {
+			return nil, fmt.Errorf("document #%d not found (there are %d)", *document, len(docs))
+		}
+		docs = docs[*document : *document+1]
+	}
+	var nodes []*yaml.Node
+	for _, doc := range docs {
+		if node := findYAMLNode(doc, keyPath); node != nil {
+			if node.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s isn't a scalar value", strings.Join(keyPath, "."))
+			}
+			nodes = append(nodes, node)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("%s not found", strings.Join(keyPath, "."))
+	}
+
+	// Replace values from the end, so positions of earlier ones don't move.
+	lines := splitLines(content)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if lines, err = replaceScalar(lines, nodes[i], value); err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(keyPath, "."), err)
+		}
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// splitLines splits content to lines keeping their line breaks.
+func splitLines(content []byte) [][]byte {
+	return bytes.SplitAfter(content, []byte("\n"))
+}
+
+// replaceScalar replaces the text of a single line scalar node.
+func replaceScalar(lines [][]byte, node *yaml.Node, value string) ([][]byte, error) {
+	if node.Line < 1 || node.Line > len(lines) {
+		return nil, fmt.Errorf("line %d is out of range", node.Line)
+	}
+	line := []rune(string(lines[node.Line-1]))
+	start := node.Column - 1
+	if start < 0 || start > len(line) {
+		return nil, fmt.Errorf("column %d is out of range", node.Column)
+	}
+	// Position of the node is the position of it's anchor or tag (if any).
+	for start < len(line) && (line[start] == '&' || line[start] == '!') {
+		for start < len(line) && line[start] != ' ' && line[start] != '\t' {
+			start++
+		}
+		for start < len(line) && (line[start] == ' ' || line[start] == '\t') {
+			start++
+		}
+	}
+	end, err := scalarEnd(line, start, node)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	text, err := scalarText(node, value)
+	if err != nil {
+		return nil, err
+	}
+	edited := string(line[:start]) + text + string(line[end:])
+	lines[node.Line-1] = []byte(edited)
+	return lines, nil
+}
+
+// scalarEnd returns the end of a scalar token starting at a given position
+// of a line. Only single line scalars are supported.
+func scalarEnd(line []rune, start int, node *yaml.Node) (int, error) {
+	switch {
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return 0, fmt.Errorf("block scalars aren't supported")
+	default:
+		value := []rune(node.Value)
+		if len(line) >= start+len(value) && string(line[start:start+len(value)]) == node.Value {
+			return start + len(value), nil
+		}
+	}
+	return 0, fmt.Errorf("multi-line scalars aren't supported")
+}
+
+// scalarText returns the YAML text of a new scalar value in the style of an
+// old one. The value keeps the type of the old one if it resolves to it,
+// otherwise it's quoted (so it's a string).
+func scalarText(old *yaml.Node, value string) (string, error) {
+	tag := "!!str"
+	if old.ShortTag() != "!!str" && resolveTag(value) == old.ShortTag() {
+		tag = old.ShortTag()
+	}
+	node := &yaml.Node{
+		Kind:  yaml.ScalarNode,
+		Tag:   tag,
+		Value: value,
+		Style: old.Style & (yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle),
+	}
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return "", fmt.Errorf("marshal value: %w", err)
+	}
+	text := strings.TrimSuffix(string(b), "\n")
+	if strings.Contains(text, "\n") {
+		return "", fmt.Errorf("value %q isn't a single line", value)
+	}
+	return text, nil
+}
+
+// resolveTag returns the tag a plain scalar resolves to.
+func resolveTag(value string) string {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &node); err != nil || len(node.Content) == 0 {
+		return "!!str"
+	}
+	return node.Content[0].ShortTag()
+}
+
+// Structural edits (adding and removing entries of block mappings and
+// sequences) insert or remove whole lines of the first document, so the rest
+// of the file is preserved as well. Flow style collections aren't supported.
+
+// documentSeparator matches separator lines of YAML documents.
+var documentSeparator = regexp.MustCompile(`^---(\s|$)`)
+
+// editYAMLDocuments edits each document of a (multi-document) YAML file
+// separately, so structural edits work on all documents. Separator lines
+// are kept, the edit gets the text between them (which may be empty).
+func editYAMLDocuments(content []byte, edit func(doc []byte) ([]byte, error)) ([]byte, error) {
+	var edited, doc []byte
+	flush := func() error {
+		text, err := edit(doc)
+		if err != nil {
+			return err
+		}
+		edited, doc = append(edited, text...), nil
+		return nil
+	}
+	for _, line := range splitLines(content) {
+		if !documentSeparator.Match(line) {
+			doc = append(doc, line...)
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		edited = append(edited, line...)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return edited, nil
+}
+
+// yamlRoot returns the root node of the first document.
+func yamlRoot(content []byte) (*yaml.Node, error) {
+	docs, err := parseYAMLDocuments(content)
+	if err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	if len(docs) == 0 || len(docs[0].Content) == 0 {
+		return nil, fmt.Errorf("empty yaml document")
+	}
+	root := docs[0].Content[0]
+	if root.Kind != yaml.MappingNode || root.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("root of the document isn't a block mapping")
+	}
+	return root, nil
+}
+
+// findYAMLEntry returns the key and value nodes of the mapping entry at a
+// key path (nil if there isn't any). Aliases aren't followed.
+func findYAMLEntry(root *yaml.Node, keyPath []string) (*yaml.Node, *yaml.Node) {
+	parent := findYAMLNode(root, keyPath[:len(keyPath)-1])
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if parent.Content[i].Value == keyPath[len(keyPath)-1] {
+			return parent.Content[i], parent.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// lastLine returns the last line of a node including its descendants.
+func lastLine(node *yaml.Node) int {
+	last := node.Line
+	if node.Kind == yaml.ScalarNode && node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		last += strings.Count(strings.TrimRight(node.Value, "\n"), "\n") + 1
+	}
+	for _, child := range node.Content {
+		if l := lastLine(child); l > last {
+			last = l
+		}
+	}
+	return last
+}
+
+// isNull tells whether a node is an empty value (without any text).
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.ShortTag() == "!!null" && node.Value == ""
+}
+
+// insertLines inserts lines after a given line (0 inserts them at the start).
+func insertLines(content []byte, after int, text []string) []byte {
+	lines := splitLines(content)
+	if after > len(lines) {
+		after = len(lines)
+	}
+	if after > 0 && !bytes.HasSuffix(lines[after-1], []byte("\n")) {
+		lines[after-1] = append(lines[after-1], '\n')
+	}
+	var inserted [][]byte
+	for _, t := range text {
+		inserted = append(inserted, []byte(t+"\n"))
+	}
+	edited := append(append(append([][]byte{}, lines[:after]...), inserted...), lines[after:]...)
+	return bytes.Join(edited, nil)
+}
+
+// removeLines removes lines from a line to another one (both included).
+func removeLines(content []byte, from, to int) []byte {
+	lines := splitLines(content)
+	return bytes.Join(append(append([][]byte{}, lines[:from-1]...), lines[to:]...), nil)
+}
+
+// indented returns lines prefixed by an indentation.
+func indented(indent int, lines []string) []string {
+	prefix := strings.Repeat(" ", indent)
+	result := make([]string, 0, len(lines))
+	for _, l := range lines {
+		result = append(result, prefix+l)
+	}
+	return result
+}
+
+// sequenceItem returns the lines of a block sequence item.
+func sequenceItem(item []string) []string {
+	lines := make([]string, 0, len(item))
+	for i, l := range item {
+		if i == 0 {
+			lines = append(lines, "- "+l)
+		} else {
+			lines = append(lines, "  "+l)
+		}
+	}
+	return lines
+}
+
+// plainText returns the YAML text of a string scalar.
+func plainText(value string) (string, error) {
+	return scalarText(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}, value)
+}
+
+// addYAMLEntry adds an entry (of the given value lines) to the end of the
+// block mapping at a key path. Its value lines are nested under its key.
+func addYAMLEntry(content []byte, keyPath []string, key string, value []string, nested bool) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	mapping := findYAMLNode(root, keyPath)
+	after, indent := 0, 0
+	switch {
+	case mapping == nil:
+		return nil, fmt.Errorf("%s not found", strings.Join(keyPath, "."))
+	case len(keyPath) > 0 && isNull(mapping):
+		// An empty value becomes a mapping.
+		keyNode, _ := findYAMLEntry(root, keyPath)
+		after, indent = keyNode.Line, keyNode.Column+1
+	case mapping.Kind != yaml.MappingNode:
+		return nil, fmt.Errorf("%s isn't a mapping", strings.Join(keyPath, "."))
+	case mapping.Style&yaml.FlowStyle != 0:
+		return nil, fmt.Errorf("%s: flow style mappings aren't supported", strings.Join(keyPath, "."))
+	default:
+		after, indent = lastLine(mapping), mapping.Content[0].Column-1
+	}
+	keyText, err := plainText(key)
+	if err != nil {
+		return nil, err
+	}
+	entry := []string{keyText + ":"}
+	if nested {
+		// Nested mappings are indented, sequences aren't (as kubectl does).
+		entry = append(entry, value...)
+	} else if len(value) > 0 {
+		entry[0] += " " + value[0]
+	}
+	return insertLines(content, after, indented(indent, entry)), nil
+}
+
+// setYAMLEntry sets a string scalar entry of the mapping at a key path. The
+// entry (and the mapping itself) is added if it's missing.
+func setYAMLEntry(content []byte, keyPath []string, key, value string) ([]byte, error) {
+	return setYAMLPath(content, append(append([]string{}, keyPath...), key), value, "!!str")
+}
+
+// setYAMLPath sets the scalar at a key path. Missing entries of the key path
+// (and their mappings) are added, the added scalar has the given tag if its
+// value resolves to it. An existing scalar keeps its type if it can.
+func setYAMLPath(content []byte, keyPath []string, value, tag string) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	if node := findYAMLNode(root, keyPath); node != nil {
+		if node.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s isn't a scalar value", strings.Join(keyPath, "."))
+		}
+		lines, err := replaceScalar(splitLines(content), node, value)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Join(lines, nil), nil
+	}
+
+	// Missing keys are added to the deepest existing mapping of the key path.
+	i := len(keyPath) - 1
+	for i > 0 && findYAMLNode(root, keyPath[:i]) == nil {
+		i--
+	}
+	valueText, err := scalarText(&yaml.Node{Kind: yaml.ScalarNode, Tag: tag}, value)
+	if err != nil {
+		return nil, err
+	}
+	if i == len(keyPath)-1 {
+		return addYAMLEntry(content, keyPath[:i], keyPath[i], []string{valueText}, false)
+	}
+	var nested []string
+	for j, key := range keyPath[i+1:] {
+		keyText, err := plainText(key)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.Repeat("  ", j+1) + keyText + ":"
+		if i+1+j == len(keyPath)-1 {
+			line += " " + valueText
+		}
+		nested = append(nested, line)
+	}
+	return addYAMLEntry(content, keyPath[:i], keyPath[i], nested, true)
+}
+
+// removeYAMLEntry removes an entry of a block mapping (if there's any).
+func removeYAMLEntry(content []byte, keyPath []string) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	keyNode, valueNode := findYAMLEntry(root, keyPath)
+	if keyNode == nil {
+		return content, nil
+	}
+	parent := findYAMLNode(root, keyPath[:len(keyPath)-1])
+	if parent.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("%s: flow style mappings aren't supported", strings.Join(keyPath, "."))
+	}
+	return removeLines(content, keyNode.Line, lastLine(valueNode)), nil
+}
+
+// appendYAMLItem appends an item (of the given lines) to the block sequence
+// at a key path. The sequence (and its missing mappings) is added if it's
+// missing.
+func appendYAMLItem(content []byte, keyPath []string, item []string) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	keyNode, seq := findYAMLEntry(root, keyPath)
+	switch {
+	case seq == nil:
+		i := len(keyPath) - 1
+		for i > 0 && findYAMLNode(root, keyPath[:i]) == nil {
+			i--
+		}
+		var nested []string
+		for j, key := range keyPath[i+1:] {
+			keyText, err := plainText(key)
+			if err != nil {
+				return nil, err
+			}
+			nested = append(nested, strings.Repeat("  ", j+1)+keyText+":")
+		}
+		nested = append(nested, indented(2*(len(keyPath)-1-i), sequenceItem(item))...)
+		return addYAMLEntry(content, keyPath[:i], keyPath[i], nested, true)
+	case isNull(seq):
+		return insertLines(content, keyNode.Line, indented(keyNode.Column-1, sequenceItem(item))), nil
+	case seq.Kind != yaml.SequenceNode:
+		return nil, fmt.Errorf("%s isn't a sequence", strings.Join(keyPath, "."))
+	case seq.Style&yaml.FlowStyle != 0:
+		return nil, fmt.Errorf("%s: flow style sequences aren't supported", strings.Join(keyPath, "."))
+	}
+	// New item is indented as the first one.
+	first := seq.Content[0]
+	line := splitLines(content)[first.Line-1]
+	indent := bytes.LastIndexByte(line[:first.Column-1], '-')
+	if indent < 0 {
+		return nil, fmt.Errorf("%s: item on line %d doesn't start with a dash", strings.Join(keyPath, "."), first.Line)
+	}
+	return insertLines(content, lastLine(seq), indented(indent, sequenceItem(item))), nil
+}
+
+// removeYAMLItem removes an item of the block sequence at a key path. The
+// sequence is removed as well if it was its only item.
+func removeYAMLItem(content []byte, keyPath []string, index int) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	keyNode, seq := findYAMLEntry(root, keyPath)
+	if seq == nil || seq.Kind != yaml.SequenceNode || index < 0 || index >= len(seq.Content) {
+		return nil, fmt.Errorf("%s.%d not found", strings.Join(keyPath, "."), index)
+	}
+	if seq.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("%s: flow style sequences aren't supported", strings.Join(keyPath, "."))
+	}
+	if len(seq.Content) == 1 {
+		return removeLines(content, keyNode.Line, lastLine(seq)), nil
+	}
+	item := seq.Content[index]
+	return removeLines(content, item.Line, lastLine(item)), nil
+}
+
+// setYAMLSequence sets the string items of the block sequence at a key path
+// (the sequence is added if it's missing). Lines of kept items (with their
+// comments) are moved, not rewritten.
+func setYAMLSequence(content []byte, keyPath []string, items []string) ([]byte, error) {
+	root, err := yamlRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	_, seq := findYAMLEntry(root, keyPath)
+	if seq == nil || isNull(seq) {
+		for _, item := range items {
+			text, err := plainText(item)
+			if err != nil {
+				return nil, err
+			}
+			if content, err = appendYAMLItem(content, keyPath, []string{text}); err != nil {
+				return nil, err
+			}
+		}
+		return content, nil
+	}
+	if seq.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s isn't a sequence", strings.Join(keyPath, "."))
+	}
+	if seq.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("%s: flow style sequences aren't supported", strings.Join(keyPath, "."))
+	}
+	if len(items) == 0 {
+		return removeYAMLEntry(content, keyPath)
+	}
+
+	// Lines of existing items by their value (an item's own comment lines
+	// above it belong to it, except the first item's).
+	lines := splitLines(content)
+	existing := map[string][]byte{}
+	from := seq.Content[0].Line
+	for _, item := range seq.Content {
+		to := lastLine(item)
+		if item.Kind == yaml.ScalarNode {
+			if _, ok := existing[item.Value]; !ok {
+				existing[item.Value] = bytes.Join(lines[from-1:to], nil)
+			}
+		}
+		from = to + 1
+	}
+	first := seq.Content[0]
+	indent := bytes.LastIndexByte(lines[first.Line-1][:first.Column-1], '-')
+	if indent < 0 {
+		return nil, fmt.Errorf("%s: item on line %d doesn't start with a dash", strings.Join(keyPath, "."), first.Line)
+	}
+
+	var edited [][]byte
+	for _, item := range items {
+		if text, ok := existing[item]; ok {
+			if !bytes.HasSuffix(text, []byte("\n")) {
+				text = append(text, '\n')
+			}
+			edited = append(edited, text)
+			continue
+		}
+		text, err := plainText(item)
+		if err != nil {
+			return nil, err
+		}
+		edited = append(edited, []byte(strings.Repeat(" ", indent)+"- "+text+"\n"))
+	}
+	end := lastLine(seq)
+	result := append(append(append([][]byte{}, lines[:first.Line-1]...), edited...), lines[end:]...)
+	return bytes.Join(result, nil), nil
+}
@@ -0,0 +1,149 @@
+package gitops
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var setYAMLScalarCases = map[string]struct {
+	content string
+	keyPath string
+	value   string
+	want    string
+	wantErr bool
+}{
+	"comments and key order are preserved": {
+		content: "# app\nimage:\n  repository: app # the image\n  tag: v1 # bumped by CI\nreplicas: 2\n",
+		keyPath: "image.tag",
+		value:   "v2",
+		want:    "# app\nimage:\n  repository: app # the image\n  tag: v2 # bumped by CI\nreplicas: 2\n",
+	},
+	"quote style is preserved": {
+		content: "tag: 'v1'\nother: \"x\"\n",
+		keyPath: "tag",
+		value:   "it's",
+		want:    "tag: 'it''s'\nother: \"x\"\n",
+	},
+	"string stays a string": {
+		content: "tag: v1\n",
+		keyPath: "tag",
+		value:   "1.20",
+		want:    "tag: \"1.20\"\n",
+	},
+	"int stays an int": {
+		content: "replicas: 2\n",
+		keyPath: "replicas",
+		value:   "3",
+		want:    "replicas: 3\n",
+	},
+	"list items are indexed": {
+		content: "containers:\n- name: app\n  image: app:v1\n- name: sidecar\n  image: sidecar:v1\n",
+		keyPath: "containers.1.image",
+		value:   "sidecar:v2",
+		want:    "containers:\n- name: app\n  image: app:v1\n- name: sidecar\n  image: sidecar:v2\n",
+	},
+	"anchored value is edited for all aliases": {
+		content: "base: &tag v1\napi:\n  tag: *tag\n",
+		keyPath: "api.tag",
+		value:   "v2",
+		want:    "base: &tag v2\napi:\n  tag: *tag\n",
+	},
+	"every document of a multi-document file is edited": {
+		content: "kind: A\nimage: a:v1\n---\nkind: B\n---\nkind: C\nimage: c:v1\n",
+		keyPath: "image",
+		value:   "x:v2",
+		want:    "kind: A\nimage: x:v2\n---\nkind: B\n---\nkind: C\nimage: x:v2\n",
+	},
+	"missing key (error)": {
+		content: "tag: v1\n",
+		keyPath: "image.tag",
+		value:   "v2",
+		wantErr: true,
+	},
+	"non-scalar value (error)": {
+		content: "image:\n  tag: v1\n",
+		keyPath: "image",
+		value:   "v2",
+		wantErr: true,
+	},
+	"block scalar (error)": {
+		content: "script: |\n  echo\n",
+		keyPath: "script",
+		value:   "v2",
+		wantErr: true,
+	},
+}
+
+func TestSetYAMLScalar(t *testing.T) {
+	for name, tc := range setYAMLScalarCases {
+		t.Run(name, func(t *testing.T) {
+			keyPath, err := parseKeyPath(tc.keyPath)
+			require.NoError(t, err, "parseKeyPath")
+			got, err := setYAMLScalar([]byte(tc.content), keyPath, tc.value)
+			if tc.wantErr {
+				require.Error(t, err, "setYAMLScalar")
+				return
+			}
+			require.NoError(t, err, "setYAMLScalar")
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestLookupYAMLScalar(t *testing.T) {
+	content := []byte("kind: A\n---\nimage:\n  tag: v3\n")
+	got, err := lookupYAMLScalar(content, []string{"image", "tag"})
+	require.NoError(t, err, "lookupYAMLScalar")
+	assert.Equal(t, "v3", got)
+
+	_, err = lookupYAMLScalar(content, []string{"image", "name"})
+	require.Error(t, err, "missing key")
+}
+
+var setYAMLSequenceCases = map[string]struct {
+	content string
+	items   []string
+	want    string
+	wantErr bool
+}{
+	"kept items keep their comments": {
+		content: "resources:\n  # the app\n  - deployment.yaml # app\n  # old one\n  - old.yaml\nimages: []\n",
+		items:   []string{"deployment.yaml", "ingress.yaml", "service.yaml"},
+		want:    "resources:\n  # the app\n  - deployment.yaml # app\n  - ingress.yaml\n  - service.yaml\nimages: []\n",
+	},
+	"items are reordered": {
+		content: "resources:\n- b.yaml # b\n- a.yaml\n",
+		items:   []string{"a.yaml", "b.yaml"},
+		want:    "resources:\n- a.yaml\n- b.yaml # b\n",
+	},
+	"missing sequence is added": {
+		content: "kind: Kustomization\n",
+		items:   []string{"a.yaml"},
+		want:    "kind: Kustomization\nresources:\n- a.yaml\n",
+	},
+	"empty sequence is removed": {
+		content: "resources:\n- a.yaml\nkind: Kustomization\n",
+		want:    "kind: Kustomization\n",
+	},
+	"flow style sequence (error)": {
+		content: "resources: [a.yaml]\n",
+		items:   []string{"b.yaml"},
+		wantErr: true,
+	},
+}
+
+func TestSetYAMLSequence(t *testing.T) {
+	for name, tc := range setYAMLSequenceCases {
+		t.Run(name, func(t *testing.T) {
+			got, err := setYAMLSequence([]byte(tc.content), []string{"resources"}, tc.items)
+			if tc.wantErr {
+				require.Error(t, err, "setYAMLSequence")
+				return
+			}
+			require.NoError(t, err, "setYAMLSequence")
+			assert.Equal(t, tc.want, string(got))
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package gitops
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// YAMLUpdate sets a value of an existing YAML file.
+type YAMLUpdate struct {
+	// File is the slash separated path relative to the repository root.
+	File string
+	// Document is the index of the only document updated in a
+	// multi-document file (nil means all documents which have the key).
+	Document *int
+	// KeyPath is the key path of the value.
+	KeyPath []string
+	// Value is the new value.
+	Value string
+}
+
+// ParseYAMLUpdates parses a list of `<file>[#<document>]:<key path>=<value>`
+// strings (e.g. `values.yaml:api-service.image.tag=v1.2.3`).
+func ParseYAMLUpdates(a []string) ([]YAMLUpdate, error) {
+	var updates []YAMLUpdate
+	for _, s := range a {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		colon := strings.Index(s, ":")
+		equals := strings.Index(s, "=")
+		if colon <= 0 || equals < colon {
+			return nil, fmt.Errorf("update %q: must be <file>:<key path>=<value>", s)
+		}
+		u := YAMLUpdate{File: strings.TrimSpace(s[:colon]), Value: s[equals+1:]}
+		if i := strings.LastIndex(u.File, "#"); i >= 0 {
+			doc, err := strconv.Atoi(u.File[i+1:])
+			if err != nil || doc < 0 {
+				return nil, fmt.Errorf("update %q: invalid document index %q", s, u.File[i+1:])
+			}
+			u.File, u.Document = u.File[:i], &doc
+		}
+		keyPath, err := parseKeyPath(strings.TrimSpace(s[colon+1 : equals]))
+		if err != nil {
+			return nil, fmt.Errorf("update %q: %w", s, err)
+		}
+		u.KeyPath = keyPath
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// YAMLUpdater sets values of existing YAML files in place (e.g. to bump the
+// image tag of hand-written manifests) instead of rendering templates.
+type YAMLUpdater struct {
+	// Root is the root folder of files (e.g. the local clone).
+	Root string
+	// Updates to apply in order.
+	Updates []YAMLUpdate
+}
+
+// YAMLUpdater implements the renderAllFileser interface.
+var _ renderAllFileser = (*YAMLUpdater)(nil)
+
+// renderAllFiles applies all updates and returns slash separated paths
+// of the updated files.
+func (yu YAMLUpdater) renderAllFiles() ([]string, error) {
+	updated := map[string]bool{}
+	for _, u := range yu.Updates {
+		name := fmt.Sprintf("%s:%s", u.File, strings.Join(u.KeyPath, "."))
+		filePath := filepath.Join(yu.Root, filepath.FromSlash(u.File))
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: read file: %w", name, err)
+		}
+		edited, err := setYAMLScalarOf(content, u.Document, u.KeyPath, u.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if err := ioutil.WriteFile(filePath, edited, 0644); err != nil {
+			return nil, fmt.Errorf("%s: write file: %w", name, err)
+		}
+		updated[filepath.ToSlash(filepath.Clean(u.File))] = true
+	}
+
+	files := make([]string, 0, len(updated))
+	for file := range updated {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Renderers render files by multiple renderers in order
+// (e.g. templates, then in-place updates).
+type Renderers []renderAllFileser
+
+// Renderers implements the renderAllFileser and changeDescriber interfaces.
+var (
+	_ renderAllFileser = (*Renderers)(nil)
+	_ changeDescriber  = (*Renderers)(nil)
+)
+
+func (rs Renderers) renderAllFiles() ([]string, error) {
+	var rendered []string
+	for _, r := range rs {
+		files, err := r.renderAllFiles()
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, files...)
+	}
+	return rendered, nil
+}
+
+// describeChange combines the descriptions of renderers which describe
+// their changes.
+func (rs Renderers) describeChange() (changeDescription, error) {
+	var description changeDescription
+	for _, r := range rs {
+		describer, ok := r.(changeDescriber)
+		if !ok {
+			continue
+		}
+		d, err := describer.describeChange()
+		if err != nil {
+			return changeDescription{}, err
+		}
+		if d.summary != "" {
+			description.summary = appendParagraph(description.summary, d.summary)
+		}
+		description.trailers = append(description.trailers, d.trailers...)
+	}
+	return description, nil
+}
@@ -0,0 +1,140 @@
+package gitops
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseYAMLUpdates(t *testing.T) {
+	doc := 1
+	updates, err := ParseYAMLUpdates([]string{
+		"values.yaml:api-service.image.tag=v1.2.3",
+		"",
+		"apps/api.yaml#1:metadata.labels.app\\.kubernetes\\.io/version=a=b",
+	})
+	require.NoError(t, err, "ParseYAMLUpdates")
+	assert.Equal(t, []YAMLUpdate{
+		{File: "values.yaml", KeyPath: []string{"api-service", "image", "tag"}, Value: "v1.2.3"},
+		{
+			File:     "apps/api.yaml",
+			Document: &doc,
+			KeyPath:  []string{"metadata", "labels", "app.kubernetes.io/version"},
+			Value:    "a=b",
+		},
+	}, updates)
+
+	for _, s := range []string{"values.yaml", "values.yaml:image.tag", "=v1", "values.yaml#x:tag=v1", "values.yaml:=v1"} {
+		_, err = ParseYAMLUpdates([]string{s})
+		require.Error(t, err, "invalid update %q", s)
+	}
+}
+
+var yamlUpdaterCases = map[string]struct {
+	updates   []string
+	wantFiles []string
+	want      map[string]string
+	wantErr   bool
+}{
+	"comments, anchors and other documents are preserved": {
+		updates:   []string{"apps/values.yaml:api-service.image.tag=v1.2.3"},
+		wantFiles: []string{"apps/values.yaml"},
+		want: map[string]string{
+			"apps/values.yaml": "# api\napi-service:\n  image:\n    repository: api\n    tag: &tag v1.2.3 # bumped by CI\nworker:\n  tag: *tag\n",
+			"apps/api.yaml":    "kind: Deployment\nimage: api:v1\n---\nkind: Service\n---\nkind: Job\nimage: api:v1\n",
+		},
+	},
+	"single document of a multi-document file": {
+		updates:   []string{"apps/api.yaml#2:image=api:v2", "apps/api.yaml#2:kind=CronJob"},
+		wantFiles: []string{"apps/api.yaml"},
+		want: map[string]string{
+			"apps/values.yaml": "# api\napi-service:\n  image:\n    repository: api\n    tag: &tag v1 # bumped by CI\nworker:\n  tag: *tag\n",
+			"apps/api.yaml":    "kind: Deployment\nimage: api:v1\n---\nkind: Service\n---\nkind: CronJob\nimage: api:v2\n",
+		},
+	},
+	"missing document (error)": {
+		updates: []string{"apps/api.yaml#3:image=api:v2"},
+		wantErr: true,
+	},
+	"missing file (error)": {
+		updates: []string{"apps/missing.yaml:image=api:v2"},
+		wantErr: true,
+	},
+}
+
+func TestYAMLUpdater(t *testing.T) {
+	for name, tc := range yamlUpdaterCases {
+		t.Run(name, func(t *testing.T) {
+			root := templatesDir(t, map[string]string{
+				"apps/values.yaml": "# api\napi-service:\n  image:\n    repository: api\n    tag: &tag v1 # bumped by CI\nworker:\n  tag: *tag\n",
+				"apps/api.yaml":    "kind: Deployment\nimage: api:v1\n---\nkind: Service\n---\nkind: Job\nimage: api:v1\n",
+			})
+			defer os.RemoveAll(root)
+			updates, err := ParseYAMLUpdates(tc.updates)
+			require.NoError(t, err, "ParseYAMLUpdates")
+
+			files, err := YAMLUpdater{Root: root, Updates: updates}.renderAllFiles()
+			if tc.wantErr {
+				require.Error(t, err, "renderAllFiles")
+				return
+			}
+			require.NoError(t, err, "renderAllFiles")
+			assert.Equal(t, tc.wantFiles, files, "updated files")
+			for file, want := range tc.want {
+				got, err := ioutil.ReadFile(path.Join(root, file))
+				require.NoError(t, err, "read %s", file)
+				assert.Equal(t, want, string(got), "content of %s", file)
+			}
+		})
+	}
+}
+
+func TestRenderers(t *testing.T) {
+	var calls []string
+	renderer := func(name string, files []string) renderAllFileser {
+		return &renderAllFileserMock{
+			renderAllFilesFunc: func() ([]string, error) {
+				calls = append(calls, name)
+				return files, nil
+			},
+		}
+	}
+	files, err := Renderers{
+		renderer("templates", []string{"sample/values.yaml"}),
+		renderer("updates", []string{"values.yaml"}),
+	}.renderAllFiles()
+	require.NoError(t, err, "renderAllFiles")
+	assert.Equal(t, []string{"templates", "updates"}, calls, "renderers in order")
+	assert.Equal(t, []string{"sample/values.yaml", "values.yaml"}, files)
+}
+
+func TestRenderersDescribeChange(t *testing.T) {
+	describer := func(summary string, trailers ...Trailer) renderAllFileser {
+		return struct {
+			*renderAllFileserMock
+			*changeDescriberMock
+		}{
+			&renderAllFileserMock{},
+			&changeDescriberMock{
+				describeChangeFunc: func() (changeDescription, error) {
+					return changeDescription{summary: summary, trailers: trailers}, nil
+				},
+			},
+		}
+	}
+	got, err := Renderers{
+		describer("### Promotion", Trailer{Key: "Gitops-Promoted-From", Value: "staging"}),
+		&renderAllFileserMock{},
+		describer(""),
+		describer("### Applications"),
+	}.describeChange()
+	require.NoError(t, err, "describeChange")
+	assert.Equal(t, changeDescription{
+		summary:  "### Promotion\n\n### Applications",
+		trailers: []Trailer{{Key: "Gitops-Promoted-From", Value: "staging"}},
+	}, got)
+}